@@ -2,15 +2,21 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/jbornemann/portscan/internal/cli"
-	"github.com/jbornemann/portscan/internal/net"
 	"github.com/spf13/cobra"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "pscli",
 	Short: "pscli is a client for issuing port scanning requests to a pscan server",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		//read from the environment here rather than as a flag default, so the key is never shown in help output
+		if len(cmdLineArgs.APIKey) == 0 {
+			cmdLineArgs.APIKey = os.Getenv("PSCAN_API_KEY")
+		}
+	},
 }
 
 var submitCmd = &cobra.Command{
@@ -19,7 +25,7 @@ var submitCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if request, err := cmdLineArgs.PrepareSubmitRequest(); err != nil {
 			return err
-		} else if err := cli.Submit(*request, net.DefaultHttpClient()); err != nil {
+		} else if err := cli.Submit(*request, cmdLineArgs.HttpClient()); err != nil {
 			return err
 		}
 		return nil
@@ -32,7 +38,110 @@ var queryCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if query, err := cmdLineArgs.PrepareQuery(); err != nil {
 			return err
-		} else if err := cli.DoQuery(*query, net.DefaultHttpClient()); err != nil {
+		} else if err := cli.DoQuery(*query, cmdLineArgs.HttpClient()); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "show ports opened, closed or changed between two scans of the same port",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if diff, err := cmdLineArgs.PrepareDiff(); err != nil {
+			return err
+		} else if err := cli.DoDiff(*diff, cmdLineArgs.HttpClient()); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "check a completed scan against the baseline of the pscan server, exiting 0 if it passed, 1 on violations and 2 otherwise",
+	Run: func(cmd *cobra.Command, args []string) {
+		//exits itself, as main does not turn errors into an exit code
+		code := cli.CheckError
+		if query, err := cmdLineArgs.PrepareQuery(); err != nil {
+			fmt.Println(err.Error())
+		} else if code, err = cli.DoCheck(*query, cmdLineArgs.HttpClient()); err != nil {
+			fmt.Println(err.Error())
+		}
+		os.Exit(code)
+	},
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "scan in process, without a pscan server, displaying results as query does",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if scan, err := cmdLineArgs.PrepareLocalScan(); err != nil {
+			return err
+		} else if err := cli.DoLocalScan(*scan); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "create, list, pause, resume and delete scans the pscan server runs on a cron schedule",
+}
+
+var scheduleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "schedule a scan, described as for submit, to run each time --cron comes due",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if create, err := cmdLineArgs.PrepareScheduleCreate(); err != nil {
+			return err
+		} else if err := cli.DoScheduleCreate(*create, cmdLineArgs.HttpClient()); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list scheduled scans and their last run, or with --id every run of one",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if list, err := cmdLineArgs.PrepareScheduleList(); err != nil {
+			return err
+		} else if err := cli.DoScheduleList(*list, cmdLineArgs.HttpClient()); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+//scheduleUpdateCmd returns the command making action to a scheduled scan
+func scheduleUpdateCmd(action, short string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   action,
+		Short: short,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if update, err := cmdLineArgs.PrepareScheduleUpdate(action); err != nil {
+				return err
+			} else if err := cli.DoScheduleUpdate(*update, cmdLineArgs.HttpClient()); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&cmdLineArgs.ScheduleID, "id", "", "id of the schedule")
+	return cmd
+}
+
+var serverInfoCmd = &cobra.Command{
+	Use:   "server-info",
+	Short: "display version, health and readiness of a pscan server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if info, err := cmdLineArgs.PrepareServerInfo(); err != nil {
+			return err
+		} else if err := cli.DoServerInfo(*info, cmdLineArgs.HttpClient()); err != nil {
 			return err
 		}
 		return nil
@@ -55,13 +164,62 @@ func init() {
 	//handle displaying error output through main(), avoid duplicate output
 	rootCmd.SilenceErrors = true
 
-	rootCmd.PersistentFlags().StringVar(&cmdLineArgs.Host, "host", "", "host of the pscan server")
+	rootCmd.PersistentFlags().StringVar(&cmdLineArgs.Host, "host", "", "host of the pscan server, e.g 10.0.0.1:8080, [::1]:8080, https://pscan.example.com or unix:///run/pscan.sock")
+	rootCmd.PersistentFlags().StringVar(&cmdLineArgs.APIKey, "api-key", "", "api key for the pscan server, defaults to $PSCAN_API_KEY")
+	rootCmd.PersistentFlags().StringVar(&cmdLineArgs.Traceparent, "traceparent", os.Getenv("TRACEPARENT"), "W3C traceparent to continue, defaults to $TRACEPARENT or a new trace")
 
-	submitCmd.Flags().StringSliceVar(&cmdLineArgs.ScanIPs, "ips", nil, "list of ips to scan from pscan server")
-	submitCmd.Flags().StringVar(&cmdLineArgs.ScanPort, "port", "", "port to scan from pscan server")
+	scanFlags(submitCmd, "pscan server")
+	submitCmd.Flags().StringVar(&cmdLineArgs.ScanSource, "source", "", "source profile of the pscan server to scan from, default is the server's default route")
 
 	queryCmd.Flags().StringVar(&cmdLineArgs.ScanID, "id", "", "id of port scan to query")
+	queryCmd.Flags().StringVar(&cmdLineArgs.QueryService, "service", "", "only show ports where a service whose name contains this was detected, e.g ssh")
+	queryCmd.Flags().StringVar(&cmdLineArgs.QueryProduct, "product", "", "only show ports where a product whose name contains this was detected, e.g nginx")
+
+	diffCmd.Flags().StringVar(&cmdLineArgs.DiffFrom, "from", "", "id of the earlier scan")
+	diffCmd.Flags().StringVar(&cmdLineArgs.DiffTo, "to", "", "id of the later scan")
+	diffCmd.Flags().BoolVar(&cmdLineArgs.OutputJSON, "json", false, "print the changes as json")
+
+	checkCmd.Flags().StringVar(&cmdLineArgs.ScanID, "id", "", "id of port scan to check")
+
+	scanFlags(scanCmd, "local scanner")
+	scanCmd.Flags().StringVar(&cmdLineArgs.LocalConcurrency, "concurrency", "", "most probes in flight at once (default 64)")
+	scanCmd.Flags().StringVar(&cmdLineArgs.LocalTimeout, "timeout", "", "how long each probe waits to connect, e.g 2s (default that of a pscan server)")
+	scanCmd.Flags().StringVar(&cmdLineArgs.LocalScriptDir, "script-dir", "", "directory of scripts --scripts may run, scripts are not run without it")
+	scanCmd.Flags().StringVar(&cmdLineArgs.QueryService, "service", "", "only show ports where a service whose name contains this was detected, e.g ssh")
+	scanCmd.Flags().StringVar(&cmdLineArgs.QueryProduct, "product", "", "only show ports where a product whose name contains this was detected, e.g nginx")
+
+	scanFlags(scheduleCreateCmd, "pscan server")
+	scheduleCreateCmd.Flags().StringVar(&cmdLineArgs.ScanSource, "source", "", "source profile of the pscan server to scan from, default is the server's default route")
+	scheduleCreateCmd.Flags().StringVar(&cmdLineArgs.ScheduleCron, "cron", "", "when to scan, in the server's time zone, as minute hour day-of-month month day-of-week e.g \"0 2 * * *\", or @hourly, @daily, @weekly or @monthly")
+	scheduleCreateCmd.Flags().StringVar(&cmdLineArgs.ScheduleName, "name", "", "optional description of the schedule, e.g \"nightly dmz sweep\"")
+	scheduleListCmd.Flags().StringVar(&cmdLineArgs.ScheduleID, "id", "", "id of a schedule to show every run of")
+	scheduleCmd.AddCommand(scheduleCreateCmd)
+	scheduleCmd.AddCommand(scheduleListCmd)
+	scheduleCmd.AddCommand(scheduleUpdateCmd(cli.SchedulePause, "stop a scheduled scan running until it is resumed"))
+	scheduleCmd.AddCommand(scheduleUpdateCmd(cli.ScheduleResume, "run a paused scheduled scan again, from its next time"))
+	scheduleCmd.AddCommand(scheduleUpdateCmd(cli.ScheduleDelete, "delete a scheduled scan, and the results of its runs"))
 
 	rootCmd.AddCommand(submitCmd)
 	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(scheduleCmd)
+	rootCmd.AddCommand(serverInfoCmd)
+}
+
+//scanFlags adds the flags describing what to scan, shared by scans submitted to a pscan server and those run in process
+func scanFlags(cmd *cobra.Command, from string) {
+	cmd.Flags().StringSliceVar(&cmdLineArgs.ScanIPs, "ips", nil, "list of ips, cidrs or hostnames to scan from the "+from)
+	cmd.Flags().StringVar(&cmdLineArgs.ScanPort, "port", "", "port to scan from the "+from)
+	cmd.Flags().StringVar(&cmdLineArgs.ScanProtocol, "protocol", "", "protocol to scan, tcp or udp (default tcp)")
+	cmd.Flags().StringVar(&cmdLineArgs.ScanTechnique, "technique", "", "how tcp ports are probed, connect or syn (half-open, needs linux and CAP_NET_RAW) (default connect)")
+	cmd.Flags().StringVar(&cmdLineArgs.ScanBanner, "banner", "", "collect what open tcp ports send on connect, passive or active (also prompts quiet services)")
+	cmd.Flags().BoolVar(&cmdLineArgs.ScanDetect, "detect", false, "identify the service, product and version on open tcp ports")
+	cmd.Flags().BoolVar(&cmdLineArgs.ScanTLS, "tls", false, "record the certificate and supported tls versions of open tcp ports that speak tls")
+	cmd.Flags().BoolVar(&cmdLineArgs.ScanHTTP, "http", false, "record the status, server, title, redirects and security headers of open tcp ports that speak http(s)")
+	cmd.Flags().BoolVar(&cmdLineArgs.ScanDiscover, "discover", false, "ping each host first, with icmp, tcp and on local subnets arp, and skip probing hosts that do not answer")
+	cmd.Flags().BoolVar(&cmdLineArgs.ScanProbeDown, "probe-down", false, "with --discover, probe hosts that did not answer anyway")
+	cmd.Flags().StringVar(&cmdLineArgs.ScanProbe, "probe", "", "prober registered with the "+from+" to check ports with, in place of a connect or udp probe")
+	cmd.Flags().StringSliceVar(&cmdLineArgs.ScanScripts, "scripts", nil, "scripts installed for the "+from+" to run against open ports, in turn, e.g ftp-anon,smb-os")
 }
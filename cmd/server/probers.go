@@ -0,0 +1,6 @@
+package main
+
+//Probers of your own, such as checks of an in-house handshake, are compiled into pscan by importing the packages
+//that register them with scanner.Register from their init functions, here. Scan requests then select them by name
+//
+//	import _ "example.com/acme/pscan-probers"
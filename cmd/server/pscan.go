@@ -13,21 +13,67 @@ import (
 var cmd = &cobra.Command{
 	Use:   "pscan",
 	Short: "pscan is a server for scanning ports",
+	Long: `pscan is a server for scanning ports
+
+Configuration is read, in increasing order of precedence, from defaults, the
+--config file, PSCAN_* environment variables (e.g PSCAN_LISTEN_PORT, with
+lists comma separated) and flags.
+
+Send SIGHUP, or POST /admin/reload, to re-read configuration. The log level,
+dial and drain timeouts, limits, api keys, policy and source profiles change
+without a restart.
+
+On SIGINT or SIGTERM, pscan stops accepting scans and waits up to the drain
+timeout for running scans. Scans still running are checkpointed to the job
+store, and resumed on next start. A second signal exits immediately.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if config, err := cmdLineArgs.ValidateAndPrepare(); err != nil {
+		if config, err := loadConfig(); err != nil {
 			return err
-		} else if server := server.NewServer(*config); server != nil {
-			sigCh := make(chan os.Signal)
+		} else if server := server.NewServer(*config); server == nil {
+			return fmt.Errorf("could not start server")
+		} else {
+			server.SetConfigLoader(loadConfig)
+			sigCh := make(chan os.Signal, 1)
 			killCh := make(chan bool)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			go server.Run(killCh)
-			<-sigCh
+			doneCh := make(chan struct{})
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+			go func() {
+				server.Run(killCh)
+				close(doneCh)
+			}()
+			for sig := range sigCh {
+				if sig == syscall.SIGHUP {
+					//failures are logged and counted by the server, which keeps its current configuration
+					_ = server.Reload()
+					continue
+				}
+				break
+			}
 			killCh <- true
+			//wait for running jobs to drain or checkpoint, unless told to stop again
+			for {
+				select {
+				case <-doneCh:
+					return nil
+				case sig := <-sigCh:
+					if sig != syscall.SIGHUP {
+						return fmt.Errorf("forced shutdown before jobs were drained")
+					}
+				}
+			}
 		}
-		return nil
 	},
 }
 
+//loadConfig reads flags, environment variables and the config file into a validated Configuration
+func loadConfig() (*server.Configuration, error) {
+	if cmdLineArgs, err := server.LoadCommandLineArgs(flagArgs, os.LookupEnv); err != nil {
+		return nil, err
+	} else {
+		return cmdLineArgs.ValidateAndPrepare()
+	}
+}
+
 func main() {
 	if err := cmd.Execute(); err != nil {
 		fmt.Println(err.Error())
@@ -35,9 +81,22 @@ func main() {
 }
 
 var (
-	cmdLineArgs = server.CommandLineArgs{}
+	//flagArgs holds only flags given explicitly, they are layered over the config file and environment
+	flagArgs = server.CommandLineArgs{}
 )
 
 func init() {
-	cmd.Flags().StringVar(&cmdLineArgs.ListenPort, "port", "8080", "port to listen for requests")
+	cmd.Flags().StringVar(&flagArgs.ConfigFile, "config", "", "path to a YAML config file")
+	cmd.Flags().StringVar(&flagArgs.ListenPort, "port", "", "port to listen for requests (default 8080)")
+	cmd.Flags().StringVar(&flagArgs.ListenAddress, "listen-address", "", "ip address to listen on, ipv4 or ipv6 (default all interfaces)")
+	cmd.Flags().StringSliceVar(&flagArgs.Listen, "listen", nil, "addresses to listen on instead of --listen-address and --port, e.g 127.0.0.1:8080,[::1]:8080,unix:///run/pscan.sock")
+	cmd.Flags().StringVar(&flagArgs.LogLevel, "log-level", "", "minimum level of log output (debug, info, warn, error) (default info)")
+	cmd.Flags().StringVar(&flagArgs.TraceExporter, "trace-exporter", "", "where to export trace spans (none, otlp, file) (default none)")
+	cmd.Flags().StringVar(&flagArgs.TraceEndpoint, "trace-endpoint", "", "OTLP/HTTP traces url, e.g http://localhost:4318/v1/traces")
+	cmd.Flags().StringVar(&flagArgs.TraceFile, "trace-file", "", "file to append trace spans to, one JSON object per line")
+	cmd.Flags().StringVar(&flagArgs.DialTimeout, "dial-timeout", "", "how long each probe waits for a connection (default 5s)")
+	cmd.Flags().StringVar(&flagArgs.DrainTimeout, "drain-timeout", "", "how long running scans are given to complete on shutdown (default 30s)")
+	cmd.Flags().StringVar(&flagArgs.MaxConcurrentProbes, "max-concurrent-probes", "", "maximum dials in progress across all scans (default 512)")
+	cmd.Flags().StringVar(&flagArgs.StorageType, "storage", "", "where scan results are kept (memory, file) (default memory)")
+	cmd.Flags().StringVar(&flagArgs.StoragePath, "storage-path", "", "file scan results are kept in, for file storage")
 }
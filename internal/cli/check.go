@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//Exit codes of pscli check
+const (
+	//CheckPassed is a scan with no baseline violations
+	CheckPassed = 0
+	//CheckViolations is a scan with at least one baseline violation
+	CheckViolations = 1
+	//CheckError is a scan that could not be checked, e.g it is not ready, or no baseline rule applies to its addresses
+	CheckError = 2
+)
+
+//DoCheck will display the baseline violations of a completed scan, with the given Client
+//the returned code is one of CheckPassed, CheckViolations or CheckError. An error is returned with CheckError only
+//the client passed may not be nil
+func DoCheck(q Query, client *http.Client) (int, error) {
+	var resp types.QueryResponse
+	if err, statusCode := doPost(client, q.Host.String(), "application/json", q.callOptions, q.QueryRequest, &resp); err != nil {
+		return CheckError, err
+	} else if statusCode == http.StatusNotFound {
+		return CheckError, fmt.Errorf("scan id %d is not a known id", q.ScanID)
+	} else if statusCode != http.StatusOK {
+		return CheckError, fmt.Errorf("could not query scan, status was %d", statusCode)
+	} else if !resp.Ready {
+		return CheckError, fmt.Errorf("scan %d is not yet ready", q.ScanID)
+	} else if resp.Baseline == nil {
+		return CheckError, fmt.Errorf("scan %d was not evaluated against a baseline, the pscan server had none configured when it completed", q.ScanID)
+	} else if resp.Baseline.Evaluated == 0 {
+		return CheckError, fmt.Errorf("scan %d was not checked, no baseline rule applies to the addresses it scanned", q.ScanID)
+	}
+
+	fmt.Println(formatCheckHeader(resp))
+	for _, violation := range resp.Baseline.Violations {
+		fmt.Println(formatViolation(violation, resp.ScanPort))
+	}
+	if !resp.Baseline.Passed {
+		return CheckViolations, nil
+	}
+	return CheckPassed, nil
+}
+
+//formatCheckHeader describes the outcome of evaluating a scan against the baseline
+func formatCheckHeader(resp types.QueryResponse) string {
+	b := resp.Baseline
+	if b.Passed {
+		return fmt.Sprintf("%s passed the baseline, %d addresses evaluated", formatHeader(resp), b.Evaluated)
+	}
+	return fmt.Sprintf("%s failed the baseline, %d violations of %d addresses evaluated", formatHeader(resp), len(b.Violations), b.Evaluated)
+}
+
+//formatViolation describes one violation, e.g 10.0.0.1:80 violates rule web: tcp port 80 is open, but is not allowed
+func formatViolation(v types.BaselineViolation, port uint) string {
+	line := net.JoinHostPort(v.IP, strconv.FormatUint(uint64(port), 10))
+	if len(v.Target) > 0 {
+		line = fmt.Sprintf("%s (%s)", line, v.Target)
+	}
+	return fmt.Sprintf("%s violates rule %s: %s", line, v.Rule, v.Reason)
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoCheck(t *testing.T) {
+	responses := map[uint64]types.QueryResponse{
+		1: {Ready: true, ScanPort: 443, Baseline: &types.BaselineResult{Passed: true, Evaluated: 2}},
+		2: {Ready: true, ScanPort: 443, Baseline: &types.BaselineResult{Evaluated: 2, Violations: []types.BaselineViolation{
+			{IP: "10.0.0.1", Rule: "web", State: types.CLOSED, Reason: "tcp port 443 is required to be open, but is closed"},
+		}}},
+		3: {Ready: false},
+		4: {Ready: true, ScanPort: 443},
+		5: {Ready: true, ScanPort: 443, Baseline: &types.BaselineResult{}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req types.QueryRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		resp, found := responses[req.ScanID]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&resp)
+	}))
+	defer server.Close()
+	host, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   uint64
+		code int
+		err  string
+	}{
+		{1, CheckPassed, ""},
+		{2, CheckViolations, ""},
+		{3, CheckError, "scan 3 is not yet ready"},
+		{4, CheckError, "scan 4 was not evaluated against a baseline, the pscan server had none configured when it completed"},
+		{5, CheckError, "scan 5 was not checked, no baseline rule applies to the addresses it scanned"},
+		{9, CheckError, "scan id 9 is not a known id"},
+	}
+	for _, tt := range tests {
+		code, err := DoCheck(Query{Host: *host, QueryRequest: types.QueryRequest{ScanID: tt.id}}, server.Client())
+		assert.Equal(t, tt.code, code)
+		if len(tt.err) > 0 {
+			assert.EqualError(t, err, tt.err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestFormatViolation(t *testing.T) {
+	assert.Equal(t, "results of scan of port 443 failed the baseline, 1 violations of 3 addresses evaluated", formatCheckHeader(types.QueryResponse{ScanPort: 443, Baseline: &types.BaselineResult{
+		Evaluated: 3, Violations: []types.BaselineViolation{{}},
+	}}))
+	assert.Equal(t, "results of scan of udp port 53 passed the baseline, 2 addresses evaluated", formatCheckHeader(types.QueryResponse{ScanPort: 53, Protocol: types.UDP, Baseline: &types.BaselineResult{
+		Passed: true, Evaluated: 2,
+	}}))
+	assert.Equal(t, "[2001:db8::1]:80 (web.example.com) violates rule web: tcp port 80 is open, but is not allowed", formatViolation(types.BaselineViolation{
+		IP: "2001:db8::1", Target: "web.example.com", Rule: "web", State: types.OPEN, Reason: "tcp port 80 is open, but is not allowed",
+	}, 80))
+}
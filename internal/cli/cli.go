@@ -3,43 +3,113 @@ package cli
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/internal/trace"
 	"github.com/jbornemann/portscan/pkg/types"
 )
 
 const (
 	defaultScheme = "http"
+	unixPrefix    = "unix://"
+	//unixHost is sent as the Host of calls made over a unix socket, which have no host of their own
+	unixHost = "localhost"
 )
 
 //CommandLineArgs represent direct, unmodified arguments received by the CLI
 type CommandLineArgs struct {
+	//Host is a host[:port], http(s) url, or unix:///path/to/socket of a pscan server
 	Host string
+	//Traceparent is an optional W3C traceparent to continue. If empty, a new trace is started
+	Traceparent string
+	//APIKey is sent to pscan servers that require authentication
+	APIKey string
 
-	ScanIPs  []string
-	ScanPort string
+	ScanIPs      []string
+	ScanPort     string
+	ScanProtocol string
+	ScanBanner   string
+	//ScanTechnique is connect or syn, for tcp scans
+	ScanTechnique string
+	//Source optionally names a source profile of the pscan server to scan from
+	ScanSource string
+	ScanDetect bool
+	ScanTLS    bool
+	ScanHTTP   bool
+	//ScanDiscover pings hosts before probing them, and ScanProbeDown probes those found down anyway
+	ScanDiscover  bool
+	ScanProbeDown bool
+	//ScanProbe optionally names a prober registered with the pscan server, to check ports with
+	ScanProbe string
+	//ScanScripts optionally name scripts installed on the pscan server, to run against open ports
+	ScanScripts []string
+
+	//LocalConcurrency, LocalTimeout and LocalScriptDir configure scans run in process, in place of the limits,
+	//dial timeout and script directory of a pscan server
+	LocalConcurrency string
+	LocalTimeout     string
+	LocalScriptDir   string
+
+	//ScheduleName and ScheduleCron describe a schedule to create, and ScheduleID names one to list, pause, resume or delete
+	ScheduleName string
+	ScheduleCron string
+	ScheduleID   string
+
+	//DiffFrom and DiffTo are the ids of the scans to compare
+	DiffFrom string
+	DiffTo   string
+	//OutputJSON prints responses as JSON, for other programs to read
+	OutputJSON bool
 
 	ScanID string
+	//QueryService and QueryProduct filter query results to ports where a matching service was detected
+	QueryService string
+	QueryProduct string
 }
 
 //SubmitRequest represents all of the information the CLI needs to execute a port scan request
 type SubmitRequest struct {
 	Host url.URL
+	callOptions
 	types.ScanRequest
 }
 
 //Query represents the information needed to query an existing scan
 type Query struct {
 	Host url.URL
+	callOptions
 	types.QueryRequest
 }
 
+//ServerInfo represents the information needed to describe a pscan server
+type ServerInfo struct {
+	Host url.URL
+	callOptions
+}
+
+//callOptions are sent as headers on every call to a pscan server
+type callOptions struct {
+	Trace  trace.SpanContext
+	APIKey string
+}
+
+func (o callOptions) apply(h http.Header) {
+	trace.Inject(o.Trace, h)
+	if len(o.APIKey) > 0 {
+		h.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+	}
+}
+
 //PrepareSubmitRequest will ensure that the CommandLineArgs received are well-formed, and valid for this request.
 //If so it will return a SubmitRequest
 //If the arguments can not be validated, an error will returned, along with a nil SubmitRequest
@@ -53,6 +123,24 @@ func (c CommandLineArgs) PrepareSubmitRequest() (*SubmitRequest, error) {
 		request.Host = *host
 	}
 
+	if sc, err := c.traceContext(); err != nil {
+		return nil, err
+	} else {
+		request.Trace = sc
+	}
+	request.APIKey = c.APIKey
+
+	if scanRequest, err := c.scanRequest(); err != nil {
+		return nil, err
+	} else {
+		request.ScanRequest = *scanRequest
+	}
+
+	return request, nil
+}
+
+//scanRequest returns the ScanRequest the scan arguments describe, or an error if they are not valid
+func (c CommandLineArgs) scanRequest() (*types.ScanRequest, error) {
 	if c.ScanIPs == nil {
 		return nil, fmt.Errorf("you must provide a list of ips to scan")
 	}
@@ -62,18 +150,26 @@ func (c CommandLineArgs) PrepareSubmitRequest() (*SubmitRequest, error) {
 	} else if port, err := strconv.ParseUint(c.ScanPort, 10, 32); err != nil {
 		return nil, fmt.Errorf("%s is not a valid port to scan", c.ScanPort)
 	} else {
-		scanRequest := types.ScanRequest{
-			ScanIPs:  c.ScanIPs,
-			ScanPort: uint(port),
+		scanRequest := &types.ScanRequest{
+			ScanIPs:   c.ScanIPs,
+			ScanPort:  uint(port),
+			Protocol:  c.ScanProtocol,
+			Technique: c.ScanTechnique,
+			Banner:    c.ScanBanner,
+			Source:    c.ScanSource,
+			Detect:    c.ScanDetect,
+			TLS:       c.ScanTLS,
+			HTTP:      c.ScanHTTP,
+			Discover:  c.ScanDiscover,
+			ProbeDown: c.ScanProbeDown,
+			Probe:     c.ScanProbe,
+			Scripts:   c.ScanScripts,
 		}
 		if valid, err := scanRequest.Validate(); !valid {
 			return nil, err
-		} else {
-			request.ScanRequest = scanRequest
 		}
+		return scanRequest, nil
 	}
-
-	return request, nil
 }
 
 //PrepareQuery will transform command line arguments into a Query, given that the correct arguments were set and that they are valid
@@ -88,6 +184,13 @@ func (c CommandLineArgs) PrepareQuery() (*Query, error) {
 		query.Host = *host
 	}
 
+	if sc, err := c.traceContext(); err != nil {
+		return nil, err
+	} else {
+		query.Trace = sc
+	}
+	query.APIKey = c.APIKey
+
 	if len(c.ScanID) == 0 {
 		return nil, fmt.Errorf("you must provide an scan id to query")
 	} else if id, err := strconv.ParseUint(c.ScanID, 10, 64); err != nil {
@@ -95,17 +198,61 @@ func (c CommandLineArgs) PrepareQuery() (*Query, error) {
 	} else {
 		query.ScanID = id
 	}
+	query.Service = c.QueryService
+	query.Product = c.QueryProduct
 
 	return query, nil
 }
 
+//PrepareServerInfo will transform command line arguments into a ServerInfo, given that a valid host was set
+//If arguments are not valid for this request, an error will be returned with a nil ServerInfo
+func (c CommandLineArgs) PrepareServerInfo() (*ServerInfo, error) {
+	info := &ServerInfo{}
+
+	if host, err := parseHostString(c.Host); err != nil {
+		return nil, err
+	} else {
+		host.Path = ""
+		info.Host = *host
+	}
+
+	if sc, err := c.traceContext(); err != nil {
+		return nil, err
+	} else {
+		info.Trace = sc
+	}
+	info.APIKey = c.APIKey
+
+	return info, nil
+}
+
+//HttpClient returns a client able to reach Host, dialing the socket of unix:// hosts
+func (c CommandLineArgs) HttpClient() *http.Client {
+	if strings.HasPrefix(c.Host, unixPrefix) {
+		return pnet.UnixSocketHttpClient(strings.TrimPrefix(c.Host, unixPrefix))
+	}
+	return pnet.DefaultHttpClient()
+}
+
+//traceContext returns the span context of this call, a new span within the trace of Traceparent if given, or
+//else the root of a new trace
+func (c CommandLineArgs) traceContext() (trace.SpanContext, error) {
+	if len(c.Traceparent) == 0 {
+		return trace.NewRootContext(), nil
+	} else if sc, err := trace.ParseTraceparent(c.Traceparent); err != nil {
+		return trace.SpanContext{}, fmt.Errorf("traceparent is not valid")
+	} else {
+		return trace.NewChildContext(sc), nil
+	}
+}
+
 //Submit will process a CLI submit request, with the given Client
 //the client passed may not be nil
 func Submit(r SubmitRequest, client *http.Client) error {
 	scanReq := r.ScanRequest
 
 	var resp types.ScanResponse
-	if err, statusCode := doPost(client, r.Host.String(), "application/json", scanReq, &resp); err != nil {
+	if err, statusCode := doPost(client, r.Host.String(), "application/json", r.callOptions, scanReq, &resp); err != nil {
 		return err
 	} else {
 		if statusCode != http.StatusOK {
@@ -124,7 +271,7 @@ func DoQuery(q Query, client *http.Client) error {
 	req := q.QueryRequest
 
 	var resp types.QueryResponse
-	if err, statusCode := doPost(client, q.Host.String(), "application/json", req, &resp); err != nil {
+	if err, statusCode := doPost(client, q.Host.String(), "application/json", q.callOptions, req, &resp); err != nil {
 		return err
 	} else {
 		if statusCode == http.StatusNotFound {
@@ -132,9 +279,9 @@ func DoQuery(q Query, client *http.Client) error {
 		} else if !resp.Ready {
 			fmt.Printf("scan %v is not yet ready\n", q.ScanID)
 		} else {
-			fmt.Printf("results of scan of port %d\n", resp.ScanPort)
-			for _, status := range resp.Status {
-				fmt.Printf("ip %s in state %s\n", status.IP, status.State)
+			fmt.Println(formatHeader(resp))
+			for _, status := range sortStatuses(resp.Status) {
+				fmt.Println(formatStatus(status, resp.ScanPort))
 			}
 		}
 	}
@@ -142,20 +289,222 @@ func DoQuery(q Query, client *http.Client) error {
 	return nil
 }
 
-func doPost(client *http.Client, endpoint, contentType string, in interface{}, out interface{}) (error, int) {
+//formatHeader describes the scan a QueryResponse holds results of
+func formatHeader(resp types.QueryResponse) string {
+	header := fmt.Sprintf("results of scan of port %d", resp.ScanPort)
+	if resp.Protocol == types.UDP {
+		header = fmt.Sprintf("results of scan of udp port %d", resp.ScanPort)
+	}
+	if len(resp.Source) > 0 {
+		header = fmt.Sprintf("%s from source %s", header, resp.Source)
+	}
+	return header
+}
+
+//sortStatuses returns statuses ordered by address, ipv4 addresses before ipv6
+func sortStatuses(statuses []types.IPStatus) []types.IPStatus {
+	sorted := append([]types.IPStatus(nil), statuses...)
+	key := func(s types.IPStatus) []byte {
+		ip, _ := pnet.ParseIP(s.IP)
+		if ip4 := ip.To4(); ip4 != nil {
+			return append([]byte{4}, ip4...)
+		}
+		return append([]byte{6}, ip.To16()...)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(key(sorted[i]), key(sorted[j])) < 0
+	})
+	return sorted
+}
+
+//formatStatus describes one result, with ipv6 addresses bracketed so they are not confused with the port
+func formatStatus(status types.IPStatus, port uint) string {
+	line := fmt.Sprintf("%s in state %s", net.JoinHostPort(status.IP, strconv.FormatUint(uint64(port), 10)), status.State)
+	if len(status.Target) > 0 {
+		line = fmt.Sprintf("%s (%s)", line, status.Target)
+	}
+	if len(status.Host) > 0 {
+		line = fmt.Sprintf("%s host: %s", line, formatHost(status))
+	}
+	if status.Service != nil {
+		line = fmt.Sprintf("%s service: %s", line, formatService(*status.Service))
+	}
+	if status.TLS != nil {
+		line = fmt.Sprintf("%s tls: %s", line, formatTLS(*status.TLS))
+	}
+	if status.HTTP != nil {
+		line = fmt.Sprintf("%s http: %s", line, formatHTTP(*status.HTTP))
+	}
+	if len(status.Banner) > 0 {
+		//banners are already escaped to printable ascii by the server
+		line = fmt.Sprintf("%s banner: %s", line, status.Banner)
+	}
+	for _, script := range status.Scripts {
+		line = fmt.Sprintf("%s script %s: %s", line, script.Name, formatScript(script))
+	}
+	return line
+}
+
+//formatScript describes what a script printed, e.g {"anonymous":true}, or why it failed, e.g failed (exit status 1)
+func formatScript(s types.ScriptResult) string {
+	if len(s.Error) > 0 {
+		return fmt.Sprintf("failed (%s)", s.Error)
+	} else if len(s.Output) == 0 {
+		return "no output"
+	}
+	return string(s.Output)
+}
+
+//formatHost describes what discovery found of a host, e.g up (icmp echo) mac 02:42:ac:11:00:02
+func formatHost(status types.IPStatus) string {
+	line := string(status.Host)
+	if len(status.HostReason) > 0 {
+		line = fmt.Sprintf("%s (%s)", line, status.HostReason)
+	}
+	if len(status.MAC) > 0 {
+		line = fmt.Sprintf("%s mac %s", line, status.MAC)
+	}
+	return line
+}
+
+//formatService describes a detected service, e.g ssh OpenSSH 8.9p1 (protocol 2.0)
+func formatService(s types.Service) string {
+	parts := []string{s.Name}
+	for _, part := range []string{s.Product, s.Version} {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	if len(s.Info) > 0 {
+		parts = append(parts, fmt.Sprintf("(%s)", s.Info))
+	}
+	return strings.Join(parts, " ")
+}
+
+//formatTLS describes what a tls handshake found, e.g TLS 1.3 TLS_AES_128_GCM_SHA256 (supports TLS 1.2, TLS 1.3)
+//cert CN=example.com expires 2030-01-01 RSA 2048
+func formatTLS(t types.TLSInfo) string {
+	line := fmt.Sprintf("%s %s (supports %s)", t.Version, t.CipherSuite, strings.Join(t.Versions, ", "))
+	if c := t.Certificate; c != nil {
+		line = fmt.Sprintf("%s cert %s expires %s %s %d", line, c.Subject, c.NotAfter.Format("2006-01-02"), c.KeyType, c.KeyBits)
+		if c.SelfSigned {
+			line += " self signed"
+		}
+	}
+	return line
+}
+
+//formatHTTP describes an http fingerprint, e.g 200 https://example.com/home server nginx title "Home"
+func formatHTTP(h types.HTTPInfo) string {
+	line := fmt.Sprintf("%d %s", h.StatusCode, h.URL)
+	if len(h.Server) > 0 {
+		line = fmt.Sprintf("%s server %s", line, h.Server)
+	}
+	if len(h.Title) > 0 {
+		line = fmt.Sprintf("%s title %q", line, h.Title)
+	}
+	if len(h.Redirects) > 0 {
+		line = fmt.Sprintf("%s via %s", line, strings.Join(h.Redirects, " -> "))
+	}
+	return line
+}
+
+//DoServerInfo will display the version, health and readiness of a pscan server, with the given Client
+//the client passed may not be nil
+func DoServerInfo(i ServerInfo, client *http.Client) error {
+	endpoint := func(path string) string {
+		host := i.Host
+		host.Path = path
+		return host.String()
+	}
+
+	var version types.VersionResponse
+	if err, statusCode := doGet(client, endpoint("/version"), i.callOptions, &version); err != nil {
+		return err
+	} else if statusCode != http.StatusOK {
+		return fmt.Errorf("could not get version of pscan server, status was %d", statusCode)
+	}
+
+	var health types.HealthResponse
+	if err, _ := doGet(client, endpoint("/healthz"), i.callOptions, &health); err != nil {
+		return err
+	}
+
+	var ready types.ReadinessResponse
+	if err, _ := doGet(client, endpoint("/readyz"), i.callOptions, &ready); err != nil {
+		return err
+	}
+
+	fmt.Printf("pscan server %s\n", i.Host.String())
+	fmt.Printf("version %s (commit %s, built %s, %s)\n", version.Version, version.Commit, version.BuildDate, version.GoVersion)
+	fmt.Printf("health %s\n", health.Status)
+	fmt.Printf("ready %t\n", ready.Ready)
+	for _, check := range ready.Checks {
+		state := "ok"
+		if !check.OK {
+			state = "failing"
+		}
+		if len(check.Message) > 0 {
+			fmt.Printf("  %s %s (%s)\n", check.Name, state, check.Message)
+		} else {
+			fmt.Printf("  %s %s\n", check.Name, state)
+		}
+	}
+
+	return nil
+}
+
+//doGet decodes any JSON body returned, as health endpoints describe failures in the body of non-200 responses
+func doGet(client *http.Client, endpoint string, opts callOptions, out interface{}) (error, int) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request to pscan server, error was: %s", err.Error()), -1
+	}
+	opts.apply(req.Header)
+	if resp, err := client.Do(req); err != nil {
+		return fmt.Errorf("could not make call to pscan server, error was: %s", err.Error()), -1
+	} else {
+		defer resp.Body.Close()
+		bs, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("could not read body from pscan server, error was: %s", err.Error()), resp.StatusCode
+		}
+		if err := json.Unmarshal(bs, &out); err != nil && resp.StatusCode == http.StatusOK {
+			return fmt.Errorf("unexpected response body from pscan server, error was: %s", err.Error()), resp.StatusCode
+		}
+		return nil, resp.StatusCode
+	}
+}
+
+func doPost(client *http.Client, endpoint, contentType string, opts callOptions, in interface{}, out interface{}) (error, int) {
 	bs, err := json.Marshal(&in)
 	if err != nil {
 		return fmt.Errorf("bug! could not marshal request, error was: %s", err.Error()), -1
 	}
-	buf := bytes.NewBuffer(bs)
-	if resp, err := client.Post(endpoint, contentType, buf); err != nil {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(bs))
+	if err != nil {
+		return fmt.Errorf("could not create request to pscan server, error was: %s", err.Error()), -1
+	}
+	req.Header.Set("Content-Type", contentType)
+	opts.apply(req.Header)
+	if resp, err := client.Do(req); err != nil {
 		return fmt.Errorf("could not make call to pscan server, error was: %s", err.Error()), -1
 	} else {
 		bs, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return fmt.Errorf("could not read body from pscan server, error was: %s", err.Error()), resp.StatusCode
 		}
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("pscan server requires a valid api key"), resp.StatusCode
+		} else if resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("pscan server refused the request: %s", string(bs)), resp.StatusCode
+		} else if resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("pscan server is limiting submissions, try again shortly"), resp.StatusCode
+		} else if resp.StatusCode == http.StatusBadRequest && len(bs) > 0 {
+			return fmt.Errorf("pscan server rejected the request: %s", string(bs)), resp.StatusCode
+		} else if resp.StatusCode == http.StatusNotFound && len(bs) > 0 {
+			return errors.New(string(bs)), resp.StatusCode
+		} else if resp.StatusCode != http.StatusOK {
 			return nil, resp.StatusCode
 		}
 
@@ -171,7 +520,14 @@ func parseHostString(hostString string) (*url.URL, error) {
 		return nil, fmt.Errorf("you must provide a pscan server host")
 	}
 
-	if !strings.HasPrefix(hostString, "https://") || strings.HasPrefix(hostString, "http://") {
+	if strings.HasPrefix(hostString, unixPrefix) {
+		if len(strings.TrimPrefix(hostString, unixPrefix)) == 0 {
+			return nil, fmt.Errorf("pscan server socket path must be given, e.g unix:///run/pscan.sock")
+		}
+		return &url.URL{Scheme: defaultScheme, Host: unixHost}, nil
+	}
+
+	if !strings.HasPrefix(hostString, "https://") && !strings.HasPrefix(hostString, "http://") {
 		hostString = fmt.Sprintf("%s://%s", defaultScheme, hostString)
 	}
 
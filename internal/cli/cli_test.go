@@ -2,13 +2,18 @@ package cli
 
 import (
 	"encoding/json"
+	"github.com/jbornemann/portscan/internal/trace"
 	"github.com/jbornemann/portscan/pkg/types"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -82,6 +87,53 @@ func TestCommandLineArgs_PrepareSubmitRequest(t *testing.T) {
 	assert.NotNil(t, req)
 }
 
+func TestParseHostString(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{"https://myserver.com", "https://myserver.com"},
+		{"[::1]:8080", "http://[::1]:8080"},
+		{"unix:///run/pscan.sock", "http://localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			host, err := parseHostString(tt.host)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, host.String())
+		})
+	}
+	_, err := parseHostString("unix://")
+	assert.EqualError(t, err, "pscan server socket path must be given, e.g unix:///run/pscan.sock")
+}
+
+func TestServerInfo_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pscan.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := json.Marshal(types.VersionResponse{Version: "1.0.0"})
+		_, _ = w.Write(bs)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	args := CommandLineArgs{Host: "unix://" + path}
+	info, err := args.PrepareServerInfo()
+	assert.Nil(t, err)
+	assert.Nil(t, DoServerInfo(*info, args.HttpClient()))
+}
+
 func TestCommandLineArgs_PrepareQuery_MustProvideAHost(t *testing.T) {
 	c := CommandLineArgs{
 		ScanID: "123",
@@ -210,3 +262,181 @@ func TestQuery(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, called)
 }
+
+func TestCommandLineArgs_PrepareQuery_TraceparentMustBeValid(t *testing.T) {
+	c := CommandLineArgs{
+		Host:        "127.0.0.1",
+		ScanID:      "123",
+		Traceparent: "junk",
+	}
+	req, err := c.PrepareQuery()
+	assert.Nil(t, req)
+	assert.EqualError(t, err, "traceparent is not valid")
+
+	c.Traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err = c.PrepareQuery()
+	assert.Nil(t, err)
+	//the call is a span of its own, within the given trace
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", req.Trace.TraceID.String())
+	assert.NotEqual(t, "00f067aa0ba902b7", req.Trace.SpanID.String())
+	assert.True(t, req.Trace.Sampled)
+
+	c.Traceparent = ""
+	req, err = c.PrepareQuery()
+	assert.Nil(t, err)
+	assert.True(t, req.Trace.IsValid())
+}
+
+func TestQuery_PropagatesTraceparent(t *testing.T) {
+	sc := trace.NewRootContext()
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	thisUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := Query{
+		Host:         *thisUrl,
+		callOptions:  callOptions{Trace: sc},
+		QueryRequest: types.QueryRequest{ScanID: 123},
+	}
+	assert.Nil(t, DoQuery(q, server.Client()))
+	assert.Equal(t, sc.Traceparent(), got)
+}
+
+func TestCommandLineArgs_PrepareServerInfo_MustProvideAHost(t *testing.T) {
+	c := CommandLineArgs{}
+	info, err := c.PrepareServerInfo()
+	assert.Nil(t, info)
+	assert.EqualError(t, err, "you must provide a pscan server host")
+}
+
+func TestServerInfo(t *testing.T) {
+	paths := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body interface{}
+		switch r.URL.Path {
+		case "/version":
+			body = types.VersionResponse{Version: "1.0.0"}
+		case "/healthz":
+			body = types.HealthResponse{Status: "ok"}
+		case "/readyz":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			body = types.ReadinessResponse{Ready: false, Checks: []types.ReadinessCheck{{Name: "queue", OK: false}}}
+		}
+		bs, _ := json.Marshal(body)
+		_, _ = w.Write(bs)
+	}))
+	defer server.Close()
+
+	thisUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := ServerInfo{Host: *thisUrl}
+	assert.Nil(t, DoServerInfo(info, server.Client()))
+	assert.Equal(t, []string{"/version", "/healthz", "/readyz"}, paths)
+}
+
+func TestSubmit_SendsAPIKey(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	thisUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := SubmitRequest{
+		Host:        *thisUrl,
+		callOptions: callOptions{APIKey: "0123456789abcdef"},
+		ScanRequest: types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 80},
+	}
+	err = Submit(req, server.Client())
+	assert.EqualError(t, err, "pscan server requires a valid api key")
+	assert.Equal(t, "Bearer 0123456789abcdef", got)
+}
+
+func TestFormatStatuses(t *testing.T) {
+	statuses := sortStatuses([]types.IPStatus{
+		{IP: "2001:db8::10", State: types.OPEN, Target: "dual.example.com"},
+		{IP: "192.0.2.10", State: types.OPEN, Target: "dual.example.com"},
+		{IP: "fe80::1%eth0", State: types.CLOSED},
+		{IP: "10.0.0.2", State: types.CLOSED},
+		{IP: "10.0.0.1", State: types.OPEN, Banner: `SSH-2.0-OpenSSH_8.9p1`},
+		{IP: "10.0.0.3", State: types.OPEN, Service: &types.Service{Name: "ssh", Product: "OpenSSH", Version: "8.9p1", Info: "protocol 2.0"}},
+		{IP: "10.0.0.4", State: types.OPEN, Service: &types.Service{Name: "http"}},
+		{IP: "10.0.0.7", State: types.OPEN, Host: types.HOST_UP, HostReason: "arp", MAC: "02:42:ac:11:00:07"},
+		{IP: "10.0.0.8", State: types.SKIPPED, Host: types.HOST_DOWN},
+		{IP: "10.0.0.9", State: types.OPEN, Scripts: []types.ScriptResult{
+			{Name: "ftp-anon", Output: []byte(`{"anonymous":true}`)},
+			{Name: "smb-os", Error: "script did not finish within 10s"},
+		}},
+		{IP: "10.0.0.6", State: types.OPEN, HTTP: &types.HTTPInfo{
+			URL:        "http://10.0.0.6:443/login",
+			StatusCode: 200,
+			Server:     "nginx",
+			Title:      "Sign in",
+			Redirects:  []string{"http://10.0.0.6:443/login"},
+		}},
+		{IP: "10.0.0.5", State: types.OPEN, TLS: &types.TLSInfo{
+			Version:     "TLS 1.3",
+			CipherSuite: "TLS_AES_128_GCM_SHA256",
+			Versions:    []string{"TLS 1.2", "TLS 1.3"},
+			Certificate: &types.Certificate{Subject: "CN=example.com", NotAfter: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), KeyType: "ECDSA", KeyBits: 256, SelfSigned: true},
+		}},
+	})
+	lines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		lines = append(lines, formatStatus(status, 443))
+	}
+	assert.Equal(t, []string{
+		"10.0.0.1:443 in state open banner: SSH-2.0-OpenSSH_8.9p1",
+		"10.0.0.2:443 in state closed",
+		"10.0.0.3:443 in state open service: ssh OpenSSH 8.9p1 (protocol 2.0)",
+		"10.0.0.4:443 in state open service: http",
+		"10.0.0.5:443 in state open tls: TLS 1.3 TLS_AES_128_GCM_SHA256 (supports TLS 1.2, TLS 1.3) cert CN=example.com expires 2030-01-01 ECDSA 256 self signed",
+		`10.0.0.6:443 in state open http: 200 http://10.0.0.6:443/login server nginx title "Sign in" via http://10.0.0.6:443/login`,
+		"10.0.0.7:443 in state open host: up (arp) mac 02:42:ac:11:00:07",
+		"10.0.0.8:443 in state skipped host: down",
+		`10.0.0.9:443 in state open script ftp-anon: {"anonymous":true} script smb-os: failed (script did not finish within 10s)`,
+		"192.0.2.10:443 in state open (dual.example.com)",
+		"[2001:db8::10]:443 in state open (dual.example.com)",
+		"[fe80::1%eth0]:443 in state closed",
+	}, lines)
+}
+
+func TestCommandLineArgs_PrepareSubmitRequest_Targets(t *testing.T) {
+	c := CommandLineArgs{
+		Host:     "127.0.0.1",
+		ScanIPs:  []string{"2001:db8::1", "10.0.0.0/30", "scanme.example.com"},
+		ScanPort: "443",
+	}
+	req, err := c.PrepareSubmitRequest()
+	assert.Nil(t, err)
+	assert.Equal(t, c.ScanIPs, req.ScanIPs)
+}
+
+func TestFormatHeader(t *testing.T) {
+	assert.Equal(t, "results of scan of port 443", formatHeader(types.QueryResponse{ScanPort: 443, Protocol: types.TCP}))
+	assert.Equal(t, "results of scan of udp port 53 from source vlan20", formatHeader(types.QueryResponse{ScanPort: 53, Protocol: types.UDP, Source: "vlan20"}))
+}
+
+func TestCommandLineArgs_PrepareQuery_Filters(t *testing.T) {
+	q, err := CommandLineArgs{Host: "127.0.0.1", ScanID: "1", QueryService: "ssh", QueryProduct: "openssh"}.PrepareQuery()
+	assert.Nil(t, err)
+	assert.Equal(t, types.QueryRequest{ScanID: 1, Service: "ssh", Product: "openssh"}, q.QueryRequest)
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//Diff represents the information needed to compare the results of two scans
+type Diff struct {
+	Host url.URL
+	callOptions
+	types.DiffRequest
+	//JSON prints the DiffResponse as JSON rather than a line per change
+	JSON bool
+}
+
+//PrepareDiff will transform command line arguments into a Diff, given that the correct arguments were set and that they are valid
+//If arguments are not valid for this request, an error will be returned with a nil Diff
+func (c CommandLineArgs) PrepareDiff() (*Diff, error) {
+	diff := &Diff{JSON: c.OutputJSON}
+
+	if host, err := parseHostString(c.Host); err != nil {
+		return nil, err
+	} else {
+		host.Path = "/diff"
+		diff.Host = *host
+	}
+
+	if sc, err := c.traceContext(); err != nil {
+		return nil, err
+	} else {
+		diff.Trace = sc
+	}
+	diff.APIKey = c.APIKey
+
+	if len(c.DiffFrom) == 0 || len(c.DiffTo) == 0 {
+		return nil, fmt.Errorf("you must provide the ids of the scans to diff from and to")
+	} else if from, err := strconv.ParseUint(c.DiffFrom, 10, 64); err != nil {
+		return nil, fmt.Errorf("%s is not a valid scan id", c.DiffFrom)
+	} else if to, err := strconv.ParseUint(c.DiffTo, 10, 64); err != nil {
+		return nil, fmt.Errorf("%s is not a valid scan id", c.DiffTo)
+	} else {
+		diff.From, diff.To = from, to
+	}
+
+	return diff, nil
+}
+
+//DoDiff will display how the results of one scan differ from another, with the given Client
+//the client passed may not be nil
+func DoDiff(d Diff, client *http.Client) error {
+	var resp types.DiffResponse
+	if err, statusCode := doPost(client, d.Host.String(), "application/json", d.callOptions, d.DiffRequest, &resp); err != nil {
+		return err
+	} else if statusCode != http.StatusOK {
+		return fmt.Errorf("could not diff scans, status was %d", statusCode)
+	}
+
+	if d.JSON {
+		bs, err := json.MarshalIndent(&resp, "", "  ")
+		if err != nil {
+			return fmt.Errorf("bug! could not marshal diff, error was: %s", err.Error())
+		}
+		fmt.Println(string(bs))
+		return nil
+	}
+
+	fmt.Println(formatDiffHeader(resp))
+	if len(resp.Changes) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, change := range resp.Changes {
+		fmt.Println(formatPortDiff(change, resp.ScanPort))
+	}
+	return nil
+}
+
+//formatDiffHeader describes the scans a DiffResponse compares
+func formatDiffHeader(resp types.DiffResponse) string {
+	port := fmt.Sprintf("port %d", resp.ScanPort)
+	if resp.Protocol == types.UDP {
+		port = fmt.Sprintf("udp port %d", resp.ScanPort)
+	}
+	return fmt.Sprintf("changes to %s from scan %d to scan %d", port, resp.From, resp.To)
+}
+
+//formatPortDiff describes one change, e.g 10.0.0.4:443 changed: banner "hello" -> "hello again"
+func formatPortDiff(d types.PortDiff, port uint) string {
+	line := net.JoinHostPort(d.IP, strconv.FormatUint(uint64(port), 10))
+	if len(d.Target) > 0 {
+		line = fmt.Sprintf("%s (%s)", line, d.Target)
+	}
+	if d.Change != types.DiffChanged {
+		return fmt.Sprintf("%s %s: %s -> %s", line, d.Change, formatDiffState(d.FromState), formatDiffState(d.ToState))
+	}
+	fields := make([]string, 0, len(d.Fields))
+	for _, f := range d.Fields {
+		fields = append(fields, fmt.Sprintf("%s %q -> %q", f.Field, f.From, f.To))
+	}
+	return fmt.Sprintf("%s %s: %s", line, d.Change, strings.Join(fields, ", "))
+}
+
+//formatDiffState describes the state of a port in one scan, which is missing if the scan did not include it
+func formatDiffState(s types.State) string {
+	if len(s) == 0 {
+		return "not scanned"
+	}
+	return string(s)
+}
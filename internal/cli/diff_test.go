@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLineArgs_PrepareDiff(t *testing.T) {
+	diff, err := CommandLineArgs{Host: "localhost:8080", DiffFrom: "1", DiffTo: "2", OutputJSON: true}.PrepareDiff()
+	assert.Nil(t, err)
+	assert.Equal(t, "/diff", diff.Host.Path)
+	assert.Equal(t, types.DiffRequest{From: 1, To: 2}, diff.DiffRequest)
+	assert.True(t, diff.JSON)
+
+	_, err = CommandLineArgs{Host: "localhost:8080", DiffFrom: "1"}.PrepareDiff()
+	assert.EqualError(t, err, "you must provide the ids of the scans to diff from and to")
+	_, err = CommandLineArgs{Host: "localhost:8080", DiffFrom: "1", DiffTo: "latest"}.PrepareDiff()
+	assert.EqualError(t, err, "latest is not a valid scan id")
+	_, err = CommandLineArgs{DiffFrom: "1", DiffTo: "2"}.PrepareDiff()
+	assert.EqualError(t, err, "you must provide a pscan server host")
+}
+
+func TestDoDiff_UnknownScan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("scan 9 is not a known id"))
+	}))
+	defer server.Close()
+	host, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DoDiff(Diff{Host: *host, DiffRequest: types.DiffRequest{From: 9, To: 2}}, server.Client())
+	assert.EqualError(t, err, "scan 9 is not a known id")
+}
+
+func TestFormatPortDiff(t *testing.T) {
+	assert.Equal(t, "changes to udp port 53 from scan 1 to scan 2", formatDiffHeader(types.DiffResponse{From: 1, To: 2, ScanPort: 53, Protocol: types.UDP}))
+	tests := []struct {
+		diff types.PortDiff
+		want string
+	}{
+		{types.PortDiff{IP: "10.0.0.1", Change: types.DiffOpened, FromState: types.CLOSED, ToState: types.OPEN}, "10.0.0.1:443 opened: closed -> open"},
+		{types.PortDiff{IP: "2001:db8::1", Target: "db.example.com", Change: types.DiffClosed, FromState: types.OPEN}, "[2001:db8::1]:443 (db.example.com) closed: open -> not scanned"},
+		{types.PortDiff{IP: "10.0.0.4", Change: types.DiffChanged, FromState: types.OPEN, ToState: types.OPEN, Fields: []types.FieldDiff{
+			{Field: "banner", From: "hello", To: "hello again"},
+			{Field: "service", To: "http nginx"},
+		}}, `10.0.0.4:443 changed: banner "hello" -> "hello again", service "" -> "http nginx"`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatPortDiff(tt.diff, 443))
+	}
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//defaultLocalConcurrency bounds the probes in progress of a local scan, unless given
+const defaultLocalConcurrency = 64
+
+//LocalScan represents the information needed to scan in process, without a pscan server
+type LocalScan struct {
+	types.ScanRequest
+	//Filter keeps only results matching its Service and Product, as a query would
+	Filter      types.QueryRequest
+	Concurrency uint
+	//Timeout is how long each probe waits for a connection. Zero is the default of a pscan server
+	Timeout   time.Duration
+	ScriptDir string
+}
+
+//PrepareLocalScan will transform command line arguments into a LocalScan, given that they are valid
+//Scan arguments are those of a submit request, other than a source profile, which only a pscan server has
+//If arguments are not valid for this request, an error will be returned with a nil LocalScan
+func (c CommandLineArgs) PrepareLocalScan() (*LocalScan, error) {
+	scan := &LocalScan{Concurrency: defaultLocalConcurrency, ScriptDir: c.LocalScriptDir}
+
+	if scanRequest, err := c.scanRequest(); err != nil {
+		return nil, err
+	} else if len(scanRequest.Source) > 0 {
+		return nil, fmt.Errorf("source profiles are only available to scans submitted to a pscan server")
+	} else {
+		scan.ScanRequest = *scanRequest
+	}
+
+	if len(c.LocalConcurrency) > 0 {
+		if n, err := strconv.ParseUint(c.LocalConcurrency, 10, 32); err != nil || n == 0 {
+			return nil, fmt.Errorf("concurrency must be a positive number")
+		} else {
+			scan.Concurrency = uint(n)
+		}
+	}
+
+	if len(c.LocalTimeout) > 0 {
+		if d, err := time.ParseDuration(c.LocalTimeout); err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout %s is not a valid duration, e.g 5s", c.LocalTimeout)
+		} else {
+			scan.Timeout = d
+		}
+	}
+	scan.Filter = types.QueryRequest{Service: c.QueryService, Product: c.QueryProduct}
+
+	return scan, nil
+}
+
+//options returns the scanner options of a LocalScan
+func (l LocalScan) options() scanner.Options {
+	return scanner.Options{
+		Protocol:  l.Protocol,
+		Technique: l.Technique,
+		Banner:    l.Banner,
+		Detect:    l.Detect,
+		TLS:       l.TLS,
+		HTTP:      l.HTTP,
+		Discover:  l.Discover,
+		ProbeDown: l.ProbeDown,
+		Prober:    l.Probe,
+		Scripts:   l.ScanRequest.Scripts,
+		ScriptDir: l.ScriptDir,
+		Timeouts:  scanner.Timeouts{Dial: l.Timeout},
+	}
+}
+
+//RunLocalScan scans as l describes with s, and returns the results as a query of the same scan on a pscan server would
+//Results interrupted by the cancellation of ctx are left out
+func RunLocalScan(ctx context.Context, l LocalScan, s *scanner.Scanner) (*types.QueryResponse, error) {
+	results, err := s.Scan(ctx, l.ScanIPs, []uint{l.ScanPort}, l.options())
+	if err != nil {
+		return nil, err
+	}
+	resp := &types.QueryResponse{
+		Ready:    true,
+		ScanPort: l.ScanPort,
+		Protocol: l.Protocol,
+		Status:   make([]types.IPStatus, 0),
+	}
+	if len(resp.Protocol) == 0 {
+		resp.Protocol = types.TCP
+	}
+	for r := range results {
+		if l.Filter.Matches(r.IPStatus) {
+			resp.Status = append(resp.Status, r.IPStatus)
+		}
+	}
+	return resp, nil
+}
+
+//DoLocalScan will process a CLI scan request in process, displaying results as a query would
+func DoLocalScan(l LocalScan) error {
+	s := scanner.New(l.Concurrency)
+	defer s.Close()
+	if _, reason := s.Technique(l.options()); len(reason) > 0 {
+		fmt.Fprintf(os.Stderr, "connecting instead of syn scanning: %s\n", reason)
+	}
+	resp, err := RunLocalScan(context.Background(), l, s)
+	if err != nil {
+		return err
+	}
+	fmt.Println(formatHeader(*resp))
+	for _, status := range sortStatuses(resp.Status) {
+		fmt.Println(formatStatus(status, resp.ScanPort))
+	}
+	return nil
+}
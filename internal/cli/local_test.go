@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLineArgs_PrepareLocalScan(t *testing.T) {
+	scan, err := CommandLineArgs{ScanIPs: []string{"127.0.0.1"}, ScanPort: "22", LocalTimeout: "2s"}.PrepareLocalScan()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(22), scan.ScanPort)
+	assert.Equal(t, uint(defaultLocalConcurrency), scan.Concurrency)
+	assert.Equal(t, 2*time.Second, scan.Timeout)
+
+	tests := []struct {
+		name string
+		args CommandLineArgs
+		want string
+	}{
+		{"ips", CommandLineArgs{ScanPort: "22"}, "you must provide a list of ips to scan"},
+		{"port", CommandLineArgs{ScanIPs: []string{"127.0.0.1"}, ScanPort: "ssh"}, "ssh is not a valid port to scan"},
+		{"source", CommandLineArgs{ScanIPs: []string{"127.0.0.1"}, ScanPort: "22", ScanSource: "vlan20"}, "source profiles are only available to scans submitted to a pscan server"},
+		{"concurrency", CommandLineArgs{ScanIPs: []string{"127.0.0.1"}, ScanPort: "22", LocalConcurrency: "0"}, "concurrency must be a positive number"},
+		{"timeout", CommandLineArgs{ScanIPs: []string{"127.0.0.1"}, ScanPort: "22", LocalTimeout: "5"}, "timeout 5 is not a valid duration, e.g 5s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan, err := tt.args.PrepareLocalScan()
+			assert.Nil(t, scan)
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestRunLocalScan(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	scan, err := CommandLineArgs{ScanIPs: []string{"127.0.0.1/31"}, ScanPort: strconv.Itoa(port)}.PrepareLocalScan()
+	assert.Nil(t, err)
+	s := scanner.New(scan.Concurrency)
+	defer s.Close()
+	resp, err := RunLocalScan(context.Background(), *scan, s)
+	assert.Nil(t, err)
+	assert.True(t, resp.Ready)
+	assert.Equal(t, uint(port), resp.ScanPort)
+	assert.Equal(t, types.TCP, resp.Protocol)
+	assert.Len(t, resp.Status, 2)
+	statuses := sortStatuses(resp.Status)
+	assert.Equal(t, "127.0.0.0", statuses[0].IP)
+	assert.Equal(t, "127.0.0.1", statuses[1].IP)
+	assert.Equal(t, types.OPEN, statuses[1].State)
+	assert.Equal(t, "127.0.0.1/31", statuses[1].Target)
+
+	//a filter keeps only ports where a matching service was detected, as a query would
+	scan.Filter = types.QueryRequest{Service: "ssh"}
+	resp, err = RunLocalScan(context.Background(), *scan, s)
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Status)
+}
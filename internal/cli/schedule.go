@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//SchedulePause, ScheduleResume and ScheduleDelete are the changes a ScheduleUpdate may make
+	SchedulePause  = "pause"
+	ScheduleResume = "resume"
+	ScheduleDelete = "delete"
+)
+
+//scheduleTimeFormat is how the times of schedules and their runs are displayed
+const scheduleTimeFormat = "2006-01-02 15:04 MST"
+
+//ScheduleCreate represents the information needed to create a scheduled scan
+type ScheduleCreate struct {
+	Host url.URL
+	callOptions
+	types.ScheduleRequest
+}
+
+//ScheduleList represents the information needed to list scheduled scans, or show the run history of one
+type ScheduleList struct {
+	Host url.URL
+	callOptions
+	//ID optionally names the one schedule to show, with every run it keeps
+	ID uint64
+}
+
+//ScheduleUpdate represents the information needed to pause, resume or delete a scheduled scan
+type ScheduleUpdate struct {
+	Host url.URL
+	callOptions
+	types.ScheduleIDRequest
+	//Action is SchedulePause, ScheduleResume or ScheduleDelete
+	Action string
+}
+
+//scheduleCall returns the url of the schedule endpoint action, and the options of calls to it
+func (c CommandLineArgs) scheduleCall(action string) (*url.URL, callOptions, error) {
+	host, err := parseHostString(c.Host)
+	if err != nil {
+		return nil, callOptions{}, err
+	}
+	host.Path = "/schedule/" + action
+	sc, err := c.traceContext()
+	if err != nil {
+		return nil, callOptions{}, err
+	}
+	return host, callOptions{Trace: sc, APIKey: c.APIKey}, nil
+}
+
+//scheduleID parses ScheduleID, which must be given if required
+func (c CommandLineArgs) scheduleID(required bool) (uint64, error) {
+	if len(c.ScheduleID) == 0 {
+		if required {
+			return 0, fmt.Errorf("you must provide a schedule id")
+		}
+		return 0, nil
+	} else if id, err := strconv.ParseUint(c.ScheduleID, 10, 64); err != nil {
+		return 0, fmt.Errorf("not a valid schedule id")
+	} else {
+		return id, nil
+	}
+}
+
+//PrepareScheduleCreate will transform command line arguments into a ScheduleCreate, given that they are valid
+//The scan is described by the same arguments as a submit request
+//If arguments are not valid for this request, an error will be returned with a nil ScheduleCreate
+func (c CommandLineArgs) PrepareScheduleCreate() (*ScheduleCreate, error) {
+	host, opts, err := c.scheduleCall("create")
+	if err != nil {
+		return nil, err
+	}
+	create := &ScheduleCreate{Host: *host, callOptions: opts}
+
+	scanRequest, err := c.scanRequest()
+	if err != nil {
+		return nil, err
+	}
+	create.ScheduleRequest = types.ScheduleRequest{Name: c.ScheduleName, Cron: c.ScheduleCron, Scan: *scanRequest}
+	if valid, err := create.ScheduleRequest.Validate(); !valid {
+		return nil, err
+	}
+
+	return create, nil
+}
+
+//PrepareScheduleList will transform command line arguments into a ScheduleList, given that they are valid
+//If arguments are not valid for this request, an error will be returned with a nil ScheduleList
+func (c CommandLineArgs) PrepareScheduleList() (*ScheduleList, error) {
+	host, opts, err := c.scheduleCall("list")
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.scheduleID(false)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleList{Host: *host, callOptions: opts, ID: id}, nil
+}
+
+//PrepareScheduleUpdate will transform command line arguments into a ScheduleUpdate making action, given that they are valid
+//If arguments are not valid for this request, an error will be returned with a nil ScheduleUpdate
+func (c CommandLineArgs) PrepareScheduleUpdate(action string) (*ScheduleUpdate, error) {
+	if action != SchedulePause && action != ScheduleResume && action != ScheduleDelete {
+		return nil, fmt.Errorf("bug! %s is not a schedule action", action)
+	}
+	host, opts, err := c.scheduleCall(action)
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.scheduleID(true)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleUpdate{Host: *host, callOptions: opts, ScheduleIDRequest: types.ScheduleIDRequest{ID: id}, Action: action}, nil
+}
+
+//DoScheduleCreate will create a scheduled scan, with the given Client
+//the client passed may not be nil
+func DoScheduleCreate(r ScheduleCreate, client *http.Client) error {
+	var resp types.Schedule
+	if err, statusCode := doPost(client, r.Host.String(), "application/json", r.callOptions, r.ScheduleRequest, &resp); err != nil {
+		return err
+	} else if statusCode != http.StatusOK {
+		return fmt.Errorf("could not create schedule, status was %d", statusCode)
+	}
+	fmt.Printf("created schedule %d\n", resp.ID)
+	fmt.Println(formatSchedule(resp))
+	return nil
+}
+
+//DoScheduleList will display every scheduled scan and its last run, or one scheduled scan and every run it keeps,
+//with the given Client
+//the client passed may not be nil
+func DoScheduleList(l ScheduleList, client *http.Client) error {
+	var resp types.ScheduleListResponse
+	if err, statusCode := doGet(client, l.Host.String(), l.callOptions, &resp); err != nil {
+		return err
+	} else if statusCode == http.StatusUnauthorized {
+		return fmt.Errorf("pscan server requires a valid api key")
+	} else if statusCode != http.StatusOK {
+		return fmt.Errorf("could not list schedules, status was %d", statusCode)
+	}
+
+	if l.ID != 0 {
+		for _, schedule := range resp.Schedules {
+			if schedule.ID == l.ID {
+				fmt.Println(formatSchedule(schedule))
+				for _, run := range schedule.Runs {
+					fmt.Printf("  %s\n", formatRun(run))
+				}
+				return nil
+			}
+		}
+		fmt.Printf("schedule id %d is not a known id\n", l.ID)
+		return nil
+	}
+
+	if len(resp.Schedules) == 0 {
+		fmt.Println("no scans are scheduled")
+	}
+	for _, schedule := range resp.Schedules {
+		fmt.Println(formatSchedule(schedule))
+		if last := len(schedule.Runs) - 1; last >= 0 {
+			fmt.Printf("  last %s\n", formatRun(schedule.Runs[last]))
+		}
+	}
+	return nil
+}
+
+//DoScheduleUpdate will pause, resume or delete a scheduled scan, with the given Client
+//the client passed may not be nil
+func DoScheduleUpdate(u ScheduleUpdate, client *http.Client) error {
+	var resp types.Schedule
+	if err, statusCode := doPost(client, u.Host.String(), "application/json", u.callOptions, u.ScheduleIDRequest, &resp); err != nil {
+		return err
+	} else if statusCode == http.StatusNotFound {
+		fmt.Printf("schedule id %d is not a known id\n", u.ID)
+		return nil
+	} else if statusCode != http.StatusOK {
+		return fmt.Errorf("could not %s schedule, status was %d", u.Action, statusCode)
+	}
+	switch u.Action {
+	case SchedulePause:
+		fmt.Printf("paused schedule %d\n", resp.ID)
+	case ScheduleResume:
+		fmt.Printf("resumed schedule %d\n", resp.ID)
+	case ScheduleDelete:
+		fmt.Printf("deleted schedule %d\n", resp.ID)
+	}
+	return nil
+}
+
+//formatSchedule describes a schedule, e.g 42 @daily next run 2021-03-02 00:00 UTC: port 22 of 10.0.0.0/24 "nightly"
+func formatSchedule(s types.Schedule) string {
+	line := fmt.Sprintf("%d %s", s.ID, s.Cron)
+	if s.Paused {
+		line += " paused"
+	} else if s.NextRun != nil {
+		line = fmt.Sprintf("%s next run %s", line, s.NextRun.Format(scheduleTimeFormat))
+	}
+	port := fmt.Sprintf("port %d", s.Scan.ScanPort)
+	if s.Scan.Protocol == types.UDP {
+		port = fmt.Sprintf("udp port %d", s.Scan.ScanPort)
+	}
+	line = fmt.Sprintf("%s: %s of %s", line, port, strings.Join(s.Scan.ScanIPs, ", "))
+	if len(s.Name) > 0 {
+		line = fmt.Sprintf("%s %q", line, s.Name)
+	}
+	return line
+}
+
+//formatRun describes one run of a schedule, e.g run 2021-03-01 00:00 UTC scan 5577006791947779410
+func formatRun(r types.ScheduleRun) string {
+	line := fmt.Sprintf("run %s", r.Time.Format(scheduleTimeFormat))
+	if len(r.Error) > 0 {
+		return fmt.Sprintf("%s failed (%s)", line, r.Error)
+	}
+	return fmt.Sprintf("%s scan %d", line, r.ScanID)
+}
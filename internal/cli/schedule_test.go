@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLineArgs_PrepareScheduleCreate(t *testing.T) {
+	args := CommandLineArgs{Host: "localhost:8080", ScanIPs: []string{"10.0.0.0/24"}, ScanPort: "22", ScheduleName: "nightly", ScheduleCron: "@daily"}
+	create, err := args.PrepareScheduleCreate()
+	assert.Nil(t, err)
+	assert.Equal(t, "/schedule/create", create.Host.Path)
+	assert.Equal(t, "nightly", create.Name)
+	assert.Equal(t, uint(22), create.Scan.ScanPort)
+
+	args.ScheduleCron = "every day"
+	create, err = args.PrepareScheduleCreate()
+	assert.Nil(t, create)
+	assert.EqualError(t, err, "cron schedule every day must have 5 fields, minute hour day-of-month month day-of-week")
+
+	args.ScheduleCron = ""
+	_, err = args.PrepareScheduleCreate()
+	assert.EqualError(t, err, "you must provide a cron schedule")
+}
+
+func TestCommandLineArgs_PrepareScheduleUpdate(t *testing.T) {
+	update, err := CommandLineArgs{Host: "localhost:8080", ScheduleID: "42"}.PrepareScheduleUpdate(SchedulePause)
+	assert.Nil(t, err)
+	assert.Equal(t, "/schedule/pause", update.Host.Path)
+	assert.Equal(t, uint64(42), update.ID)
+
+	_, err = CommandLineArgs{Host: "localhost:8080"}.PrepareScheduleUpdate(ScheduleDelete)
+	assert.EqualError(t, err, "you must provide a schedule id")
+	_, err = CommandLineArgs{Host: "localhost:8080", ScheduleID: "nightly"}.PrepareScheduleUpdate(ScheduleResume)
+	assert.EqualError(t, err, "not a valid schedule id")
+
+	list, err := CommandLineArgs{Host: "localhost:8080"}.PrepareScheduleList()
+	assert.Nil(t, err)
+	assert.Equal(t, "/schedule/list", list.Host.Path)
+	assert.Zero(t, list.ID)
+}
+
+func TestDoScheduleCreate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		var req types.ScheduleRequest
+		if err := json.Unmarshal(bs, &req); err != nil || req.Cron != "@daily" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("source profile vlan20 is not configured"))
+			return
+		}
+		bs, _ = json.Marshal(types.Schedule{ID: 42, Cron: req.Cron, Scan: req.Scan})
+		_, _ = w.Write(bs)
+	}))
+	defer server.Close()
+	host, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := ScheduleCreate{Host: *host, ScheduleRequest: types.ScheduleRequest{Cron: "@daily", Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 22}}}
+	assert.Nil(t, DoScheduleCreate(create, server.Client()))
+
+	create.Cron = "@hourly"
+	assert.EqualError(t, DoScheduleCreate(create, server.Client()), "pscan server rejected the request: source profile vlan20 is not configured")
+}
+
+func TestFormatSchedule(t *testing.T) {
+	next := time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+	s := types.Schedule{ID: 42, Name: "nightly", Cron: "@daily", NextRun: &next, Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.0/24", "db.example.com"}, ScanPort: 22}}
+	assert.Equal(t, `42 @daily next run 2021-03-02 00:00 UTC: port 22 of 10.0.0.0/24, db.example.com "nightly"`, formatSchedule(s))
+
+	s = types.Schedule{ID: 7, Cron: "*/5 * * * *", Paused: true, Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 53, Protocol: types.UDP}}
+	assert.Equal(t, "7 */5 * * * * paused: udp port 53 of 10.0.0.1", formatSchedule(s))
+
+	assert.Equal(t, "run 2021-03-02 00:00 UTC scan 99", formatRun(types.ScheduleRun{Time: next, ScanID: 99}))
+	assert.Equal(t, "run 2021-03-02 00:00 UTC failed (port 25 is a denied port)", formatRun(types.ScheduleRun{Time: next, Error: "port 25 is a denied port"}))
+}
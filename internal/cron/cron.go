@@ -0,0 +1,181 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Schedule is a parsed cron expression, of the minutes, hours, days of the month, months and days of the week it runs on
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	//domAny and dowAny are set when the day of the month or week starts with *, e.g * or */2. When neither is, a day
+	//matching either runs, and otherwise a day matching both, as in cron
+	domAny, dowAny bool
+}
+
+//field describes the range and names of one field of a cron expression
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	//day of week accepts 7 as well as 0 for sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+//descriptors are the @ shorthands accepted in place of five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//searchYears bounds how far ahead Next looks for a matching time
+const searchYears = 5
+
+//Parse parses a cron expression of five fields, minute hour day-of-month month day-of-week, e.g */15 9-17 * * mon-fri,
+//or one of @yearly, @monthly, @weekly, @daily and @hourly
+//Fields are lists of values, ranges and steps, with months and days of the week also named by their first three letters
+func Parse(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if d, found := descriptors[strings.ToLower(expr)]; found {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %s must have 5 fields, minute hour day-of-month month day-of-week", spec)
+	}
+	s := &Schedule{}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	} else if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	} else if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	} else if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	} else if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	if s.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron schedule %s never runs", spec)
+	}
+	return s, nil
+}
+
+//parse returns the bits of the values a field of a cron expression names
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		if b, err := f.parsePart(part); err != nil {
+			return 0, fmt.Errorf("%s is not a valid %s", spec, f.name)
+		} else {
+			bits |= b
+		}
+	}
+	return bits, nil
+}
+
+//parsePart returns the bits of one value, range or step of a list
+func (f field) parsePart(part string) (uint64, error) {
+	step := 1
+	if i := strings.Index(part, "/"); i >= 0 {
+		n, err := strconv.Atoi(part[i+1:])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("bad step")
+		}
+		step = n
+		part = part[:i]
+	}
+	low, high := f.min, f.max
+	if part != "*" {
+		i := strings.Index(part, "-")
+		if i < 0 {
+			i = len(part)
+		}
+		var err error
+		if low, err = f.value(part[:i]); err != nil {
+			return 0, err
+		}
+		high = low
+		if i < len(part) {
+			if high, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+		} else if step > 1 {
+			//a value with a step runs from that value to the end of the field, e.g 5/15 is 5,20,35,50
+			high = f.max
+		}
+		if low > high {
+			return 0, fmt.Errorf("bad range")
+		}
+	}
+	var bits uint64
+	for v := low; v <= high; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+//value parses a number or name of a field
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if len(name) > 0 && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("out of range")
+	}
+	return v, nil
+}
+
+//Next returns the first time after after that s runs, to the minute and in the location of after
+//Next returns the zero time if s does not run in the next five years
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	loc := t.Location()
+	limit := t.AddDate(searchYears, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		} else if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		} else if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		} else if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,69 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	//a monday
+	from := time.Date(2021, 3, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2021, 3, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 3, 1, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 3, 1, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17 * * mon-fri", time.Date(2021, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * sat,sun", time.Date(2021, 3, 6, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		//day of month or day of week, when both are given
+		{"0 0 15 * fri", time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		//but a field starting with * is unrestricted, so as in cron a day must match both, here an odd-numbered monday
+		{"0 0 */2 * 1", time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, s.Next(from))
+		})
+	}
+}
+
+func TestSchedule_Next_KeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := Parse("0 9 * * *")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 3, 2, 9, 0, 0, 0, loc), s.Next(time.Date(2021, 3, 1, 9, 0, 0, 0, loc)))
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"* * * *", "cron schedule * * * * must have 5 fields, minute hour day-of-month month day-of-week"},
+		{"60 * * * *", "60 is not a valid minute"},
+		{"* 5-1 * * *", "5-1 is not a valid hour"},
+		{"* * 0 * *", "0 is not a valid day of month"},
+		{"* * * foo *", "foo is not a valid month"},
+		{"* * * * 1,8", "1,8 is not a valid day of week"},
+		{"*/0 * * * *", "*/0 is not a valid minute"},
+		{"0 0 30 feb *", "cron schedule 0 0 30 feb * never runs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			assert.Nil(t, s)
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Level is the severity of a log line
+type Level int32
+
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+//String returns the lowercase name of the Level, as it appears in log output
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+}
+
+//ParseLevel returns the Level named by s, e.g "debug" or "warn"
+//ParseLevel will return an error if s does not name a known Level
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return InfoLevel, fmt.Errorf("%s is not a valid log level", s)
+	}
+}
+
+//Fields are structured key/value pairs attached to a log line
+type Fields map[string]interface{}
+
+const redacted = "[REDACTED]"
+
+//sensitiveKeys are field name fragments whose values are never written to output
+var sensitiveKeys = []string{"authorization", "password", "secret", "token", "api_key", "apikey", "cookie"}
+
+//Logger writes leveled, structured log lines as JSON, one object per line
+//A Logger is safe for concurrent use
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  *int32
+	fields Fields
+	now    func() time.Time
+	exit   func(int)
+}
+
+//New returns a Logger writing lines at or above level to out
+func New(out io.Writer, level Level) *Logger {
+	lvl := int32(level)
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		level:  &lvl,
+		fields: Fields{},
+		now:    time.Now,
+		exit:   os.Exit,
+	}
+}
+
+//Discard returns a Logger that writes nothing, useful for tests
+func Discard() *Logger {
+	return New(ioutil.Discard, ErrorLevel+1)
+}
+
+//With returns a child Logger that adds fields to every line it writes
+//The child shares its output and level with the parent
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	child := *l
+	child.fields = merged
+	return &child
+}
+
+//SetLevel changes the minimum Level written by this Logger, and any Logger derived from it
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
+//Level returns the minimum Level written by this Logger
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(l.level))
+}
+
+//Enabled returns true if a line at level would be written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.write(DebugLevel, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.write(InfoLevel, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.write(WarnLevel, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+}
+
+//Fatal writes msg at ErrorLevel and exits the process
+func (l *Logger) Fatal(msg string, fields ...Fields) {
+	l.write(ErrorLevel, msg, fields)
+	l.exit(1)
+}
+
+func (l *Logger) write(level Level, msg string, extra []Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	line := make(Fields, len(l.fields)+3)
+	for k, v := range l.fields {
+		line[k] = redact(k, v)
+	}
+	for _, fields := range extra {
+		for k, v := range fields {
+			line[k] = redact(k, v)
+		}
+	}
+	line["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level.String()
+	line["msg"] = msg
+
+	bs, err := json.Marshal(line)
+	if err != nil {
+		bs, _ = json.Marshal(Fields{
+			"time":  line["time"],
+			"level": level.String(),
+			"msg":   msg,
+			"error": fmt.Sprintf("could not marshal log fields: %s", err.Error()),
+		})
+	}
+	bs = append(bs, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(bs)
+}
+
+func redact(key string, value interface{}) interface{} {
+	lower := strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lower, sensitive) {
+			return redacted
+		}
+	}
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	return value
+}
+
+type requestIDKey struct{}
+
+//NewRequestID returns a random identifier used to correlate log lines for a single request
+func NewRequestID() string {
+	bs := make([]byte, 8)
+	if _, err := rand.Read(bs); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bs)
+}
+
+//WithRequestID returns a copy of ctx carrying the given request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+//RequestID returns the request id carried by ctx, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(level Level) (*Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	l := New(buf, level)
+	l.now = func() time.Time {
+		return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return l, buf
+}
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("line is not json: %s", line)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("DEBUG")
+	assert.Nil(t, err)
+	assert.Equal(t, DebugLevel, level)
+
+	level, err = ParseLevel("")
+	assert.Nil(t, err)
+	assert.Equal(t, InfoLevel, level)
+
+	_, err = ParseLevel("loud")
+	assert.EqualError(t, err, "loud is not a valid log level")
+}
+
+func TestLogger_FiltersByLevel(t *testing.T) {
+	l, buf := newTestLogger(WarnLevel)
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	got := lines(t, buf)
+	assert.Len(t, got, 2)
+	assert.Equal(t, "warn", got[0]["level"])
+	assert.Equal(t, "error", got[1]["level"])
+
+	l.SetLevel(DebugLevel)
+	l.Debug("debug")
+	assert.Len(t, lines(t, buf), 3)
+}
+
+func TestLogger_WritesStructuredFields(t *testing.T) {
+	l, buf := newTestLogger(InfoLevel)
+	l.With(Fields{"request_id": "abc"}).Info("hello", Fields{"port": 80, "error": fmt.Errorf("boom")})
+	got := lines(t, buf)
+	assert.Len(t, got, 1)
+	assert.Equal(t, "hello", got[0]["msg"])
+	assert.Equal(t, "info", got[0]["level"])
+	assert.Equal(t, "2020-01-01T00:00:00Z", got[0]["time"])
+	assert.Equal(t, "abc", got[0]["request_id"])
+	assert.Equal(t, float64(80), got[0]["port"])
+	assert.Equal(t, "boom", got[0]["error"])
+}
+
+func TestLogger_WithDoesNotModifyParent(t *testing.T) {
+	l, buf := newTestLogger(InfoLevel)
+	_ = l.With(Fields{"child": true})
+	l.Info("parent")
+	got := lines(t, buf)
+	_, found := got[0]["child"]
+	assert.False(t, found)
+}
+
+func TestLogger_RedactsSensitiveFields(t *testing.T) {
+	l, buf := newTestLogger(InfoLevel)
+	l.With(Fields{"Authorization": "Bearer xyz"}).Info("hello", Fields{"api_key": "xyz", "db_password": "xyz"})
+	assert.NotContains(t, buf.String(), "xyz")
+	got := lines(t, buf)
+	assert.Equal(t, redacted, got[0]["Authorization"])
+	assert.Equal(t, redacted, got[0]["api_key"])
+	assert.Equal(t, redacted, got[0]["db_password"])
+}
+
+func TestLogger_Fatal(t *testing.T) {
+	l, buf := newTestLogger(InfoLevel)
+	code := 0
+	l.exit = func(c int) {
+		code = c
+	}
+	l.Fatal("bye")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "error", lines(t, buf)[0]["level"])
+}
+
+func TestRequestID(t *testing.T) {
+	assert.Equal(t, "", RequestID(context.Background()))
+	id := NewRequestID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewRequestID())
+	assert.Equal(t, id, RequestID(WithRequestID(context.Background(), id)))
+}
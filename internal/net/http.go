@@ -1,6 +1,8 @@
 package net
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"time"
 )
@@ -11,3 +13,16 @@ func DefaultHttpClient() *http.Client {
 		Timeout: 5 * time.Second,
 	}
 }
+
+//UnixSocketHttpClient returns an http.Client with the same defaults as DefaultHttpClient,
+//that makes every connection to the unix domain socket at path, whatever the host of the request url
+func UnixSocketHttpClient(path string) *http.Client {
+	client := DefaultHttpClient()
+	dialer := &net.Dialer{}
+	client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+	return client
+}
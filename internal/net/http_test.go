@@ -1,10 +1,36 @@
 package net
 
 import (
-	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDefaultHttpClient_Sanity(t *testing.T) {
 	assert.NotNil(t, DefaultHttpClient())
 }
+
+func TestUnixSocketHttpClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscan-net")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pscan.sock")
+	listener, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	resp, err := UnixSocketHttpClient(path).Get("http://localhost/healthz")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "/healthz", string(bs))
+}
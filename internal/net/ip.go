@@ -0,0 +1,55 @@
+package net
+
+import (
+	"net"
+	"strings"
+)
+
+//ParseIP parses an ip address, which for ipv6 may carry a zone, e.g fe80::1%eth0
+//It returns a nil ip if s is not an ip address, or if a zone is given for an ipv4 address
+func ParseIP(s string) (net.IP, string) {
+	address, zone := s, ""
+	if i := strings.LastIndex(s, "%"); i >= 0 {
+		address, zone = s[:i], s[i+1:]
+		if len(zone) == 0 {
+			return nil, ""
+		}
+	}
+	ip := net.ParseIP(address)
+	if ip == nil || (len(zone) > 0 && ip.To4() != nil) {
+		return nil, ""
+	}
+	return ip, zone
+}
+
+//FormatIP returns the canonical form of ip, with zone if one is given
+func FormatIP(ip net.IP, zone string) string {
+	if len(zone) > 0 {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
+
+//ValidHostname returns true if s is a fully qualified domain name, or localhost
+func ValidHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "localhost" {
+		return true
+	}
+	labels := strings.Split(s, ".")
+	if len(s) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	//a name of only digits and dots is a malformed ip address, not a hostname
+	return strings.Trim(s, "0123456789.") != ""
+}
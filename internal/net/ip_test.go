@@ -0,0 +1,44 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		s    string
+		ip   string
+		zone string
+	}{
+		{"127.0.0.1", "127.0.0.1", ""},
+		{"2001:0db8::0001", "2001:db8::1", ""},
+		{"fe80::1%eth0", "fe80::1", "eth0"},
+		{"fe80::1%", "", ""},
+		{"127.0.0.1%eth0", "", ""},
+		{"example.com", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			ip, zone := ParseIP(tt.s)
+			if len(tt.ip) == 0 {
+				assert.Nil(t, ip)
+			} else {
+				assert.Equal(t, tt.ip, ip.String())
+			}
+			assert.Equal(t, tt.zone, zone)
+		})
+	}
+	ip, zone := ParseIP("fe80::0001%eth0")
+	assert.Equal(t, "fe80::1%eth0", FormatIP(ip, zone))
+}
+
+func TestValidHostname(t *testing.T) {
+	for _, valid := range []string{"localhost", "example.com", "scan-target.example.com.", "a1.b2"} {
+		assert.True(t, ValidHostname(valid), valid)
+	}
+	for _, invalid := range []string{"notanip", "", "-bad.example.com", "bad_name.example.com", "10.0.0.256", "example..com"} {
+		assert.False(t, ValidHostname(invalid), invalid)
+	}
+}
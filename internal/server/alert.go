@@ -0,0 +1,430 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/internal/trace"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//defaultCertExpiry is how soon a certificate must expire to raise an alert, for rules that do not say
+	defaultCertExpiry = 30 * 24 * time.Hour
+	//alertSendTimeout bounds the time a sink is given to deliver one notification
+	alertSendTimeout = 10 * time.Second
+)
+
+//AlertArgs are the unmodified alert rules and the sinks they notify, see CommandLineArgs.Alerts
+type AlertArgs struct {
+	Rules []AlertRuleArgs          `yaml:"rules"`
+	Sinks map[string]AlertSinkArgs `yaml:"sinks"`
+}
+
+//AlertRuleArgs are the unmodified arguments of one alert rule
+type AlertRuleArgs struct {
+	Name       string   `yaml:"name"`
+	Events     []string `yaml:"events"`
+	CertExpiry string   `yaml:"cert_expiry"`
+	Sinks      []string `yaml:"sinks"`
+}
+
+//AlertSinkArgs are the unmodified arguments of one alert sink. Which apply depends on Type
+type AlertSinkArgs struct {
+	Type string   `yaml:"type"`
+	URL  string   `yaml:"url"`
+	SMTP string   `yaml:"smtp"`
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+	Path string   `yaml:"path"`
+}
+
+//Alert sink types
+const (
+	AlertSinkWebhook = "webhook"
+	AlertSinkEmail   = "email"
+	AlertSinkFile    = "file"
+)
+
+//AlertConfiguration holds the rules evaluated against the results of each completed scan
+type AlertConfiguration struct {
+	Rules []AlertRule
+}
+
+//AlertRule names the events of completed scans to be told of, and the sinks that are told of them
+type AlertRule struct {
+	Name   string
+	Events []string
+	//CertExpiry is how soon a certificate must expire to raise types.AlertCertExpiring
+	CertExpiry time.Duration
+	Sinks      []alertSink
+}
+
+//alertSink delivers notifications of alerts. A sink of a new type implements it, and is created by newAlertSink
+type alertSink interface {
+	//name is the name the sink is configured with
+	name() string
+	send(ctx context.Context, n types.AlertNotification) error
+}
+
+func (c CommandLineArgs) prepareAlerts() (*AlertConfiguration, error) {
+	if c.Alerts == nil {
+		return &AlertConfiguration{}, nil
+	}
+	sinks := make(map[string]alertSink, len(c.Alerts.Sinks))
+	for name, args := range c.Alerts.Sinks {
+		if sink, err := newAlertSink(name, args); err != nil {
+			return nil, err
+		} else {
+			sinks[name] = sink
+		}
+	}
+
+	alerts := &AlertConfiguration{Rules: make([]AlertRule, 0, len(c.Alerts.Rules))}
+	names := make(map[string]bool, len(c.Alerts.Rules))
+	for i, args := range c.Alerts.Rules {
+		rule := AlertRule{Name: args.Name, Events: args.Events, CertExpiry: defaultCertExpiry}
+		if len(rule.Name) == 0 {
+			rule.Name = strconv.Itoa(i + 1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %s is declared more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(args.Events) == 0 {
+			return nil, fmt.Errorf("alert rule %s must list the events it alerts on", rule.Name)
+		}
+		for _, event := range args.Events {
+			switch event {
+			case types.AlertPortOpened, types.AlertPortClosed, types.AlertCertExpiring, types.AlertHostDown:
+			default:
+				return nil, fmt.Errorf("alert rule %s event %s is not port_opened, port_closed, cert_expiring or host_down", rule.Name, event)
+			}
+		}
+		if len(args.CertExpiry) > 0 {
+			if d, err := time.ParseDuration(args.CertExpiry); err != nil || d <= 0 {
+				return nil, fmt.Errorf("alert rule %s cert expiry %s is not a valid duration, e.g 720h", rule.Name, args.CertExpiry)
+			} else {
+				rule.CertExpiry = d
+			}
+		}
+		if len(args.Sinks) == 0 {
+			return nil, fmt.Errorf("alert rule %s must list the sinks it notifies", rule.Name)
+		}
+		for _, name := range args.Sinks {
+			if sink, found := sinks[name]; !found {
+				return nil, fmt.Errorf("alert rule %s sink %s is not configured", rule.Name, name)
+			} else {
+				rule.Sinks = append(rule.Sinks, sink)
+			}
+		}
+		alerts.Rules = append(alerts.Rules, rule)
+	}
+	return alerts, nil
+}
+
+//newAlertSink creates the sink of args.Type, returning an error if args are not valid for it
+func newAlertSink(name string, args AlertSinkArgs) (alertSink, error) {
+	switch args.Type {
+	case AlertSinkWebhook:
+		if endpoint, err := url.Parse(args.URL); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+			return nil, fmt.Errorf("alert sink %s url is not a valid http(s) url", name)
+		}
+		return &webhookSink{sinkName: name, url: args.URL, client: pnet.DefaultHttpClient()}, nil
+	case AlertSinkEmail:
+		if _, _, err := net.SplitHostPort(args.SMTP); err != nil {
+			return nil, fmt.Errorf("alert sink %s must provide the host:port of an smtp relay", name)
+		}
+		if len(args.From) == 0 || len(args.To) == 0 {
+			return nil, fmt.Errorf("alert sink %s must provide the from and to addresses of its email", name)
+		}
+		for _, address := range append([]string{args.From}, args.To...) {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return nil, fmt.Errorf("alert sink %s address %s is not a valid email address", name, address)
+			}
+		}
+		return &emailSink{sinkName: name, relay: args.SMTP, from: args.From, to: args.To}, nil
+	case AlertSinkFile:
+		if len(args.Path) == 0 {
+			return nil, fmt.Errorf("alert sink %s must provide a path", name)
+		}
+		if info, err := os.Stat(filepath.Dir(args.Path)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("alert sink %s directory %s does not exist", name, filepath.Dir(args.Path))
+		}
+		return &fileSink{sinkName: name, path: args.Path}, nil
+	default:
+		return nil, fmt.Errorf("alert sink %s type %s is not webhook, email or file", name, args.Type)
+	}
+}
+
+func (r AlertRule) alertsOn(event string) bool {
+	for _, e := range r.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//evaluate returns the alerts the results of a completed scan raise for this rule. Ports opened and closed are
+//found by comparing to the results of previous, the last completed run of the same schedule, and are not raised without it
+//A host down is raised only if it was not down in previous
+func (r AlertRule) evaluate(previous *types.QueryResponse, resp types.QueryResponse, now time.Time) []types.Alert {
+	alerts := make([]types.Alert, 0)
+	protocol := protocolOrDefault(resp.Protocol)
+	wasDown := make(map[string]bool)
+	if previous != nil {
+		for _, d := range diffResults(*previous, resp) {
+			alert := types.Alert{IP: d.IP, Target: d.Target}
+			if d.Change == types.DiffOpened && r.alertsOn(types.AlertPortOpened) {
+				alert.Event = types.AlertPortOpened
+				alert.Message = fmt.Sprintf("%s port %d opened, was %s", protocol, resp.ScanPort, formatAlertState(d.FromState))
+			} else if d.Change == types.DiffClosed && r.alertsOn(types.AlertPortClosed) {
+				alert.Event = types.AlertPortClosed
+				alert.Message = fmt.Sprintf("%s port %d is %s, was open", protocol, resp.ScanPort, formatAlertState(d.ToState))
+			} else {
+				continue
+			}
+			alerts = append(alerts, alert)
+		}
+		for _, status := range previous.Status {
+			wasDown[status.IP] = status.Host == types.HOST_DOWN
+		}
+	}
+	for _, status := range resp.Status {
+		if r.alertsOn(types.AlertCertExpiring) && status.State == types.OPEN && status.TLS != nil && status.TLS.Certificate != nil {
+			c := status.TLS.Certificate
+			if c.NotAfter.Before(now.Add(r.CertExpiry)) {
+				verb := "expires"
+				if c.NotAfter.Before(now) {
+					verb = "expired"
+				}
+				alerts = append(alerts, types.Alert{Event: types.AlertCertExpiring, IP: status.IP, Target: status.Target,
+					Message: fmt.Sprintf("certificate %s of %s port %d %s %s", c.Subject, protocol, resp.ScanPort, verb, c.NotAfter.Format("2006-01-02"))})
+			}
+		}
+		if r.alertsOn(types.AlertHostDown) && status.Host == types.HOST_DOWN && !wasDown[status.IP] {
+			alerts = append(alerts, types.Alert{Event: types.AlertHostDown, IP: status.IP, Target: status.Target, Message: "host is down, it did not answer a ping"})
+		}
+	}
+	return alerts
+}
+
+//formatAlertState describes the state of a port in one scan, which is missing if the scan did not include it
+func formatAlertState(s types.State) string {
+	if len(s) == 0 {
+		return "not scanned"
+	}
+	return string(s)
+}
+
+//sendAlerts evaluates every alert rule against the results of a completed job, notifying the sinks of those raising alerts
+func (s *server) sendAlerts(ctx context.Context, log *plog.Logger, alerts AlertConfiguration, j job, resp types.QueryResponse) {
+	var previous *types.QueryResponse
+	if j.Previous != 0 {
+		if p, found := s.jobs.Load(j.Previous); found && p.Ready {
+			previous = &p
+		}
+	}
+	now := time.Now()
+	for _, rule := range alerts.Rules {
+		raised := rule.evaluate(previous, resp, now)
+		if len(raised) == 0 {
+			continue
+		}
+		n := types.AlertNotification{
+			Rule:     rule.Name,
+			ScanID:   j.ScanID,
+			ScanPort: resp.ScanPort,
+			Protocol: resp.Protocol,
+			Schedule: j.Schedule,
+			Time:     now,
+			Alerts:   raised,
+		}
+		if previous != nil {
+			n.PreviousScanID = j.Previous
+		}
+		for _, sink := range rule.Sinks {
+			if ctx.Err() != nil {
+				log.Warn("alerts not sent, interrupted by shutdown", plog.Fields{"rule": rule.Name, "sink": sink.name()})
+				continue
+			}
+			sendCtx, span := s.tracer.Start(ctx, "alert.send")
+			span.SetKind(trace.SpanKindClient)
+			span.SetAttribute("rule", rule.Name)
+			span.SetAttribute("sink", sink.name())
+			span.SetAttribute("alerts", len(raised))
+			sendCtx, cancel := context.WithTimeout(sendCtx, alertSendTimeout)
+			err := sink.send(sendCtx, n)
+			cancel()
+			fields := plog.Fields{"rule": rule.Name, "sink": sink.name(), "alerts": len(raised)}
+			if err != nil {
+				span.SetError(err)
+				fields["error"] = err
+				log.Error("could not send alert", fields)
+			} else {
+				log.Info("alert sent", fields)
+			}
+			span.End()
+		}
+	}
+}
+
+//webhookSink posts each notification as JSON to a url
+type webhookSink struct {
+	sinkName string
+	url      string
+	client   *http.Client
+}
+
+func (w *webhookSink) name() string {
+	return w.sinkName
+}
+
+func (w *webhookSink) send(ctx context.Context, n types.AlertNotification) error {
+	bs, err := json.Marshal(&n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(trace.SpanFromContext(ctx).Context(), req.Header)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//emailSink mails each notification through an smtp relay, without tls or authentication, as suits a local relay
+type emailSink struct {
+	sinkName string
+	relay    string
+	from     string
+	to       []string
+}
+
+func (e *emailSink) name() string {
+	return e.sinkName
+}
+
+func (e *emailSink) send(ctx context.Context, n types.AlertNotification) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", e.relay)
+	if err != nil {
+		return err
+	}
+	defer closeOnDone(ctx, conn)()
+	host, _, _ := net.SplitHostPort(e.relay)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+//closeOnDone sets the deadline of ctx on conn, and closes conn should ctx be cancelled before the returned func is
+//called, so that reads and writes on it return
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+//message formats n as a plain text email, with a line per alert
+func (e *emailSink) message(n types.AlertNotification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: pscan alert %s: %d alerts from scan %d\r\n", n.Rule, len(n.Alerts), n.ScanID)
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, alert := range n.Alerts {
+		ip := alert.IP
+		if len(alert.Target) > 0 {
+			ip = fmt.Sprintf("%s (%s)", ip, alert.Target)
+		}
+		fmt.Fprintf(&b, "%s %s: %s\r\n", ip, alert.Event, alert.Message)
+	}
+	return b.Bytes()
+}
+
+//fileSink appends each notification to a file, as a line of JSON
+type fileSink struct {
+	sinkName string
+	path     string
+}
+
+func (f *fileSink) name() string {
+	return f.sinkName
+}
+
+func (f *fileSink) send(_ context.Context, n types.AlertNotification) error {
+	bs, err := json.Marshal(&n)
+	if err != nil {
+		return err
+	}
+	mu := pathMu(f.path)
+	mu.Lock()
+	defer mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(bs, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLineArgs_PrepareAlerts(t *testing.T) {
+	dir := filepath.Dir(writeConfigFile(t, ""))
+	path := writeConfigFile(t, `
+alerts:
+  sinks:
+    ops:
+      type: webhook
+      url: https://hooks.example.com/pscan
+    mail:
+      type: email
+      smtp: localhost:25
+      from: pscan@example.com
+      to: [ops@example.com]
+    log:
+      type: file
+      path: `+filepath.Join(dir, "alerts.jsonl")+`
+  rules:
+    - name: exposure
+      events: [port_opened, port_closed]
+      sinks: [ops, log]
+    - events: [cert_expiring]
+      cert_expiry: 168h
+      sinks: [mail]
+`)
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.Nil(t, err)
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	if assert.Len(t, config.Alerts.Rules, 2) {
+		exposure := config.Alerts.Rules[0]
+		assert.Equal(t, "exposure", exposure.Name)
+		assert.Equal(t, defaultCertExpiry, exposure.CertExpiry)
+		if assert.Len(t, exposure.Sinks, 2) {
+			assert.Equal(t, "ops", exposure.Sinks[0].name())
+			assert.Equal(t, "log", exposure.Sinks[1].name())
+		}
+		assert.Equal(t, "2", config.Alerts.Rules[1].Name)
+		assert.Equal(t, 7*24*time.Hour, config.Alerts.Rules[1].CertExpiry)
+	}
+
+	tests := []struct {
+		name   string
+		alerts AlertArgs
+		want   string
+	}{
+		{"event", AlertArgs{Rules: []AlertRuleArgs{{Name: "a", Events: []string{"port_changed"}}}}, "alert rule a event port_changed is not port_opened, port_closed, cert_expiring or host_down"},
+		{"no events", AlertArgs{Rules: []AlertRuleArgs{{Name: "a"}}}, "alert rule a must list the events it alerts on"},
+		{"cert expiry", AlertArgs{Rules: []AlertRuleArgs{{Name: "a", Events: []string{types.AlertCertExpiring}, CertExpiry: "30d"}}}, "alert rule a cert expiry 30d is not a valid duration, e.g 720h"},
+		{"no sinks", AlertArgs{Rules: []AlertRuleArgs{{Name: "a", Events: []string{types.AlertHostDown}}}}, "alert rule a must list the sinks it notifies"},
+		{"unknown sink", AlertArgs{Rules: []AlertRuleArgs{{Name: "a", Events: []string{types.AlertHostDown}, Sinks: []string{"ops"}}}}, "alert rule a sink ops is not configured"},
+		{"duplicate", AlertArgs{
+			Sinks: map[string]AlertSinkArgs{"log": {Type: AlertSinkFile, Path: filepath.Join(dir, "alerts.jsonl")}},
+			Rules: []AlertRuleArgs{{Name: "a", Events: []string{types.AlertHostDown}, Sinks: []string{"log"}}, {Name: "a", Events: []string{types.AlertHostDown}, Sinks: []string{"log"}}},
+		}, "alert rule a is declared more than once"},
+		{"sink type", AlertArgs{Sinks: map[string]AlertSinkArgs{"ops": {Type: "slack"}}}, "alert sink ops type slack is not webhook, email or file"},
+		{"webhook", AlertArgs{Sinks: map[string]AlertSinkArgs{"ops": {Type: AlertSinkWebhook, URL: "hooks.example.com"}}}, "alert sink ops url is not a valid http(s) url"},
+		{"relay", AlertArgs{Sinks: map[string]AlertSinkArgs{"mail": {Type: AlertSinkEmail, SMTP: "localhost"}}}, "alert sink mail must provide the host:port of an smtp relay"},
+		{"addresses", AlertArgs{Sinks: map[string]AlertSinkArgs{"mail": {Type: AlertSinkEmail, SMTP: "localhost:25", From: "pscan@example.com"}}}, "alert sink mail must provide the from and to addresses of its email"},
+		{"address", AlertArgs{Sinks: map[string]AlertSinkArgs{"mail": {Type: AlertSinkEmail, SMTP: "localhost:25", From: "pscan", To: []string{"ops@example.com"}}}}, "alert sink mail address pscan is not a valid email address"},
+		{"path", AlertArgs{Sinks: map[string]AlertSinkArgs{"log": {Type: AlertSinkFile}}}, "alert sink log must provide a path"},
+		{"directory", AlertArgs{Sinks: map[string]AlertSinkArgs{"log": {Type: AlertSinkFile, Path: "/nonexistent/alerts.jsonl"}}}, "alert sink log directory /nonexistent does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := tt.alerts
+			_, err := CommandLineArgs{Alerts: &alerts}.prepareAlerts()
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestAlertRule_Evaluate(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := func(notAfter time.Time) *types.TLSInfo {
+		return &types.TLSInfo{Certificate: &types.Certificate{Subject: "CN=example.com", NotAfter: notAfter}}
+	}
+	previous := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "10.0.0.1", State: types.CLOSED},
+		{IP: "10.0.0.2", State: types.OPEN},
+		{IP: "10.0.0.3", State: types.SKIPPED, Host: types.HOST_DOWN},
+	}}
+	resp := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "10.0.0.1", State: types.OPEN, TLS: cert(now.Add(24 * time.Hour))},
+		{IP: "10.0.0.2", State: types.SKIPPED, Host: types.HOST_DOWN},
+		{IP: "10.0.0.3", State: types.SKIPPED, Host: types.HOST_DOWN},
+		{IP: "10.0.0.4", State: types.OPEN, Target: "10.0.0.0/24", TLS: cert(now.Add(-time.Hour))},
+		{IP: "10.0.0.5", State: types.OPEN, TLS: cert(now.Add(365 * 24 * time.Hour))},
+	}}
+	rule := AlertRule{Events: []string{types.AlertPortOpened, types.AlertPortClosed, types.AlertCertExpiring, types.AlertHostDown}, CertExpiry: defaultCertExpiry}
+	assert.Equal(t, []types.Alert{
+		{Event: types.AlertPortOpened, IP: "10.0.0.1", Message: "tcp port 443 opened, was closed"},
+		{Event: types.AlertPortClosed, IP: "10.0.0.2", Message: "tcp port 443 is skipped, was open"},
+		{Event: types.AlertPortOpened, IP: "10.0.0.4", Target: "10.0.0.0/24", Message: "tcp port 443 opened, was not scanned"},
+		{Event: types.AlertPortOpened, IP: "10.0.0.5", Message: "tcp port 443 opened, was not scanned"},
+		{Event: types.AlertCertExpiring, IP: "10.0.0.1", Message: "certificate CN=example.com of tcp port 443 expires 2030-01-02"},
+		//10.0.0.3 was down in the previous run too
+		{Event: types.AlertHostDown, IP: "10.0.0.2", Message: "host is down, it did not answer a ping"},
+		{Event: types.AlertCertExpiring, IP: "10.0.0.4", Target: "10.0.0.0/24", Message: "certificate CN=example.com of tcp port 443 expired 2029-12-31"},
+	}, rule.evaluate(&previous, resp, now))
+
+	//without a previous run, only what the scan found alone is raised
+	rule = AlertRule{Events: []string{types.AlertPortOpened, types.AlertHostDown}}
+	assert.Equal(t, []types.Alert{
+		{Event: types.AlertHostDown, IP: "10.0.0.2", Message: "host is down, it did not answer a ping"},
+		{Event: types.AlertHostDown, IP: "10.0.0.3", Message: "host is down, it did not answer a ping"},
+	}, rule.evaluate(nil, resp, now))
+}
+
+var testNotification = types.AlertNotification{
+	Rule:     "exposure",
+	ScanID:   2,
+	ScanPort: 22,
+	Time:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	Alerts:   []types.Alert{{Event: types.AlertPortOpened, IP: "10.0.0.1", Target: "10.0.0.0/24", Message: "tcp port 22 opened, was closed"}},
+}
+
+func TestWebhookSink_Send(t *testing.T) {
+	var received types.AlertNotification
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := newAlertSink("ops", AlertSinkArgs{Type: AlertSinkWebhook, URL: server.URL})
+	assert.Nil(t, err)
+	assert.Nil(t, sink.send(context.Background(), testNotification))
+	assert.Equal(t, testNotification, received)
+
+	status = http.StatusBadGateway
+	assert.EqualError(t, sink.send(context.Background(), testNotification), "webhook answered with status 502")
+}
+
+func TestFileSink_Send(t *testing.T) {
+	path := filepath.Join(filepath.Dir(writeConfigFile(t, "")), "alerts.jsonl")
+	sink, err := newAlertSink("log", AlertSinkArgs{Type: AlertSinkFile, Path: path})
+	assert.Nil(t, err)
+	//a reload replaces the sink while the old one may still be sending, so both append to path
+	reloaded, err := newAlertSink("log", AlertSinkArgs{Type: AlertSinkFile, Path: path})
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for _, s := range []alertSink{sink, reloaded} {
+		wg.Add(1)
+		go func(s alertSink) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.Nil(t, s.send(context.Background(), testNotification))
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	bs, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if assert.Len(t, lines, 20) {
+		for _, line := range lines {
+			var n types.AlertNotification
+			assert.Nil(t, json.Unmarshal([]byte(line), &n))
+			assert.Equal(t, testNotification, n)
+		}
+	}
+}
+
+//smtpRelay accepts one message as a relay would, sending the commands and data received on commands when done
+func smtpRelay(t *testing.T) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	commands := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := make([]string, 0)
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			_, _ = conn.Write([]byte(s + "\r\n"))
+		}
+		reply("220 relay ready")
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			received = append(received, line)
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 queued")
+			case data:
+			case line == "DATA":
+				data = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				commands <- received
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		commands <- received
+	}()
+	return l.Addr().String(), commands
+}
+
+func TestEmailSink_Send(t *testing.T) {
+	relay, commands := smtpRelay(t)
+	sink, err := newAlertSink("mail", AlertSinkArgs{Type: AlertSinkEmail, SMTP: relay, From: "pscan@example.com", To: []string{"ops@example.com", "sec@example.com"}})
+	assert.Nil(t, err)
+	assert.Nil(t, sink.send(context.Background(), testNotification))
+
+	received := <-commands
+	assert.Contains(t, received, "MAIL FROM:<pscan@example.com>")
+	assert.Contains(t, received, "RCPT TO:<ops@example.com>")
+	assert.Contains(t, received, "RCPT TO:<sec@example.com>")
+	assert.Contains(t, received, "Subject: pscan alert exposure: 1 alerts from scan 2")
+	assert.Contains(t, received, "10.0.0.1 (10.0.0.0/24) port_opened: tcp port 22 opened, was closed")
+}
+
+func TestServer_ProcessJob_SendsAlerts(t *testing.T) {
+	port := openPort(t)
+	path := filepath.Join(filepath.Dir(writeConfigFile(t, "")), "alerts.jsonl")
+	alerts, err := CommandLineArgs{Alerts: &AlertArgs{
+		Sinks: map[string]AlertSinkArgs{"log": {Type: AlertSinkFile, Path: path}},
+		Rules: []AlertRuleArgs{{Name: "exposure", Events: []string{types.AlertPortOpened}, Sinks: []string{"log"}}},
+	}}.prepareAlerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the job store holds the result of the schedule's previous run
+	previous := func(s *server) {
+		s.jobs.Store(1, types.QueryResponse{Ready: true, ScanPort: port, Status: []types.IPStatus{{IP: "127.0.0.1", State: types.CLOSED}}})
+	}
+	runJobs(t, Configuration{Alerts: *alerts}, previous, job{ScanID: 2, Port: port, IPs: []string{"127.0.0.1"}, Schedule: 7, Previous: 1})
+
+	bs, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	var n types.AlertNotification
+	assert.Nil(t, json.Unmarshal(bs, &n))
+	assert.Equal(t, "exposure", n.Rule)
+	assert.Equal(t, uint64(2), n.ScanID)
+	assert.Equal(t, uint64(7), n.Schedule)
+	assert.Equal(t, uint64(1), n.PreviousScanID)
+	assert.Len(t, n.Alerts, 1)
+}
+
+//blockingSink sends nothing, returning only once ctx is done
+type blockingSink struct{}
+
+func (blockingSink) name() string {
+	return "blocking"
+}
+
+func (blockingSink) send(ctx context.Context, _ types.AlertNotification) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestServer_Drain_InterruptsAlerts(t *testing.T) {
+	port := openPort(t)
+	previous := func(s *server) {
+		s.jobs.Store(1, types.QueryResponse{Ready: true, ScanPort: port, Status: []types.IPStatus{{IP: "127.0.0.1", State: types.CLOSED}}})
+	}
+	s := startJobs(t, Configuration{Alerts: AlertConfiguration{Rules: []AlertRule{
+		{Name: "exposure", Events: []string{types.AlertPortOpened}, Sinks: []alertSink{blockingSink{}, blockingSink{}}},
+	}}}, previous, job{ScanID: 2, Port: port, IPs: []string{"127.0.0.1"}, Previous: 1})
+	start := time.Now()
+	s.drain(100 * time.Millisecond)
+	//alerts are interrupted at the drain timeout, rather than each sink being given alertSendTimeout
+	assert.True(t, time.Since(start) < alertSendTimeout)
+}
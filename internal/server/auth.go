@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+)
+
+const (
+	minAPIKeyLength = 16
+	apiKeyHeader    = "X-API-Key"
+)
+
+//AuthConfiguration holds the credentials accepted by this server
+//If there are no APIKeys, scan endpoints are open to anyone who can reach the server
+type AuthConfiguration struct {
+	APIKeys []string
+}
+
+//Enabled returns true if callers must present an api key
+func (a AuthConfiguration) Enabled() bool {
+	return len(a.APIKeys) > 0
+}
+
+//Allowed returns true if key is one of the configured api keys
+func (a AuthConfiguration) Allowed(key string) bool {
+	allowed := false
+	for _, k := range a.APIKeys {
+		//compare against every key, so timing does not reveal which key was close
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+//apiKey returns the key presented by r, either as a bearer token or in the X-API-Key header
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); len(key) > 0 {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+//withAuth rejects calls that do not present an allowed api key, when api keys are configured
+func (s *server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := s.currentConfig().Auth
+		if auth.Enabled() && !auth.Allowed(apiKey(r)) {
+			s.requestLog(r).Warn("unauthorized", plog.Fields{"path": r.URL.Path})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pscan"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
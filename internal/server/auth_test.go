@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAuth(t *testing.T) {
+	s := NewServer(Configuration{Auth: AuthConfiguration{APIKeys: []string{"0123456789abcdef"}}})
+	called := false
+	handler := s.withAuth(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/submit", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.False(t, called)
+
+	req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.False(t, called)
+
+	req = httptest.NewRequest(http.MethodPost, "/submit", nil)
+	req.Header.Set("Authorization", "Bearer 0123456789abcdef")
+	handler(httptest.NewRecorder(), req)
+	assert.True(t, called)
+
+	called = false
+	req = httptest.NewRequest(http.MethodPost, "/submit", nil)
+	req.Header.Set(apiKeyHeader, "0123456789abcdef")
+	handler(httptest.NewRecorder(), req)
+	assert.True(t, called)
+}
+
+func TestWithAuth_DisabledWithoutKeys(t *testing.T) {
+	s := NewServer(Configuration{})
+	called := false
+	s.withAuth(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/submit", nil))
+	assert.True(t, called)
+}
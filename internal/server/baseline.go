@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+//baselineFile is the unmodified contents of a baseline file, see CommandLineArgs.BaselineFile
+type baselineFile struct {
+	Rules []struct {
+		Name          string   `yaml:"name"`
+		Hosts         []string `yaml:"hosts"`
+		Protocol      string   `yaml:"protocol"`
+		AllowedPorts  []string `yaml:"allowed_ports"`
+		RequiredPorts []string `yaml:"required_ports"`
+	} `yaml:"rules"`
+}
+
+//Baseline declares the exposure expected of hosts. Completed scans are evaluated against it
+type Baseline struct {
+	//File is the file this Baseline was loaded from. Empty means there is no baseline
+	File  string
+	Rules []BaselineRule
+}
+
+//BaselineRule is the exposure expected of a set of hosts
+//An address is held to the first rule of a Baseline whose Hosts contain it and whose Protocol is that of the scan
+type BaselineRule struct {
+	Name     string
+	Hosts    []*net.IPNet
+	Protocol string
+	//AllowedPorts may be open. Any other port found open is a violation
+	AllowedPorts []PortRange
+	//RequiredPorts must be open, and are allowed to be
+	RequiredPorts []PortRange
+}
+
+func (c CommandLineArgs) prepareBaseline() (*Baseline, error) {
+	if len(c.BaselineFile) == 0 {
+		return &Baseline{}, nil
+	}
+	bs, err := ioutil.ReadFile(c.BaselineFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read baseline, error was: %s", err.Error())
+	}
+	var f baselineFile
+	if err := yaml.UnmarshalStrict(bs, &f); err != nil {
+		return nil, fmt.Errorf("baseline %s is not valid, error was: %s", c.BaselineFile, err.Error())
+	}
+	baseline := &Baseline{File: c.BaselineFile, Rules: make([]BaselineRule, 0, len(f.Rules))}
+	names := make(map[string]bool, len(f.Rules))
+	for i, args := range f.Rules {
+		rule := BaselineRule{Name: args.Name, Protocol: protocolOrDefault(args.Protocol)}
+		if len(rule.Name) == 0 {
+			rule.Name = strconv.Itoa(i + 1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("baseline rule %s is declared more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(args.Hosts) == 0 {
+			return nil, fmt.Errorf("baseline rule %s must list the hosts it applies to", rule.Name)
+		}
+		if rule.Protocol != types.TCP && rule.Protocol != types.UDP {
+			return nil, fmt.Errorf("baseline rule %s protocol %s is not tcp or udp", rule.Name, args.Protocol)
+		}
+		if rule.Hosts, err = parseTargets(args.Hosts); err != nil {
+			return nil, fmt.Errorf("baseline rule %s hosts are not valid: %s", rule.Name, err.Error())
+		}
+		if rule.AllowedPorts, err = parsePortRanges(args.AllowedPorts); err != nil {
+			return nil, fmt.Errorf("baseline rule %s allowed ports are not valid: %s", rule.Name, err.Error())
+		}
+		if rule.RequiredPorts, err = parsePortRanges(args.RequiredPorts); err != nil {
+			return nil, fmt.Errorf("baseline rule %s required ports are not valid: %s", rule.Name, err.Error())
+		}
+		baseline.Rules = append(baseline.Rules, rule)
+	}
+	return baseline, nil
+}
+
+//Enabled is true when a baseline file was given
+func (b Baseline) Enabled() bool {
+	return len(b.File) > 0
+}
+
+//rule returns the rule ip of a scan of protocol is held to, or nil if no rule applies to it
+func (b Baseline) rule(ip net.IP, protocol string) *BaselineRule {
+	for i, rule := range b.Rules {
+		if rule.Protocol == protocol && containsIP(rule.Hosts, ip) {
+			return &b.Rules[i]
+		}
+	}
+	return nil
+}
+
+//evaluate checks the port of every address of a completed scan against the rule each is held to
+func (b Baseline) evaluate(resp types.QueryResponse) *types.BaselineResult {
+	result := &types.BaselineResult{Violations: make([]types.BaselineViolation, 0)}
+	protocol := protocolOrDefault(resp.Protocol)
+	for _, status := range resp.Status {
+		ip, _ := pnet.ParseIP(status.IP)
+		if ip == nil {
+			continue
+		}
+		rule := b.rule(ip, protocol)
+		if rule == nil {
+			continue
+		}
+		result.Evaluated++
+		violation := types.BaselineViolation{IP: status.IP, Target: status.Target, Rule: rule.Name, State: status.State}
+		required := containsPort(rule.RequiredPorts, resp.ScanPort)
+		if status.State == types.OPEN && !required && !containsPort(rule.AllowedPorts, resp.ScanPort) {
+			violation.Reason = fmt.Sprintf("%s port %d is open, but is not allowed", protocol, resp.ScanPort)
+		} else if status.State != types.OPEN && required {
+			violation.Reason = fmt.Sprintf("%s port %d is required to be open, but is %s", protocol, resp.ScanPort, status.State)
+		} else {
+			continue
+		}
+		result.Violations = append(result.Violations, violation)
+	}
+	//a scan no rule applies to has not been checked, so does not pass
+	result.Passed = result.Evaluated > 0 && len(result.Violations) == 0
+	return result
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBaseline = `
+rules:
+  - name: web
+    hosts: [10.0.0.10, 10.0.1.0/28]
+    allowed_ports: [80]
+    required_ports: [443]
+  - name: dns
+    hosts: [10.0.0.0/24]
+    protocol: udp
+    allowed_ports: [53]
+  - hosts: [10.0.0.0/24]
+`
+
+func TestCommandLineArgs_PrepareBaseline(t *testing.T) {
+	path := writeConfigFile(t, testBaseline)
+	baseline, err := CommandLineArgs{BaselineFile: path}.prepareBaseline()
+	assert.Nil(t, err)
+	assert.True(t, baseline.Enabled())
+	if assert.Len(t, baseline.Rules, 3) {
+		assert.Equal(t, "web", baseline.Rules[0].Name)
+		assert.Equal(t, types.TCP, baseline.Rules[0].Protocol)
+		assert.Len(t, baseline.Rules[0].Hosts, 2)
+		assert.Equal(t, []PortRange{{80, 80}}, baseline.Rules[0].AllowedPorts)
+		assert.Equal(t, []PortRange{{443, 443}}, baseline.Rules[0].RequiredPorts)
+		assert.Equal(t, types.UDP, baseline.Rules[1].Protocol)
+		//unnamed rules are named by their position
+		assert.Equal(t, "3", baseline.Rules[2].Name)
+		assert.Empty(t, baseline.Rules[2].AllowedPorts)
+	}
+
+	none, err := CommandLineArgs{}.prepareBaseline()
+	assert.Nil(t, err)
+	assert.False(t, none.Enabled())
+
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"hosts", "rules:\n  - name: web\n", "baseline rule web must list the hosts it applies to"},
+		{"duplicate", "rules:\n  - name: web\n    hosts: [10.0.0.1]\n  - name: web\n    hosts: [10.0.0.2]\n", "baseline rule web is declared more than once"},
+		{"protocol", "rules:\n  - hosts: [10.0.0.1]\n    protocol: sctp\n", "baseline rule 1 protocol sctp is not tcp or udp"},
+		{"cidr", "rules:\n  - name: web\n    hosts: [10.0.0.0/33]\n", "baseline rule web hosts are not valid: 10.0.0.0/33 is not a valid cidr"},
+		{"ports", "rules:\n  - name: web\n    hosts: [10.0.0.1]\n    required_ports: [100-10]\n", "baseline rule web required ports are not valid: 100-10 is not a valid port or port range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CommandLineArgs{BaselineFile: writeConfigFile(t, tt.contents)}.prepareBaseline()
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+
+	_, err = CommandLineArgs{BaselineFile: writeConfigFile(t, "rule: []\n")}.prepareBaseline()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "field rule not found")
+}
+
+func TestBaseline_Evaluate(t *testing.T) {
+	baseline, err := CommandLineArgs{BaselineFile: writeConfigFile(t, testBaseline)}.prepareBaseline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := baseline.evaluate(types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "10.0.0.10", State: types.OPEN},
+		{IP: "10.0.1.2", State: types.CLOSED, Target: "10.0.1.0/28"},
+		{IP: "10.0.0.20", State: types.OPEN},
+		{IP: "10.0.0.21", State: types.CLOSED},
+		//no rule applies to addresses outside the baseline
+		{IP: "192.168.0.1", State: types.OPEN},
+	}})
+	assert.Equal(t, &types.BaselineResult{Passed: false, Evaluated: 4, Violations: []types.BaselineViolation{
+		{IP: "10.0.1.2", Target: "10.0.1.0/28", Rule: "web", State: types.CLOSED, Reason: "tcp port 443 is required to be open, but is closed"},
+		{IP: "10.0.0.20", Rule: "3", State: types.OPEN, Reason: "tcp port 443 is open, but is not allowed"},
+	}}, result)
+
+	result = baseline.evaluate(types.QueryResponse{Ready: true, ScanPort: 80, Status: []types.IPStatus{
+		{IP: "10.0.0.10", State: types.OPEN},
+		{IP: "10.0.0.11", State: types.CLOSED},
+	}})
+	assert.Equal(t, &types.BaselineResult{Passed: true, Evaluated: 2, Violations: []types.BaselineViolation{}}, result)
+
+	//rules apply only to scans of their protocol
+	result = baseline.evaluate(types.QueryResponse{Ready: true, ScanPort: 53, Protocol: types.UDP, Status: []types.IPStatus{
+		{IP: "10.0.0.10", State: types.OPEN_FILTERED},
+		{IP: "10.0.0.11", State: types.OPEN},
+	}})
+	assert.True(t, result.Passed)
+	assert.Equal(t, 2, result.Evaluated)
+
+	//a scan no rule applies to does not pass
+	result = baseline.evaluate(types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "192.168.0.1", State: types.OPEN},
+	}})
+	assert.Equal(t, &types.BaselineResult{Passed: false, Evaluated: 0, Violations: []types.BaselineViolation{}}, result)
+}
+
+func TestServer_ProcessJob_EvaluatesBaseline(t *testing.T) {
+	port := openPort(t)
+	path := writeConfigFile(t, "rules:\n  - name: loopback\n    hosts: [127.0.0.0/8]\n")
+	baseline, err := CommandLineArgs{BaselineFile: path}.prepareBaseline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := runJobs(t, Configuration{Baseline: *baseline}, nil, job{ScanID: 1, Port: port, IPs: []string{"127.0.0.1"}})
+
+	resp, found := s.jobs.Load(1)
+	assert.True(t, found)
+	if assert.NotNil(t, resp.Baseline) {
+		assert.False(t, resp.Baseline.Passed)
+		assert.Equal(t, []types.BaselineViolation{{IP: "127.0.0.1", Rule: "loopback", State: types.OPEN, Reason: fmt.Sprintf("tcp port %d is open, but is not allowed", port)}}, resp.Baseline.Violations)
+	}
+}
@@ -0,0 +1,808 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/internal/trace"
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/service"
+	"gopkg.in/yaml.v2"
+)
+
+//CommandLineArgs represents unmodified, direct arguments to start a port scan server
+//They may come from flags, environment variables or a config file, see LoadCommandLineArgs
+type CommandLineArgs struct {
+	ConfigFile string
+
+	ListenAddress string
+	ListenPort    string
+	//Listen replaces ListenAddress and ListenPort with any number of host:port, [ipv6]:port or unix:///path listeners
+	Listen   []string
+	LogLevel string
+
+	TraceExporter string
+	TraceEndpoint string
+	TraceFile     string
+
+	DialTimeout      string
+	ShutdownTimeout  string
+	DrainTimeout     string
+	ReadTimeout      string
+	WriteTimeout     string
+	BannerTimeout    string
+	TLSTimeout       string
+	HTTPTimeout      string
+	DiscoveryTimeout string
+	ScriptTimeout    string
+
+	MaxActiveJobs        string
+	MaxConcurrentProbes  string
+	MaxIPsPerScan        string
+	SubmitRate           string
+	SubmitBurst          string
+	MaxBannerBytes       string
+	MaxConcurrentScripts string
+
+	StorageType string
+	StoragePath string
+
+	APIKeys []string
+
+	AllowedTargets []string
+	DeniedTargets  []string
+	AllowedPorts   []string
+	DeniedPorts    []string
+
+	//Sources are the named source profiles scan requests may select. They are only read from the config file
+	Sources map[string]SourceArgs
+
+	//ServiceProbes is a file of service detection probes, tried before those built in
+	ServiceProbes string
+
+	//ScriptDir is the directory of scripts scan requests may run against open ports. Empty disables scripts
+	ScriptDir string
+
+	//BaselineFile is a file of the exposure expected of hosts, that completed scans are evaluated against
+	BaselineFile string
+
+	//Alerts are the rules evaluated against each completed scan, and the sinks they notify. They are only read from the config file
+	Alerts *AlertArgs
+
+	//Exporters are the named exporters the results of each completed scan are written to. They are only read from the config file
+	Exporters map[string]ExporterArgs
+}
+
+const (
+	defaultListenPort = "8080"
+	envPrefix         = "PSCAN_"
+)
+
+//argField binds one CommandLineArgs field to its environment variable
+type argField struct {
+	env  string
+	str  *string
+	list *[]string
+}
+
+func (c *CommandLineArgs) fields() []argField {
+	return []argField{
+		{env: "CONFIG", str: &c.ConfigFile},
+		{env: "LISTEN_ADDRESS", str: &c.ListenAddress},
+		{env: "LISTEN_PORT", str: &c.ListenPort},
+		{env: "LISTEN", list: &c.Listen},
+		{env: "LOG_LEVEL", str: &c.LogLevel},
+		{env: "TRACE_EXPORTER", str: &c.TraceExporter},
+		{env: "TRACE_ENDPOINT", str: &c.TraceEndpoint},
+		{env: "TRACE_FILE", str: &c.TraceFile},
+		{env: "DIAL_TIMEOUT", str: &c.DialTimeout},
+		{env: "SHUTDOWN_TIMEOUT", str: &c.ShutdownTimeout},
+		{env: "DRAIN_TIMEOUT", str: &c.DrainTimeout},
+		{env: "READ_TIMEOUT", str: &c.ReadTimeout},
+		{env: "WRITE_TIMEOUT", str: &c.WriteTimeout},
+		{env: "BANNER_TIMEOUT", str: &c.BannerTimeout},
+		{env: "TLS_TIMEOUT", str: &c.TLSTimeout},
+		{env: "HTTP_TIMEOUT", str: &c.HTTPTimeout},
+		{env: "DISCOVERY_TIMEOUT", str: &c.DiscoveryTimeout},
+		{env: "SCRIPT_TIMEOUT", str: &c.ScriptTimeout},
+		{env: "MAX_ACTIVE_JOBS", str: &c.MaxActiveJobs},
+		{env: "MAX_CONCURRENT_PROBES", str: &c.MaxConcurrentProbes},
+		{env: "MAX_IPS_PER_SCAN", str: &c.MaxIPsPerScan},
+		{env: "SUBMIT_RATE", str: &c.SubmitRate},
+		{env: "SUBMIT_BURST", str: &c.SubmitBurst},
+		{env: "MAX_BANNER_BYTES", str: &c.MaxBannerBytes},
+		{env: "MAX_CONCURRENT_SCRIPTS", str: &c.MaxConcurrentScripts},
+		{env: "STORAGE_TYPE", str: &c.StorageType},
+		{env: "STORAGE_PATH", str: &c.StoragePath},
+		{env: "API_KEYS", list: &c.APIKeys},
+		{env: "ALLOWED_TARGETS", list: &c.AllowedTargets},
+		{env: "DENIED_TARGETS", list: &c.DeniedTargets},
+		{env: "ALLOWED_PORTS", list: &c.AllowedPorts},
+		{env: "DENIED_PORTS", list: &c.DeniedPorts},
+		{env: "SERVICE_PROBES", str: &c.ServiceProbes},
+		{env: "SCRIPT_DIR", str: &c.ScriptDir},
+		{env: "BASELINE_FILE", str: &c.BaselineFile},
+	}
+}
+
+//override returns a copy of c, with every field set in o replacing the field in c
+func (c CommandLineArgs) override(o CommandLineArgs) CommandLineArgs {
+	dst, src := c.fields(), o.fields()
+	for i := range dst {
+		if dst[i].str != nil && len(*src[i].str) > 0 {
+			*dst[i].str = *src[i].str
+		} else if dst[i].list != nil && *src[i].list != nil {
+			*dst[i].list = *src[i].list
+		}
+	}
+	if o.Sources != nil {
+		c.Sources = o.Sources
+	}
+	if o.Alerts != nil {
+		c.Alerts = o.Alerts
+	}
+	if o.Exporters != nil {
+		c.Exporters = o.Exporters
+	}
+	return c
+}
+
+//LoadCommandLineArgs layers, in increasing order of precedence, defaults, the config file, environment variables and flags
+//flags should only have fields set for flags explicitly given. lookupEnv is typically os.LookupEnv
+//The config file is taken from flags, or the PSCAN_CONFIG environment variable
+func LoadCommandLineArgs(flags CommandLineArgs, lookupEnv func(string) (string, bool)) (CommandLineArgs, error) {
+	env := envArgs(lookupEnv)
+
+	path := env.ConfigFile
+	if len(flags.ConfigFile) > 0 {
+		path = flags.ConfigFile
+	}
+
+	args := CommandLineArgs{ListenPort: defaultListenPort}
+	if len(path) > 0 {
+		if file, err := readConfigFile(path); err != nil {
+			return CommandLineArgs{}, err
+		} else {
+			args = args.override(*file)
+		}
+	}
+	args = args.override(env).override(flags)
+	args.ConfigFile = path
+	return args, nil
+}
+
+func envArgs(lookupEnv func(string) (string, bool)) CommandLineArgs {
+	args := CommandLineArgs{}
+	for _, field := range args.fields() {
+		value, found := lookupEnv(envPrefix + field.env)
+		if !found {
+			continue
+		}
+		if field.str != nil {
+			*field.str = value
+		} else {
+			*field.list = splitList(value)
+		}
+	}
+	return args
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//fileConfig is the layout of the pscan config file
+//Leaves are strings so that they are validated, with the same messages, alongside flags and environment variables
+type fileConfig struct {
+	Listen struct {
+		Address   string   `yaml:"address"`
+		Port      string   `yaml:"port"`
+		Listeners []string `yaml:"listeners"`
+	} `yaml:"listen"`
+	Log struct {
+		Level string `yaml:"level"`
+	} `yaml:"log"`
+	Tracing struct {
+		Exporter string `yaml:"exporter"`
+		Endpoint string `yaml:"endpoint"`
+		File     string `yaml:"file"`
+	} `yaml:"tracing"`
+	Timeouts struct {
+		Dial      string `yaml:"dial"`
+		Shutdown  string `yaml:"shutdown"`
+		Drain     string `yaml:"drain"`
+		Read      string `yaml:"read"`
+		Write     string `yaml:"write"`
+		Banner    string `yaml:"banner"`
+		TLS       string `yaml:"tls"`
+		HTTP      string `yaml:"http"`
+		Discovery string `yaml:"discovery"`
+		Script    string `yaml:"script"`
+	} `yaml:"timeouts"`
+	Limits struct {
+		MaxActiveJobs        string `yaml:"max_active_jobs"`
+		MaxConcurrentProbes  string `yaml:"max_concurrent_probes"`
+		MaxIPsPerScan        string `yaml:"max_ips_per_scan"`
+		SubmitRate           string `yaml:"submit_rate"`
+		SubmitBurst          string `yaml:"submit_burst"`
+		MaxBannerBytes       string `yaml:"max_banner_bytes"`
+		MaxConcurrentScripts string `yaml:"max_concurrent_scripts"`
+	} `yaml:"limits"`
+	Storage struct {
+		Type string `yaml:"type"`
+		Path string `yaml:"path"`
+	} `yaml:"storage"`
+	Auth struct {
+		APIKeys []string `yaml:"api_keys"`
+	} `yaml:"auth"`
+	Policy struct {
+		AllowedTargets []string `yaml:"allowed_targets"`
+		DeniedTargets  []string `yaml:"denied_targets"`
+		AllowedPorts   []string `yaml:"allowed_ports"`
+		DeniedPorts    []string `yaml:"denied_ports"`
+	} `yaml:"policy"`
+	Sources   map[string]SourceArgs `yaml:"sources"`
+	Detection struct {
+		ProbesFile string `yaml:"probes_file"`
+	} `yaml:"detection"`
+	Scripts struct {
+		Directory string `yaml:"directory"`
+	} `yaml:"scripts"`
+	Baseline struct {
+		File string `yaml:"file"`
+	} `yaml:"baseline"`
+	Alerts    *AlertArgs              `yaml:"alerts"`
+	Exporters map[string]ExporterArgs `yaml:"exporters"`
+}
+
+func readConfigFile(path string) (*CommandLineArgs, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file, error was: %s", err.Error())
+	}
+	var f fileConfig
+	if err := yaml.UnmarshalStrict(bs, &f); err != nil {
+		return nil, fmt.Errorf("config file %s is not valid, error was: %s", path, err.Error())
+	}
+	return &CommandLineArgs{
+		ListenAddress:        f.Listen.Address,
+		ListenPort:           f.Listen.Port,
+		Listen:               f.Listen.Listeners,
+		LogLevel:             f.Log.Level,
+		TraceExporter:        f.Tracing.Exporter,
+		TraceEndpoint:        f.Tracing.Endpoint,
+		TraceFile:            f.Tracing.File,
+		DialTimeout:          f.Timeouts.Dial,
+		ShutdownTimeout:      f.Timeouts.Shutdown,
+		DrainTimeout:         f.Timeouts.Drain,
+		ReadTimeout:          f.Timeouts.Read,
+		WriteTimeout:         f.Timeouts.Write,
+		BannerTimeout:        f.Timeouts.Banner,
+		TLSTimeout:           f.Timeouts.TLS,
+		HTTPTimeout:          f.Timeouts.HTTP,
+		DiscoveryTimeout:     f.Timeouts.Discovery,
+		ScriptTimeout:        f.Timeouts.Script,
+		MaxActiveJobs:        f.Limits.MaxActiveJobs,
+		MaxConcurrentProbes:  f.Limits.MaxConcurrentProbes,
+		MaxIPsPerScan:        f.Limits.MaxIPsPerScan,
+		SubmitRate:           f.Limits.SubmitRate,
+		SubmitBurst:          f.Limits.SubmitBurst,
+		MaxBannerBytes:       f.Limits.MaxBannerBytes,
+		MaxConcurrentScripts: f.Limits.MaxConcurrentScripts,
+		StorageType:          f.Storage.Type,
+		StoragePath:          f.Storage.Path,
+		APIKeys:              f.Auth.APIKeys,
+		AllowedTargets:       f.Policy.AllowedTargets,
+		DeniedTargets:        f.Policy.DeniedTargets,
+		AllowedPorts:         f.Policy.AllowedPorts,
+		DeniedPorts:          f.Policy.DeniedPorts,
+		Sources:              f.Sources,
+		ServiceProbes:        f.Detection.ProbesFile,
+		ScriptDir:            f.Scripts.Directory,
+		BaselineFile:         f.Baseline.File,
+		Alerts:               f.Alerts,
+		Exporters:            f.Exporters,
+	}, nil
+}
+
+//ValidateAndPrepare for a CommandLineArgs prepares a server configuration if the arguments given are valid
+//Arguments left empty, other than the listen port, take their defaults
+//ValidateAndPrepare will return an error if these CommandLineArgs are not valid
+func (c CommandLineArgs) ValidateAndPrepare() (*Configuration, error) {
+	config := &Configuration{
+		ConfigFile: c.ConfigFile,
+	}
+
+	if len(c.ListenPort) == 0 {
+		return nil, fmt.Errorf("must provide a listen port")
+	} else if port, err := strconv.ParseUint(c.ListenPort, 10, 32); err != nil {
+		return nil, fmt.Errorf("listen port is not valid")
+	} else if !pnet.ValidPort(uint(port)) {
+		return nil, fmt.Errorf("listen port is not within valid port range")
+	} else {
+		config.ListenPort = uint(port)
+	}
+
+	if !validListenHost(c.ListenAddress) {
+		return nil, fmt.Errorf("listen address %s is not a valid ip address", c.ListenAddress)
+	} else {
+		config.ListenAddress = c.ListenAddress
+	}
+
+	if listeners, err := c.prepareListeners(); err != nil {
+		return nil, err
+	} else {
+		config.Listeners = listeners
+	}
+
+	if level, err := plog.ParseLevel(c.LogLevel); err != nil {
+		return nil, err
+	} else {
+		config.LogLevel = level
+	}
+
+	if tracing, err := c.prepareTracing(); err != nil {
+		return nil, err
+	} else {
+		config.Tracing = *tracing
+	}
+
+	if timeouts, err := c.prepareTimeouts(); err != nil {
+		return nil, err
+	} else {
+		config.Timeouts = *timeouts
+	}
+
+	if limits, err := c.prepareLimits(); err != nil {
+		return nil, err
+	} else {
+		config.Limits = *limits
+	}
+
+	if storage, err := c.prepareStorage(); err != nil {
+		return nil, err
+	} else {
+		config.Storage = *storage
+	}
+
+	if auth, err := c.prepareAuth(); err != nil {
+		return nil, err
+	} else {
+		config.Auth = *auth
+	}
+
+	if policy, err := c.preparePolicy(); err != nil {
+		return nil, err
+	} else {
+		config.Policy = *policy
+	}
+
+	if sources, err := c.prepareSources(); err != nil {
+		return nil, err
+	} else {
+		config.Sources = sources
+	}
+
+	if detection, err := c.prepareDetection(); err != nil {
+		return nil, err
+	} else {
+		config.Detection = *detection
+	}
+
+	if scripts, err := c.prepareScripts(); err != nil {
+		return nil, err
+	} else {
+		config.Scripts = *scripts
+	}
+
+	if baseline, err := c.prepareBaseline(); err != nil {
+		return nil, err
+	} else {
+		config.Baseline = *baseline
+	}
+
+	if alerts, err := c.prepareAlerts(); err != nil {
+		return nil, err
+	} else {
+		config.Alerts = *alerts
+	}
+
+	if export, err := c.prepareExport(); err != nil {
+		return nil, err
+	} else {
+		config.Export = *export
+	}
+
+	return config, nil
+}
+
+func (c CommandLineArgs) prepareTracing() (*TracingConfiguration, error) {
+	switch c.TraceExporter {
+	case "", TraceExporterNone:
+		return &TracingConfiguration{Exporter: TraceExporterNone}, nil
+	case TraceExporterOTLP:
+		if len(c.TraceEndpoint) == 0 {
+			return nil, fmt.Errorf("must provide a trace endpoint for the otlp trace exporter")
+		} else if endpoint, err := url.Parse(c.TraceEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+			return nil, fmt.Errorf("trace endpoint is not a valid http(s) url")
+		}
+		return &TracingConfiguration{Exporter: TraceExporterOTLP, Endpoint: c.TraceEndpoint}, nil
+	case TraceExporterFile:
+		if len(c.TraceFile) == 0 {
+			return nil, fmt.Errorf("must provide a trace file for the file trace exporter")
+		}
+		return &TracingConfiguration{Exporter: TraceExporterFile, File: c.TraceFile}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid trace exporter", c.TraceExporter)
+	}
+}
+
+func (c CommandLineArgs) prepareTimeouts() (*TimeoutConfiguration, error) {
+	timeouts := &TimeoutConfiguration{}
+	for _, t := range []struct {
+		name  string
+		value string
+		out   *time.Duration
+	}{
+		{"dial", c.DialTimeout, &timeouts.Dial},
+		{"shutdown", c.ShutdownTimeout, &timeouts.Shutdown},
+		{"drain", c.DrainTimeout, &timeouts.Drain},
+		{"read", c.ReadTimeout, &timeouts.Read},
+		{"write", c.WriteTimeout, &timeouts.Write},
+		{"banner", c.BannerTimeout, &timeouts.Banner},
+		{"tls", c.TLSTimeout, &timeouts.TLS},
+		{"http", c.HTTPTimeout, &timeouts.HTTP},
+		{"discovery", c.DiscoveryTimeout, &timeouts.Discovery},
+		{"script", c.ScriptTimeout, &timeouts.Script},
+	} {
+		if len(t.value) == 0 {
+			continue
+		} else if d, err := time.ParseDuration(t.value); err != nil {
+			return nil, fmt.Errorf("%s timeout %s is not a valid duration, e.g 5s", t.name, t.value)
+		} else if d <= 0 {
+			return nil, fmt.Errorf("%s timeout must be greater than zero", t.name)
+		} else {
+			*t.out = d
+		}
+	}
+	return timeouts, nil
+}
+
+func (c CommandLineArgs) prepareLimits() (*LimitConfiguration, error) {
+	limits := &LimitConfiguration{}
+	for _, l := range []struct {
+		name  string
+		value string
+		out   *uint
+	}{
+		{"max active jobs", c.MaxActiveJobs, &limits.MaxActiveJobs},
+		{"max concurrent probes", c.MaxConcurrentProbes, &limits.MaxConcurrentProbes},
+		{"max ips per scan", c.MaxIPsPerScan, &limits.MaxIPsPerScan},
+		{"submit burst", c.SubmitBurst, &limits.SubmitBurst},
+		{"max banner bytes", c.MaxBannerBytes, &limits.MaxBannerBytes},
+		{"max concurrent scripts", c.MaxConcurrentScripts, &limits.MaxConcurrentScripts},
+	} {
+		if len(l.value) == 0 {
+			continue
+		} else if n, err := strconv.ParseUint(l.value, 10, 32); err != nil || n == 0 {
+			return nil, fmt.Errorf("%s must be a positive number", l.name)
+		} else {
+			*l.out = uint(n)
+		}
+	}
+	if limits.MaxBannerBytes > maxBannerBytes {
+		return nil, fmt.Errorf("max banner bytes may be at most %d", maxBannerBytes)
+	}
+	if len(c.SubmitRate) > 0 {
+		if rate, err := strconv.ParseFloat(c.SubmitRate, 64); err != nil || rate <= 0 {
+			return nil, fmt.Errorf("submit rate must be a positive number of submissions per second")
+		} else {
+			limits.SubmitRate = rate
+		}
+	}
+	return limits, nil
+}
+
+func (c CommandLineArgs) prepareStorage() (*StorageConfiguration, error) {
+	switch c.StorageType {
+	case "", StorageMemory:
+		return &StorageConfiguration{Type: StorageMemory}, nil
+	case StorageFile:
+		if len(c.StoragePath) == 0 {
+			return nil, fmt.Errorf("must provide a storage path for file storage")
+		}
+		return &StorageConfiguration{Type: StorageFile, Path: c.StoragePath}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid storage type", c.StorageType)
+	}
+}
+
+func (c CommandLineArgs) prepareAuth() (*AuthConfiguration, error) {
+	auth := &AuthConfiguration{}
+	for _, key := range c.APIKeys {
+		if len(key) < minAPIKeyLength {
+			return nil, fmt.Errorf("api keys must be at least %d characters", minAPIKeyLength)
+		}
+		auth.APIKeys = append(auth.APIKeys, key)
+	}
+	return auth, nil
+}
+
+func (c CommandLineArgs) preparePolicy() (*Policy, error) {
+	policy := &Policy{}
+	var err error
+	if policy.AllowedTargets, err = parseTargets(c.AllowedTargets); err != nil {
+		return nil, fmt.Errorf("allowed targets are not valid: %s", err.Error())
+	}
+	if policy.DeniedTargets, err = parseTargets(c.DeniedTargets); err != nil {
+		return nil, fmt.Errorf("denied targets are not valid: %s", err.Error())
+	}
+	if policy.AllowedPorts, err = parsePortRanges(c.AllowedPorts); err != nil {
+		return nil, fmt.Errorf("allowed ports are not valid: %s", err.Error())
+	}
+	if policy.DeniedPorts, err = parsePortRanges(c.DeniedPorts); err != nil {
+		return nil, fmt.Errorf("denied ports are not valid: %s", err.Error())
+	}
+	return policy, nil
+}
+
+func (c CommandLineArgs) prepareDetection() (*DetectionConfiguration, error) {
+	if len(c.ServiceProbes) == 0 {
+		return &DetectionConfiguration{}, nil
+	}
+	f, err := os.Open(c.ServiceProbes)
+	if err != nil {
+		return nil, fmt.Errorf("could not read service probes, error was: %s", err.Error())
+	}
+	defer f.Close()
+	probes, err := service.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("service probes %s are not valid, error was: %s", c.ServiceProbes, err.Error())
+	}
+	return &DetectionConfiguration{ProbesFile: c.ServiceProbes, Probes: service.Combine(probes, service.Default())}, nil
+}
+
+func (c CommandLineArgs) prepareScripts() (*ScriptConfiguration, error) {
+	if len(c.ScriptDir) == 0 {
+		return &ScriptConfiguration{}, nil
+	}
+	if info, err := os.Stat(c.ScriptDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("script directory %s is not a directory", c.ScriptDir)
+	}
+	return &ScriptConfiguration{Directory: c.ScriptDir}, nil
+}
+
+//Configuration represents the runtime configuration for this port scan server
+//The zero value of each field, other than ListenPort, takes a default
+type Configuration struct {
+	//ConfigFile is the file this Configuration was loaded from, if any
+	ConfigFile string
+
+	//ListenAddress is the ip address to listen on. Empty listens on all interfaces
+	ListenAddress string
+	ListenPort    uint
+	//Listeners, if given, are listened on instead of ListenAddress and ListenPort
+	Listeners []ListenerConfiguration
+	//LogLevel is the minimum level of log lines written. The zero value logs at info
+	LogLevel plog.Level
+	Tracing  TracingConfiguration
+	Timeouts TimeoutConfiguration
+	Limits   LimitConfiguration
+	Storage  StorageConfiguration
+	Auth     AuthConfiguration
+	Policy   Policy
+	//Sources are the source profiles a scan request may select by name
+	Sources   map[string]SourceProfile
+	Detection DetectionConfiguration
+	Scripts   ScriptConfiguration
+	Baseline  Baseline
+	Alerts    AlertConfiguration
+	Export    ExportConfiguration
+}
+
+//ScriptConfiguration describes the scripts scan requests may run against open ports
+type ScriptConfiguration struct {
+	//Directory holds the scripts, which are run from it by name. Empty disables scripts
+	Directory string
+}
+
+//DetectionConfiguration holds the probes used to identify services
+type DetectionConfiguration struct {
+	//ProbesFile is the file probes were added from, if any
+	ProbesFile string
+	//Probes are the probes of ProbesFile followed by those built in. Nil uses only those built in
+	Probes *service.Database
+}
+
+//TimeoutConfiguration holds the time limits the server places on work
+type TimeoutConfiguration struct {
+	//Dial is how long a single probe waits for a connection
+	Dial time.Duration
+	//Shutdown is how long active HTTP calls are given to complete on shutdown
+	Shutdown time.Duration
+	//Drain is how long running jobs are given to complete on shutdown, before they are checkpointed
+	Drain time.Duration
+	//Read and Write bound the time spent reading a request and writing a response
+	Read  time.Duration
+	Write time.Duration
+	//Banner is how long an open port is given to send its banner, when banners are requested
+	Banner time.Duration
+	//TLS is how long each tls handshake is given, when tls inspection is requested
+	TLS time.Duration
+	//HTTP is how long an open port is given to answer every request of its fingerprint, when one is requested
+	HTTP time.Duration
+	//Discovery is how long a host is given to answer any of its pings, when host discovery is requested
+	Discovery time.Duration
+	//Script is how long each script is given to run against an open port
+	Script time.Duration
+}
+
+//scanTimeouts returns the timeouts of a single probe
+func (t TimeoutConfiguration) scanTimeouts() scanner.Timeouts {
+	return scanner.Timeouts{Dial: t.Dial, Banner: t.Banner, TLS: t.TLS, HTTP: t.HTTP, Discovery: t.Discovery, Script: t.Script}
+}
+
+//LimitConfiguration bounds the work the server will take on
+type LimitConfiguration struct {
+	//MaxActiveJobs is the number of unfinished jobs at which the server reports itself not ready
+	MaxActiveJobs uint
+	//MaxConcurrentProbes bounds the number of dials in progress across all jobs
+	MaxConcurrentProbes uint
+	//MaxIPsPerScan bounds the number of ips in a single scan request
+	MaxIPsPerScan uint
+	//SubmitRate is the sustained number of scan submissions accepted per second. Zero is unlimited
+	SubmitRate float64
+	//SubmitBurst is the number of submissions accepted at once, above SubmitRate
+	SubmitBurst uint
+	//MaxBannerBytes is the most read from an open port when banners are requested
+	MaxBannerBytes uint
+	//MaxConcurrentScripts bounds the number of scripts running across all jobs
+	MaxConcurrentScripts uint
+}
+
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
+//StorageConfiguration describes where scan results are kept
+type StorageConfiguration struct {
+	Type string
+	//Path is the file results are kept in, used by file storage
+	Path string
+}
+
+//persistent returns true if results and checkpoints survive a restart
+func (c StorageConfiguration) persistent() bool {
+	return c.Type == StorageFile
+}
+
+const (
+	defaultDialTimeout          = 5 * time.Second
+	defaultShutdownTimeout      = 10 * time.Second
+	defaultDrainTimeout         = 30 * time.Second
+	defaultReadTimeout          = 30 * time.Second
+	defaultWriteTimeout         = 30 * time.Second
+	defaultBannerTimeout        = 2 * time.Second
+	defaultTLSTimeout           = 5 * time.Second
+	defaultHTTPTimeout          = 5 * time.Second
+	defaultDiscoveryTimeout     = 1 * time.Second
+	defaultScriptTimeout        = 10 * time.Second
+	defaultMaxActiveJobs        = 1000
+	defaultMaxConcurrentProbes  = 512
+	defaultMaxIPsPerScan        = 1024
+	defaultMaxBannerBytes       = 256
+	defaultMaxConcurrentScripts = 4
+	maxBannerBytes              = 4096
+)
+
+//withDefaults returns a copy of c, with defaults in place of zero values
+func (c Configuration) withDefaults() Configuration {
+	if c.Timeouts.Dial == 0 {
+		c.Timeouts.Dial = defaultDialTimeout
+	}
+	if c.Timeouts.Shutdown == 0 {
+		c.Timeouts.Shutdown = defaultShutdownTimeout
+	}
+	if c.Timeouts.Drain == 0 {
+		c.Timeouts.Drain = defaultDrainTimeout
+	}
+	if c.Timeouts.Read == 0 {
+		c.Timeouts.Read = defaultReadTimeout
+	}
+	if c.Timeouts.Write == 0 {
+		c.Timeouts.Write = defaultWriteTimeout
+	}
+	if c.Timeouts.Banner == 0 {
+		c.Timeouts.Banner = defaultBannerTimeout
+	}
+	if c.Timeouts.TLS == 0 {
+		c.Timeouts.TLS = defaultTLSTimeout
+	}
+	if c.Timeouts.HTTP == 0 {
+		c.Timeouts.HTTP = defaultHTTPTimeout
+	}
+	if c.Timeouts.Discovery == 0 {
+		c.Timeouts.Discovery = defaultDiscoveryTimeout
+	}
+	if c.Timeouts.Script == 0 {
+		c.Timeouts.Script = defaultScriptTimeout
+	}
+	if c.Limits.MaxActiveJobs == 0 {
+		c.Limits.MaxActiveJobs = defaultMaxActiveJobs
+	}
+	if c.Limits.MaxConcurrentProbes == 0 {
+		c.Limits.MaxConcurrentProbes = defaultMaxConcurrentProbes
+	}
+	if c.Limits.MaxIPsPerScan == 0 {
+		c.Limits.MaxIPsPerScan = defaultMaxIPsPerScan
+	}
+	if c.Limits.MaxBannerBytes == 0 {
+		c.Limits.MaxBannerBytes = defaultMaxBannerBytes
+	}
+	if c.Limits.MaxConcurrentScripts == 0 {
+		c.Limits.MaxConcurrentScripts = defaultMaxConcurrentScripts
+	}
+	if c.Limits.SubmitRate > 0 && c.Limits.SubmitBurst == 0 {
+		c.Limits.SubmitBurst = uint(math.Ceil(c.Limits.SubmitRate))
+	}
+	if len(c.Storage.Type) == 0 {
+		c.Storage.Type = StorageMemory
+	}
+	if c.Detection.Probes == nil {
+		c.Detection.Probes = service.Default()
+	}
+	return c
+}
+
+//listeners returns Listeners, or when none are given, a single tcp listener on ListenAddress and ListenPort
+func (c Configuration) listeners() []ListenerConfiguration {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfiguration{{
+		Network: ListenTCP,
+		Address: net.JoinHostPort(c.ListenAddress, strconv.FormatUint(uint64(c.ListenPort), 10)),
+	}}
+}
+
+const (
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp"
+	TraceExporterFile = "file"
+)
+
+//TracingConfiguration describes where spans are exported. The zero value exports nothing
+type TracingConfiguration struct {
+	Exporter string
+	//Endpoint is the OTLP/HTTP traces url, used by the otlp exporter
+	Endpoint string
+	//File is the path spans are appended to, used by the file exporter
+	File string
+}
+
+func (t TracingConfiguration) exporter() trace.Exporter {
+	switch t.Exporter {
+	case TraceExporterOTLP:
+		return trace.NewOTLPExporter(t.Endpoint, "pscan", pnet.DefaultHttpClient())
+	case TraceExporterFile:
+		return trace.NewFileExporter(t.File)
+	default:
+		return nil
+	}
+}
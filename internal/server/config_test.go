@@ -0,0 +1,204 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "pscan-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "pscan.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := vars[name]
+		return value, found
+	}
+}
+
+const testConfigFile = `
+listen:
+  address: 127.0.0.1
+  port: 9000
+log:
+  level: warn
+timeouts:
+  dial: 2s
+limits:
+  max_concurrent_probes: 64
+auth:
+  api_keys: ["0123456789abcdef"]
+policy:
+  allowed_targets: [10.0.0.0/8]
+  allowed_ports: [22, "8000-8100"]
+`
+
+func TestLoadCommandLineArgs_Defaults(t *testing.T) {
+	args, err := LoadCommandLineArgs(CommandLineArgs{}, env(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, CommandLineArgs{ListenPort: defaultListenPort}, args)
+}
+
+func TestLoadCommandLineArgs_Precedence(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path, ListenPort: "9002"}, env(map[string]string{
+		"PSCAN_LISTEN_PORT":     "9001",
+		"PSCAN_LOG_LEVEL":       "debug",
+		"PSCAN_DENIED_TARGETS":  "10.0.0.1, 10.0.0.2",
+		"PSCAN_UNRELATED_THING": "ignored",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, path, args.ConfigFile)
+	//flag over environment over file
+	assert.Equal(t, "9002", args.ListenPort)
+	//environment over file
+	assert.Equal(t, "debug", args.LogLevel)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, args.DeniedTargets)
+	//file over defaults
+	assert.Equal(t, "127.0.0.1", args.ListenAddress)
+	assert.Equal(t, "2s", args.DialTimeout)
+	assert.Equal(t, []string{"22", "8000-8100"}, args.AllowedPorts)
+}
+
+func TestLoadCommandLineArgs_ConfigFileFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	args, err := LoadCommandLineArgs(CommandLineArgs{}, env(map[string]string{"PSCAN_CONFIG": path}))
+	assert.Nil(t, err)
+	assert.Equal(t, "9000", args.ListenPort)
+}
+
+func TestLoadCommandLineArgs_Listeners(t *testing.T) {
+	path := writeConfigFile(t, "listen:\n  listeners: [\"127.0.0.1:8080\", \"unix:///run/pscan.sock\"]\n")
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8080", "unix:///run/pscan.sock"}, args.Listen)
+
+	args, err = LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(map[string]string{"PSCAN_LISTEN": "[::1]:8080"}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"[::1]:8080"}, args.Listen)
+}
+
+func TestLoadCommandLineArgs_BadConfigFile(t *testing.T) {
+	_, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: "/does/not/exist.yaml"}, env(nil))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not read config file")
+
+	path := writeConfigFile(t, "listen:\n  prot: 8080\n")
+	_, err = LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "field prot not found")
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_FromConfigFile(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.Nil(t, err)
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, path, config.ConfigFile)
+	assert.Equal(t, "127.0.0.1", config.ListenAddress)
+	assert.Equal(t, uint(9000), config.ListenPort)
+	assert.Equal(t, 2*time.Second, config.Timeouts.Dial)
+	assert.Equal(t, uint(64), config.Limits.MaxConcurrentProbes)
+	assert.True(t, config.Auth.Enabled())
+	assert.Len(t, config.Policy.AllowedTargets, 1)
+	assert.Equal(t, []PortRange{{22, 22}, {8000, 8100}}, config.Policy.AllowedPorts)
+	assert.Equal(t, StorageMemory, config.Storage.Type)
+
+	limited := Configuration{Limits: LimitConfiguration{SubmitRate: 2.5}}.withDefaults()
+	assert.Equal(t, uint(3), limited.Limits.SubmitBurst)
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args CommandLineArgs
+		want string
+	}{
+		{"listen address", CommandLineArgs{ListenAddress: "nowhere"}, "listen address nowhere is not a valid ip address"},
+		{"timeout", CommandLineArgs{DialTimeout: "5"}, "dial timeout 5 is not a valid duration, e.g 5s"},
+		{"negative timeout", CommandLineArgs{ShutdownTimeout: "-1s"}, "shutdown timeout must be greater than zero"},
+		{"limit", CommandLineArgs{MaxIPsPerScan: "0"}, "max ips per scan must be a positive number"},
+		{"banner bytes", CommandLineArgs{MaxBannerBytes: "65536"}, "max banner bytes may be at most 4096"},
+		{"submit rate", CommandLineArgs{SubmitRate: "fast"}, "submit rate must be a positive number of submissions per second"},
+		{"storage type", CommandLineArgs{StorageType: "s3"}, "s3 is not a valid storage type"},
+		{"storage path", CommandLineArgs{StorageType: StorageFile}, "must provide a storage path for file storage"},
+		{"api key", CommandLineArgs{APIKeys: []string{"short"}}, "api keys must be at least 16 characters"},
+		{"target", CommandLineArgs{AllowedTargets: []string{"10.0.0.0/33"}}, "allowed targets are not valid: 10.0.0.0/33 is not a valid cidr"},
+		{"port", CommandLineArgs{DeniedPorts: []string{"100-10"}}, "denied ports are not valid: 100-10 is not a valid port or port range"},
+		{"script dir", CommandLineArgs{ScriptDir: "/nonexistent/scripts"}, "script directory /nonexistent/scripts is not a directory"},
+		{"scripts", CommandLineArgs{MaxConcurrentScripts: "none"}, "max concurrent scripts must be a positive number"},
+		{"baseline", CommandLineArgs{BaselineFile: "/nonexistent/baseline.yaml"}, "could not read baseline, error was: open /nonexistent/baseline.yaml: no such file or directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args.ListenPort = "8080"
+			config, err := tt.args.ValidateAndPrepare()
+			assert.Nil(t, config)
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestConfiguration_WithDefaults(t *testing.T) {
+	config := Configuration{Timeouts: TimeoutConfiguration{Dial: time.Second}}.withDefaults()
+	assert.Equal(t, time.Second, config.Timeouts.Dial)
+	assert.Equal(t, defaultShutdownTimeout, config.Timeouts.Shutdown)
+	assert.Equal(t, uint(defaultMaxActiveJobs), config.Limits.MaxActiveJobs)
+	assert.Equal(t, StorageMemory, config.Storage.Type)
+
+	limited := Configuration{Limits: LimitConfiguration{SubmitRate: 2.5}}.withDefaults()
+	assert.Equal(t, uint(3), limited.Limits.SubmitBurst)
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_ServiceProbes(t *testing.T) {
+	path := writeConfigFile(t, "Probe TCP NULL q||\nmatch acme m|^ACME (\\d+)| p/Acme server/ v/$1/\n")
+	config, err := CommandLineArgs{ListenPort: "8080", ServiceProbes: path}.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, path, config.Detection.ProbesFile)
+	assert.Equal(t, "NULL", config.Detection.Probes.Probes[0].Name)
+	assert.Len(t, config.Detection.Probes.Probes, len(service.Default().Probes)+1)
+
+	path = writeConfigFile(t, "Probe TCP NULL q||\nmatch acme m|(|\n")
+	_, err = CommandLineArgs{ListenPort: "8080", ServiceProbes: path}.ValidateAndPrepare()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "are not valid, error was: line 2: match acme regex is not valid")
+
+	defaults := Configuration{}.withDefaults()
+	assert.Equal(t, service.Default(), defaults.Detection.Probes)
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_Scripts(t *testing.T) {
+	path := writeConfigFile(t, "")
+	dir := filepath.Dir(path)
+	path = writeConfigFile(t, "scripts:\n  directory: "+dir+"\ntimeouts:\n  script: 30s\nlimits:\n  max_concurrent_scripts: 2\n")
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.Nil(t, err)
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, dir, config.Scripts.Directory)
+	assert.Equal(t, 30*time.Second, config.Timeouts.Script)
+	assert.Equal(t, uint(2), config.Limits.MaxConcurrentScripts)
+
+	defaults := Configuration{}.withDefaults()
+	assert.Equal(t, "", defaults.Scripts.Directory)
+	assert.Equal(t, defaultScriptTimeout, defaults.Timeouts.Script)
+	assert.Equal(t, uint(defaultMaxConcurrentScripts), defaults.Limits.MaxConcurrentScripts)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//diffResults returns how the port of each address differs from the from scan to the to scan, ordered by address
+//Addresses only one of the scans included are compared as if the other found no state for them
+func diffResults(from, to types.QueryResponse) []types.PortDiff {
+	before := make(map[string]types.IPStatus, len(from.Status))
+	for _, status := range from.Status {
+		before[status.IP] = status
+	}
+	after := make(map[string]types.IPStatus, len(to.Status))
+	for _, status := range to.Status {
+		after[status.IP] = status
+	}
+	ips := make([]string, 0, len(before)+len(after))
+	for ip := range before {
+		ips = append(ips, ip)
+	}
+	for ip := range after {
+		if _, found := before[ip]; !found {
+			ips = append(ips, ip)
+		}
+	}
+	sortIPs(ips)
+
+	diffs := make([]types.PortDiff, 0)
+	for _, ip := range ips {
+		b, a := before[ip], after[ip]
+		diff := types.PortDiff{IP: ip, Target: a.Target, FromState: b.State, ToState: a.State}
+		if len(diff.Target) == 0 {
+			diff.Target = b.Target
+		}
+		switch {
+		case a.State == types.OPEN && b.State != types.OPEN:
+			diff.Change = types.DiffOpened
+		case b.State == types.OPEN && a.State != types.OPEN:
+			diff.Change = types.DiffClosed
+		case a.State != b.State:
+			diff.Change = types.DiffState
+		case a.State == types.OPEN:
+			if diff.Fields = diffFields(b, a); len(diff.Fields) > 0 {
+				diff.Change = types.DiffChanged
+			}
+		}
+		if len(diff.Change) > 0 {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+//diffFields returns what was collected from an open port that differs between two of its statuses. A field is only
+//compared when both scans collected it, as a scan made without e.g --banner records none
+func diffFields(from, to types.IPStatus) []types.FieldDiff {
+	fields := make([]types.FieldDiff, 0)
+	compare := func(field string, describe func(types.IPStatus) string) {
+		if b, a := describe(from), describe(to); len(b) > 0 && len(a) > 0 && b != a {
+			fields = append(fields, types.FieldDiff{Field: field, From: b, To: a})
+		}
+	}
+	compare("banner", func(s types.IPStatus) string {
+		return s.Banner
+	})
+	compare("service", func(s types.IPStatus) string {
+		if s.Service == nil {
+			return ""
+		}
+		return strings.TrimSpace(strings.Join([]string{s.Service.Name, s.Service.Product, s.Service.Version}, " "))
+	})
+	compare("tls", func(s types.IPStatus) string {
+		if s.TLS == nil {
+			return ""
+		}
+		return fmt.Sprintf("%s (supports %s)", s.TLS.Version, strings.Join(s.TLS.Versions, ", "))
+	})
+	compare("certificate", func(s types.IPStatus) string {
+		if s.TLS == nil || s.TLS.Certificate == nil {
+			return ""
+		}
+		c := s.TLS.Certificate
+		return fmt.Sprintf("%s expires %s sha256 %s", c.Subject, c.NotAfter.Format("2006-01-02"), c.Fingerprint)
+	})
+	compare("http", func(s types.IPStatus) string {
+		if s.HTTP == nil {
+			return ""
+		}
+		return fmt.Sprintf("%d server %q title %q", s.HTTP.StatusCode, s.HTTP.Server, s.HTTP.Title)
+	})
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+//sortIPs orders ips by address, ipv4 addresses before ipv6
+func sortIPs(ips []string) {
+	key := func(s string) []byte {
+		ip, _ := pnet.ParseIP(s)
+		if ip4 := ip.To4(); ip4 != nil {
+			return append([]byte{4}, ip4...)
+		}
+		return append([]byte{6}, ip.To16()...)
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return bytes.Compare(key(ips[i]), key(ips[j])) < 0
+	})
+}
+
+//diff reports how the results of one completed scan differ from those of another of the same port
+func (s *server) diff(w http.ResponseWriter, r *http.Request) {
+	log := s.requestLog(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("could not read diff request body", plog.Fields{"error": err})
+		return
+	}
+	var req types.DiffRequest
+	if err := json.Unmarshal(bs, &req); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Warn("bad diff request body", plog.Fields{"error": err, "body_bytes": len(bs)})
+		return
+	}
+	log.Debug("diff", plog.Fields{"from": req.From, "to": req.To})
+	from, found := s.jobs.Load(req.From)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(fmt.Sprintf("scan %d is not a known id", req.From)))
+		return
+	}
+	to, found := s.jobs.Load(req.To)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(fmt.Sprintf("scan %d is not a known id", req.To)))
+		return
+	}
+	if !from.Ready || !to.Ready {
+		id := req.From
+		if from.Ready {
+			id = req.To
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("scan %d is not yet ready", id)))
+		return
+	}
+	if from.ScanPort != to.ScanPort || protocolOrDefault(from.Protocol) != protocolOrDefault(to.Protocol) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("scans %d and %d are not of the same port", req.From, req.To)))
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, types.DiffResponse{
+		From:     req.From,
+		To:       req.To,
+		ScanPort: to.ScanPort,
+		Protocol: to.Protocol,
+		Changes:  diffResults(from, to),
+	})
+}
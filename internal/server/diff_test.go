@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffResults(t *testing.T) {
+	cert := func(fingerprint string) *types.TLSInfo {
+		return &types.TLSInfo{Version: "TLS 1.3", Versions: []string{"TLS 1.2", "TLS 1.3"}, Certificate: &types.Certificate{
+			Subject: "CN=example.com", NotAfter: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Fingerprint: fingerprint,
+		}}
+	}
+	from := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "10.0.0.1", State: types.CLOSED},
+		{IP: "10.0.0.2", State: types.OPEN, Target: "10.0.0.0/24"},
+		{IP: "10.0.0.3", State: types.CLOSED},
+		{IP: "10.0.0.4", State: types.OPEN, Banner: "hello", TLS: cert("aa")},
+		{IP: "10.0.0.5", State: types.OPEN, Banner: "same"},
+		{IP: "2001:db8::1", State: types.OPEN},
+	}}
+	to := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{
+		{IP: "2001:db8::1", State: types.OPEN},
+		{IP: "10.0.0.10", State: types.OPEN},
+		{IP: "10.0.0.5", State: types.OPEN, Banner: "same"},
+		{IP: "10.0.0.4", State: types.OPEN, Banner: "hello again", TLS: cert("bb")},
+		{IP: "10.0.0.3", State: types.SKIPPED},
+		{IP: "10.0.0.2", State: types.CLOSED, Target: "10.0.0.0/24"},
+		{IP: "10.0.0.1", State: types.OPEN},
+	}}
+	assert.Equal(t, []types.PortDiff{
+		{IP: "10.0.0.1", Change: types.DiffOpened, FromState: types.CLOSED, ToState: types.OPEN},
+		{IP: "10.0.0.2", Target: "10.0.0.0/24", Change: types.DiffClosed, FromState: types.OPEN, ToState: types.CLOSED},
+		{IP: "10.0.0.3", Change: types.DiffState, FromState: types.CLOSED, ToState: types.SKIPPED},
+		{IP: "10.0.0.4", Change: types.DiffChanged, FromState: types.OPEN, ToState: types.OPEN, Fields: []types.FieldDiff{
+			{Field: "banner", From: "hello", To: "hello again"},
+			{Field: "certificate", From: "CN=example.com expires 2030-01-01 sha256 aa", To: "CN=example.com expires 2030-01-01 sha256 bb"},
+		}},
+		//an address only the later scan included
+		{IP: "10.0.0.10", Change: types.DiffOpened, ToState: types.OPEN},
+	}, diffResults(from, to))
+
+	assert.Empty(t, diffResults(to, to))
+
+	//fields collected by only one of the scans are not changes
+	plain := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{{IP: "10.0.0.4", State: types.OPEN}}}
+	inspected := types.QueryResponse{Ready: true, ScanPort: 443, Status: []types.IPStatus{{IP: "10.0.0.4", State: types.OPEN, Banner: "hello", TLS: cert("aa")}}}
+	assert.Empty(t, diffResults(inspected, plain))
+	assert.Empty(t, diffResults(plain, inspected))
+}
+
+func TestServer_Diff(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.jobs.Store(1, types.QueryResponse{Ready: true, ScanPort: 22, Status: []types.IPStatus{{IP: "10.0.0.1", State: types.CLOSED}}})
+	s.jobs.Store(2, types.QueryResponse{Ready: true, ScanPort: 22, Status: []types.IPStatus{{IP: "10.0.0.1", State: types.OPEN}}})
+	s.jobs.Store(3, types.QueryResponse{Ready: false, ScanPort: 22})
+	s.jobs.Store(4, types.QueryResponse{Ready: true, ScanPort: 22, Protocol: types.UDP})
+
+	rec := callHandler(s, s.diff, http.MethodPost, types.DiffRequest{From: 1, To: 2})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"from":1,"to":2,"port":22,"changes":[{"ip":"10.0.0.1","change":"opened","from_state":"closed","to_state":"open"}]}`, rec.Body.String())
+
+	tests := []struct {
+		req    types.DiffRequest
+		status int
+		want   string
+	}{
+		{types.DiffRequest{From: 9, To: 2}, http.StatusNotFound, "scan 9 is not a known id"},
+		{types.DiffRequest{From: 1, To: 3}, http.StatusBadRequest, "scan 3 is not yet ready"},
+		{types.DiffRequest{From: 1, To: 4}, http.StatusBadRequest, "scans 1 and 4 are not of the same port"},
+	}
+	for _, tt := range tests {
+		rec := callHandler(s, s.diff, http.MethodPost, tt.req)
+		assert.Equal(t, tt.status, rec.Code)
+		assert.Equal(t, tt.want, rec.Body.String())
+	}
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/internal/trace"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//ExporterArgs are the unmodified arguments of one exporter, see CommandLineArgs.Exporters. Which apply depends on Type
+type ExporterArgs struct {
+	Type      string `yaml:"type"`
+	Path      string `yaml:"path"`
+	MaxSizeMB string `yaml:"max_size_mb"`
+	MaxFiles  string `yaml:"max_files"`
+	Network   string `yaml:"network"`
+	Address   string `yaml:"address"`
+	Facility  string `yaml:"facility"`
+}
+
+//Exporter types
+const (
+	ExporterFile   = "file"
+	ExporterSyslog = "syslog"
+	ExporterStdout = "stdout"
+)
+
+const (
+	defaultExportMaxSizeMB = 100
+	defaultExportMaxFiles  = 5
+	defaultSyslogNetwork   = "udp"
+	defaultSyslogFacility  = "local0"
+	//exportTimeout bounds the time an exporter is given to write one record
+	exportTimeout = 10 * time.Second
+	//maxSyslogDatagram is the largest message sent over udp or unixgram, as receivers commonly truncate longer ones
+	maxSyslogDatagram = 8192
+)
+
+//syslogFacilities are the facility codes of RFC 5424 a syslog exporter may use
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+//ExportConfiguration holds the exporters the results of each completed scan are written to
+type ExportConfiguration struct {
+	Exporters []exporter
+}
+
+//exporter writes the results of completed scans. An exporter of a new type implements it, and is created by newExporter
+type exporter interface {
+	//name is the name the exporter is configured with
+	name() string
+	export(ctx context.Context, record types.ExportRecord) error
+}
+
+func (c CommandLineArgs) prepareExport() (*ExportConfiguration, error) {
+	names := make([]string, 0, len(c.Exporters))
+	for name := range c.Exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	export := &ExportConfiguration{Exporters: make([]exporter, 0, len(names))}
+	for _, name := range names {
+		if e, err := newExporter(name, c.Exporters[name]); err != nil {
+			return nil, err
+		} else {
+			export.Exporters = append(export.Exporters, e)
+		}
+	}
+	return export, nil
+}
+
+//newExporter creates the exporter of args.Type, returning an error if args are not valid for it
+func newExporter(name string, args ExporterArgs) (exporter, error) {
+	switch args.Type {
+	case ExporterFile:
+		if len(args.Path) == 0 {
+			return nil, fmt.Errorf("exporter %s must provide a path", name)
+		}
+		if info, err := os.Stat(filepath.Dir(args.Path)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("exporter %s directory %s does not exist", name, filepath.Dir(args.Path))
+		}
+		e := &fileExporter{exporterName: name, path: args.Path, maxBytes: defaultExportMaxSizeMB << 20, maxFiles: defaultExportMaxFiles}
+		if len(args.MaxSizeMB) > 0 {
+			if mb, err := strconv.ParseUint(args.MaxSizeMB, 10, 32); err != nil || mb == 0 {
+				return nil, fmt.Errorf("exporter %s max size must be a positive number of megabytes", name)
+			} else {
+				e.maxBytes = int64(mb) << 20
+			}
+		}
+		if len(args.MaxFiles) > 0 {
+			if files, err := strconv.ParseUint(args.MaxFiles, 10, 16); err != nil || files == 0 {
+				return nil, fmt.Errorf("exporter %s max files must be a positive number", name)
+			} else {
+				e.maxFiles = int(files)
+			}
+		}
+		return e, nil
+	case ExporterSyslog:
+		e := &syslogExporter{exporterName: name, network: args.Network, address: args.Address, hostname: "-"}
+		if len(e.network) == 0 {
+			e.network = defaultSyslogNetwork
+		}
+		switch e.network {
+		case "udp", "tcp", "unix", "unixgram":
+		default:
+			return nil, fmt.Errorf("exporter %s network %s is not udp, tcp, unix or unixgram", name, e.network)
+		}
+		if len(e.address) == 0 {
+			return nil, fmt.Errorf("exporter %s must provide the address of a syslog server", name)
+		}
+		facility := args.Facility
+		if len(facility) == 0 {
+			facility = defaultSyslogFacility
+		}
+		if code, found := syslogFacilities[facility]; !found {
+			return nil, fmt.Errorf("exporter %s facility %s is not a syslog facility, e.g local0", name, facility)
+		} else {
+			//records are sent at severity informational
+			e.priority = code*8 + 6
+		}
+		if hostname, err := os.Hostname(); err == nil && len(hostname) > 0 {
+			e.hostname = hostname
+		}
+		return e, nil
+	case ExporterStdout:
+		return &writerExporter{exporterName: name, out: os.Stdout, mu: &stdoutMu}, nil
+	default:
+		return nil, fmt.Errorf("exporter %s type %s is not file, syslog or stdout", name, args.Type)
+	}
+}
+
+//exportResult writes the results of a completed job to every exporter
+func (s *server) exportResult(ctx context.Context, log *plog.Logger, export ExportConfiguration, j job, resp types.QueryResponse) {
+	record := types.ExportRecord{ScanID: j.ScanID, Schedule: j.Schedule, RequestID: j.RequestID, Completed: time.Now(), QueryResponse: resp}
+	for _, e := range export.Exporters {
+		if ctx.Err() != nil {
+			log.Warn("result not exported, interrupted by shutdown", plog.Fields{"exporter": e.name()})
+			continue
+		}
+		exportCtx, span := s.tracer.Start(ctx, "result.export")
+		span.SetKind(trace.SpanKindClient)
+		span.SetAttribute("exporter", e.name())
+		exportCtx, cancel := context.WithTimeout(exportCtx, exportTimeout)
+		err := e.export(exportCtx, record)
+		cancel()
+		if err != nil {
+			span.SetError(err)
+			log.Error("could not export result", plog.Fields{"exporter": e.name(), "error": err})
+		} else {
+			log.Debug("result exported", plog.Fields{"exporter": e.name()})
+		}
+		span.End()
+	}
+}
+
+//fileExporter appends each record to a file as a line of JSON, rotating the file once it would exceed maxBytes
+//Rotated files are renamed path.1, path.2 and so on, oldest last, keeping at most maxFiles of them
+type fileExporter struct {
+	exporterName string
+	path         string
+	maxBytes     int64
+	maxFiles     int
+}
+
+func (f *fileExporter) name() string {
+	return f.exporterName
+}
+
+func (f *fileExporter) export(_ context.Context, record types.ExportRecord) error {
+	bs, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	line := append(bs, '\n')
+	mu := pathMu(f.path)
+	mu.Lock()
+	defer mu.Unlock()
+	if info, err := os.Stat(f.path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("could not rotate %s, error was: %s", f.path, err.Error())
+		}
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//rotate renames path to path.1, after moving each earlier rotation up one and removing the oldest
+func (f *fileExporter) rotate() error {
+	rotated := func(i int) string {
+		return fmt.Sprintf("%s.%d", f.path, i)
+	}
+	if err := os.Remove(rotated(f.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(i), rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.path, rotated(1))
+}
+
+//syslogExporter sends the status of each address of a record to a syslog server, as the message of an RFC 5424
+//syslog message. Messages sent over a stream, tcp or unix, are framed by octet counting, as RFC 6587 describes
+type syslogExporter struct {
+	exporterName string
+	network      string
+	address      string
+	priority     int
+	hostname     string
+}
+
+func (e *syslogExporter) name() string {
+	return e.exporterName
+}
+
+func (e *syslogExporter) export(ctx context.Context, record types.ExportRecord) error {
+	if len(record.Status) == 0 {
+		return nil
+	}
+	stream := e.network == "tcp" || e.network == "unix"
+	msgs := make([][]byte, 0, len(record.Status))
+	for _, status := range record.Status {
+		bs, err := json.Marshal(&types.ExportStatus{
+			ScanID:    record.ScanID,
+			Schedule:  record.Schedule,
+			RequestID: record.RequestID,
+			Completed: record.Completed,
+			ScanPort:  record.ScanPort,
+			Protocol:  record.Protocol,
+			Source:    record.Source,
+			IPStatus:  status,
+		})
+		if err != nil {
+			return err
+		}
+		msg := e.format(record.Completed, bs)
+		if stream {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		} else if len(msg) > maxSyslogDatagram {
+			//receivers truncate or drop a datagram this long, so the record is refused rather than sent in part
+			return fmt.Errorf("status of %s is a message of %d bytes, more than the %d sent over %s, use tcp", status.IP, len(msg), maxSyslogDatagram, e.network)
+		}
+		msgs = append(msgs, msg)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, e.network, e.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	for _, msg := range msgs {
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//format returns an RFC 5424 message of msg, with the app name pscan, the pid as proc id, the msg id scan and no structured data
+func (e *syslogExporter) format(t time.Time, msg []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s pscan %d scan - ", e.priority, t.UTC().Format("2006-01-02T15:04:05.000000Z"), e.hostname, os.Getpid())
+	return append([]byte(header), msg...)
+}
+
+//pathMus serializes writes to each file, shared by the file exporters and alert sinks of every reload so that one
+//rotating a file does not race another appending to it
+var (
+	pathMusMu sync.Mutex
+	pathMus   = map[string]*sync.Mutex{}
+)
+
+//pathMu returns the mutex serializing writes to path
+func pathMu(path string) *sync.Mutex {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	pathMusMu.Lock()
+	defer pathMusMu.Unlock()
+	mu, found := pathMus[path]
+	if !found {
+		mu = &sync.Mutex{}
+		pathMus[path] = mu
+	}
+	return mu
+}
+
+//stdoutMu serializes the records of stdout exporters, so that lines are not interleaved
+var stdoutMu sync.Mutex
+
+//writerExporter writes each record to out as a line of JSON
+type writerExporter struct {
+	exporterName string
+	out          io.Writer
+	mu           *sync.Mutex
+}
+
+func (w *writerExporter) name() string {
+	return w.exporterName
+}
+
+func (w *writerExporter) export(_ context.Context, record types.ExportRecord) error {
+	bs, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.out.Write(append(bs, '\n'))
+	return err
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRecord = types.ExportRecord{
+	ScanID:    2,
+	Schedule:  7,
+	Completed: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	QueryResponse: types.QueryResponse{Ready: true, ScanPort: 22, Status: []types.IPStatus{
+		{IP: "10.0.0.1", State: types.OPEN},
+	}},
+}
+
+func TestCommandLineArgs_PrepareExport(t *testing.T) {
+	dir := filepath.Dir(writeConfigFile(t, ""))
+	path := writeConfigFile(t, `
+exporters:
+  siem:
+    type: file
+    path: `+filepath.Join(dir, "results.jsonl")+`
+    max_size_mb: 10
+    max_files: 3
+  collector:
+    type: syslog
+    network: tcp
+    address: localhost:6514
+    facility: local3
+  console:
+    type: stdout
+`)
+	args, err := LoadCommandLineArgs(CommandLineArgs{ConfigFile: path}, env(nil))
+	assert.Nil(t, err)
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	if assert.Len(t, config.Export.Exporters, 3) {
+		//exporters are ordered by name
+		assert.Equal(t, "collector", config.Export.Exporters[0].name())
+		assert.Equal(t, 19*8+6, config.Export.Exporters[0].(*syslogExporter).priority)
+		assert.Equal(t, "console", config.Export.Exporters[1].name())
+		file := config.Export.Exporters[2].(*fileExporter)
+		assert.Equal(t, int64(10<<20), file.maxBytes)
+		assert.Equal(t, 3, file.maxFiles)
+	}
+
+	defaults, err := newExporter("collector", ExporterArgs{Type: ExporterSyslog, Address: "localhost:514"})
+	assert.Nil(t, err)
+	assert.Equal(t, "udp", defaults.(*syslogExporter).network)
+	assert.Equal(t, 16*8+6, defaults.(*syslogExporter).priority)
+
+	tests := []struct {
+		name string
+		args ExporterArgs
+		want string
+	}{
+		{"type", ExporterArgs{Type: "kafka"}, "exporter e type kafka is not file, syslog or stdout"},
+		{"path", ExporterArgs{Type: ExporterFile}, "exporter e must provide a path"},
+		{"directory", ExporterArgs{Type: ExporterFile, Path: "/nonexistent/results.jsonl"}, "exporter e directory /nonexistent does not exist"},
+		{"size", ExporterArgs{Type: ExporterFile, Path: filepath.Join(dir, "results.jsonl"), MaxSizeMB: "0"}, "exporter e max size must be a positive number of megabytes"},
+		{"files", ExporterArgs{Type: ExporterFile, Path: filepath.Join(dir, "results.jsonl"), MaxFiles: "many"}, "exporter e max files must be a positive number"},
+		{"network", ExporterArgs{Type: ExporterSyslog, Network: "sctp", Address: "localhost:514"}, "exporter e network sctp is not udp, tcp, unix or unixgram"},
+		{"address", ExporterArgs{Type: ExporterSyslog}, "exporter e must provide the address of a syslog server"},
+		{"facility", ExporterArgs{Type: ExporterSyslog, Address: "localhost:514", Facility: "mail"}, "exporter e facility mail is not a syslog facility, e.g local0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CommandLineArgs{Exporters: map[string]ExporterArgs{"e": tt.args}}.prepareExport()
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestFileExporter_Rotates(t *testing.T) {
+	path := filepath.Join(filepath.Dir(writeConfigFile(t, "")), "results.jsonl")
+	bs, _ := json.Marshal(&testRecord)
+	//room for two records a file
+	e := &fileExporter{exporterName: "siem", path: path, maxBytes: int64(2*len(bs) + 2), maxFiles: 2}
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, e.export(context.Background(), testRecord))
+	}
+
+	lines := func(path string) int {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return 0
+		}
+		return len(strings.Split(strings.TrimSpace(string(bs)), "\n"))
+	}
+	assert.Equal(t, 1, lines(path))
+	assert.Equal(t, 2, lines(path+".1"))
+	assert.Equal(t, 2, lines(path+".2"))
+	_, err := os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	var record types.ExportRecord
+	bs, _ = ioutil.ReadFile(path)
+	assert.Nil(t, json.Unmarshal(bs, &record))
+	assert.Equal(t, testRecord, record)
+}
+
+func TestFileExporter_SharesPathAcrossReloads(t *testing.T) {
+	path := filepath.Join(filepath.Dir(writeConfigFile(t, "")), "results.jsonl")
+	bs, _ := json.Marshal(&testRecord)
+	//a reload replaces the exporter while the old one may still be exporting, so both write to and rotate path
+	exporters := []*fileExporter{
+		{exporterName: "siem", path: path, maxBytes: int64(2*len(bs) + 2), maxFiles: 100},
+		{exporterName: "siem", path: path, maxBytes: int64(2*len(bs) + 2), maxFiles: 100},
+	}
+	var wg sync.WaitGroup
+	for _, e := range exporters {
+		wg.Add(1)
+		go func(e *fileExporter) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, e.export(context.Background(), testRecord))
+			}
+		}(e)
+	}
+	wg.Wait()
+
+	//no record is lost to a rotation overwriting another
+	lines := 0
+	for i := 0; i <= 100; i++ {
+		name := path
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", path, i)
+		}
+		if bs, err := ioutil.ReadFile(name); err == nil {
+			lines += len(strings.Split(strings.TrimSpace(string(bs)), "\n"))
+		}
+	}
+	assert.Equal(t, 200, lines)
+}
+
+func TestSyslogExporter_Export(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	e, err := newExporter("collector", ExporterArgs{Type: ExporterSyslog, Address: udp.LocalAddr().String(), Facility: "local0"})
+	assert.Nil(t, err)
+	e.(*syslogExporter).hostname = "scanner1"
+	assert.Nil(t, e.export(context.Background(), testRecord))
+
+	buf := make([]byte, 65536)
+	_ = udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	assert.Nil(t, err)
+	bs, _ := json.Marshal(&types.ExportStatus{ScanID: 2, Schedule: 7, Completed: testRecord.Completed, ScanPort: 22, IPStatus: testRecord.Status[0]})
+	assert.Equal(t, "<134>1 2030-01-01T00:00:00.000000Z scanner1 pscan "+strconv.Itoa(os.Getpid())+" scan - "+string(bs), string(buf[:n]))
+
+	//an address whose status would not fit a datagram is refused
+	large := testRecord
+	large.Status = []types.IPStatus{{IP: "10.0.0.1", State: types.OPEN, Banner: strings.Repeat("x", maxSyslogDatagram)}}
+	err = e.export(context.Background(), large)
+	if assert.NotNil(t, err) {
+		assert.Regexp(t, "^status of 10.0.0.1 is a message of [0-9]+ bytes, more than the 8192 sent over udp, use tcp$", err.Error())
+	}
+
+	//messages sent over tcp are framed by their length, one an address, so a scan of many is not sent as one message
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		msgs := make([]string, 0)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, size)
+			_, _ = io.ReadFull(r, msg)
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+	many := testRecord
+	many.Status = make([]types.IPStatus, 0, 1024)
+	for i := 0; i < 1024; i++ {
+		many.Status = append(many.Status, types.IPStatus{IP: fmt.Sprintf("10.0.%d.%d", i/256, i%256), State: types.OPEN, Banner: strings.Repeat("x", 256)})
+	}
+	e, err = newExporter("collector", ExporterArgs{Type: ExporterSyslog, Network: "tcp", Address: tcp.Addr().String()})
+	assert.Nil(t, err)
+	assert.Nil(t, e.export(context.Background(), many))
+	msgs := <-received
+	if assert.Len(t, msgs, 1024) {
+		assert.True(t, strings.HasPrefix(msgs[0], "<134>1 2030-01-01T00:00:00.000000Z "))
+		var status types.ExportStatus
+		assert.Nil(t, json.Unmarshal([]byte(msgs[1023][strings.Index(msgs[1023], "{"):]), &status))
+		assert.Equal(t, uint64(2), status.ScanID)
+		assert.Equal(t, "10.0.3.255", status.IP)
+	}
+}
+
+func TestServer_ProcessJob_ExportsResult(t *testing.T) {
+	port := openPort(t)
+	var out bytes.Buffer
+	runJobs(t, Configuration{Export: ExportConfiguration{Exporters: []exporter{&writerExporter{exporterName: "console", out: &out, mu: &sync.Mutex{}}}}}, nil,
+		job{ScanID: 1, Port: port, IPs: []string{"127.0.0.1"}, RequestID: "abc"})
+
+	var record types.ExportRecord
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, uint64(1), record.ScanID)
+	assert.Equal(t, "abc", record.RequestID)
+	assert.True(t, record.Ready)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN}}, record.Status)
+	assert.False(t, record.Completed.IsZero())
+}
+
+//blockingExporter exports nothing, returning only once ctx is done
+type blockingExporter struct{}
+
+func (blockingExporter) name() string {
+	return "blocking"
+}
+
+func (blockingExporter) export(ctx context.Context, _ types.ExportRecord) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestServer_Drain_InterruptsExports(t *testing.T) {
+	port := openPort(t)
+	s := startJobs(t, Configuration{Export: ExportConfiguration{Exporters: []exporter{blockingExporter{}, blockingExporter{}}}}, nil,
+		job{ScanID: 1, Port: port, IPs: []string{"127.0.0.1"}})
+	start := time.Now()
+	s.drain(100 * time.Millisecond)
+	//exports are interrupted at the drain timeout, rather than each being given exportTimeout
+	assert.True(t, time.Since(start) < exportTimeout)
+	_, found := s.jobs.Load(1)
+	assert.True(t, found)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/internal/version"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//healthz reports the server process is alive and serving HTTP
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, types.HealthResponse{Status: "ok"})
+}
+
+//readyz reports whether the server should be sent new scans
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resp := s.readiness()
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, r, status, resp)
+}
+
+func (s *server) readiness() types.ReadinessResponse {
+	checks := make([]types.ReadinessCheck, 0, 3)
+
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		checks = append(checks, types.ReadinessCheck{Name: "accepting", OK: false, Message: "shutting down"})
+	} else {
+		checks = append(checks, types.ReadinessCheck{Name: "accepting", OK: true})
+	}
+
+	if err := s.jobs.Ping(); err != nil {
+		checks = append(checks, types.ReadinessCheck{Name: "job_store", OK: false, Message: err.Error()})
+	} else {
+		checks = append(checks, types.ReadinessCheck{Name: "job_store", OK: true})
+	}
+
+	active, limit := atomic.LoadInt64(&s.activeJobs), int64(s.currentConfig().Limits.MaxActiveJobs)
+	queue := types.ReadinessCheck{Name: "queue", OK: active < limit, Message: fmt.Sprintf("%d of %d active jobs", active, limit)}
+	checks = append(checks, queue)
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+	return types.ReadinessResponse{Ready: ready, Checks: checks}
+}
+
+//version reports build metadata of the running server
+func (s *server) version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	info := version.Get()
+	s.writeJSON(w, r, http.StatusOK, types.VersionResponse{
+		Version:   info.Version,
+		Commit:    info.Commit,
+		BuildDate: info.BuildDate,
+		GoVersion: info.GoVersion,
+	})
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		s.requestLog(r).Error("could not marshal response", plog.Fields{"error": err})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bs)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct {
+	*memoryStore
+}
+
+func (f failingStore) Ping() error {
+	return fmt.Errorf("store unavailable")
+}
+
+func TestServer_Healthz(t *testing.T) {
+	s := NewServer(Configuration{})
+	rec := httptest.NewRecorder()
+	s.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp types.HealthResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "ok", resp.Status)
+
+	rec = httptest.NewRecorder()
+	s.healthz(rec, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func readyz(t *testing.T, s *server) (int, types.ReadinessResponse) {
+	rec := httptest.NewRecorder()
+	s.readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp types.ReadinessResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, resp
+}
+
+func TestServer_Readyz(t *testing.T) {
+	s := NewServer(Configuration{Limits: LimitConfiguration{MaxActiveJobs: 2}})
+	code, resp := readyz(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Ready)
+	assert.Len(t, resp.Checks, 3)
+
+	s.activeJobs = 2
+	code, resp = readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Ready)
+	assert.Equal(t, types.ReadinessCheck{Name: "queue", OK: false, Message: "2 of 2 active jobs"}, resp.Checks[2])
+}
+
+func TestServer_Readyz_NotReadyWhenStoreUnavailable(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.jobs = failingStore{newMemoryStore()}
+	code, resp := readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, types.ReadinessCheck{Name: "job_store", OK: false, Message: "store unavailable"}, resp.Checks[1])
+}
+
+func TestServer_Readyz_NotReadyWhenShuttingDown(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.shuttingDown = 1
+	code, resp := readyz(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Checks[0].OK)
+}
+
+func TestServer_Version(t *testing.T) {
+	s := NewServer(Configuration{})
+	rec := httptest.NewRecorder()
+	s.version(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp types.VersionResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "dev", resp.Version)
+	assert.NotEmpty(t, resp.GoVersion)
+}
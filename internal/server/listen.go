@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+)
+
+const (
+	ListenTCP  = "tcp"
+	ListenUnix = "unix"
+
+	unixPrefix = "unix://"
+	//socketMode lets the owner and group of pscan connect to a unix socket listener
+	socketMode = 0660
+)
+
+//ListenerConfiguration describes one address the server accepts requests on
+type ListenerConfiguration struct {
+	//Network is ListenTCP or ListenUnix
+	Network string
+	//Address is host:port for tcp listeners, and a socket path for unix listeners
+	Address string
+}
+
+func (l ListenerConfiguration) String() string {
+	if l.Network == ListenUnix {
+		return unixPrefix + l.Address
+	}
+	return l.Address
+}
+
+//parseListener parses a listener given as host:port, [ipv6]:port or unix:///path/to/socket
+func parseListener(spec string) (ListenerConfiguration, error) {
+	if strings.HasPrefix(spec, unixPrefix) {
+		path := strings.TrimPrefix(spec, unixPrefix)
+		if len(path) == 0 {
+			return ListenerConfiguration{}, fmt.Errorf("listener %s must give a socket path, e.g unix:///run/pscan.sock", spec)
+		}
+		return ListenerConfiguration{Network: ListenUnix, Address: path}, nil
+	}
+	host, port, err := net.SplitHostPort(spec)
+	if err != nil {
+		return ListenerConfiguration{}, fmt.Errorf("listener %s is not valid, expected host:port, [ipv6]:port or unix:///path", spec)
+	}
+	if !validListenHost(host) {
+		return ListenerConfiguration{}, fmt.Errorf("listener %s does not have a valid ip address", spec)
+	}
+	if n, err := strconv.ParseUint(port, 10, 32); err != nil || !pnet.ValidPort(uint(n)) {
+		return ListenerConfiguration{}, fmt.Errorf("listener %s does not have a valid port", spec)
+	}
+	return ListenerConfiguration{Network: ListenTCP, Address: net.JoinHostPort(host, port)}, nil
+}
+
+//validListenHost accepts an empty host (all interfaces), localhost, or an ip address, with a zone for link-local ipv6
+func validListenHost(host string) bool {
+	if len(host) == 0 || host == "localhost" {
+		return true
+	}
+	if i := strings.LastIndex(host, "%"); i > 0 {
+		ip := net.ParseIP(host[:i])
+		return ip != nil && ip.To4() == nil && i < len(host)-1
+	}
+	return net.ParseIP(host) != nil
+}
+
+func (c CommandLineArgs) prepareListeners() ([]ListenerConfiguration, error) {
+	var listeners []ListenerConfiguration
+	seen := make(map[string]bool)
+	for _, spec := range c.Listen {
+		listener, err := parseListener(spec)
+		if err != nil {
+			return nil, err
+		} else if seen[listener.String()] {
+			return nil, fmt.Errorf("listener %s is given more than once", spec)
+		}
+		seen[listener.String()] = true
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+//listen opens a listener. A unix socket left behind by a pscan that did not exit cleanly is replaced,
+//one that is still being served is not
+func listen(l ListenerConfiguration) (net.Listener, error) {
+	if l.Network != ListenUnix {
+		return net.Listen(l.Network, l.Address)
+	}
+	if info, err := os.Lstat(l.Address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", l.Address)
+		}
+		if conn, err := net.DialTimeout(ListenUnix, l.Address, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is already in use", l.Address)
+		}
+		if err := os.Remove(l.Address); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %s, error was: %s", l.Address, err.Error())
+		}
+	}
+	listener, err := net.Listen(ListenUnix, l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(l.Address, socketMode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("could not set permissions on %s, error was: %s", l.Address, err.Error())
+	}
+	return listener, nil
+}
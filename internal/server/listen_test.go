@@ -0,0 +1,137 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempSocket(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pscan-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "pscan.sock")
+}
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		spec string
+		want ListenerConfiguration
+		err  string
+	}{
+		{spec: "127.0.0.1:8080", want: ListenerConfiguration{Network: ListenTCP, Address: "127.0.0.1:8080"}},
+		{spec: ":8080", want: ListenerConfiguration{Network: ListenTCP, Address: ":8080"}},
+		{spec: "localhost:8080", want: ListenerConfiguration{Network: ListenTCP, Address: "localhost:8080"}},
+		{spec: "[::1]:8080", want: ListenerConfiguration{Network: ListenTCP, Address: "[::1]:8080"}},
+		{spec: "[fe80::1%eth0]:8080", want: ListenerConfiguration{Network: ListenTCP, Address: "[fe80::1%eth0]:8080"}},
+		{spec: "unix:///run/pscan.sock", want: ListenerConfiguration{Network: ListenUnix, Address: "/run/pscan.sock"}},
+		{spec: "unix://", err: "listener unix:// must give a socket path, e.g unix:///run/pscan.sock"},
+		{spec: "127.0.0.1", err: "listener 127.0.0.1 is not valid, expected host:port, [ipv6]:port or unix:///path"},
+		{spec: "::1:8080", err: "listener ::1:8080 is not valid, expected host:port, [ipv6]:port or unix:///path"},
+		{spec: "nowhere:8080", err: "listener nowhere:8080 does not have a valid ip address"},
+		{spec: "[10.0.0.1%eth0]:8080", err: "listener [10.0.0.1%eth0]:8080 does not have a valid ip address"},
+		{spec: "127.0.0.1:0", err: "listener 127.0.0.1:0 does not have a valid port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseListener(tt.spec)
+			if len(tt.err) > 0 {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_Listeners(t *testing.T) {
+	config, err := CommandLineArgs{ListenPort: "8080", Listen: []string{"127.0.0.1:8080", "[::1]:8080", "unix:///run/pscan.sock"}}.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8080", "[::1]:8080", "unix:///run/pscan.sock"}, listenerStrings(config.listeners()))
+
+	_, err = CommandLineArgs{ListenPort: "8080", Listen: []string{"[::1]:8080", "[::1]:8080"}}.ValidateAndPrepare()
+	assert.EqualError(t, err, "listener [::1]:8080 is given more than once")
+}
+
+func TestConfiguration_ListenersDefault(t *testing.T) {
+	assert.Equal(t, []string{":8080"}, listenerStrings(Configuration{ListenPort: 8080}.listeners()))
+	assert.Equal(t, []string{"[::1]:9000"}, listenerStrings(Configuration{ListenAddress: "::1", ListenPort: 9000}.listeners()))
+}
+
+func listenerStrings(listeners []ListenerConfiguration) []string {
+	strs := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		strs = append(strs, l.String())
+	}
+	return strs
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := tempSocket(t)
+	l := ListenerConfiguration{Network: ListenUnix, Address: path}
+
+	listener, err := listen(l)
+	assert.Nil(t, err)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(socketMode), info.Mode().Perm())
+
+	_, err = listen(l)
+	assert.EqualError(t, err, path+" is already in use")
+
+	//a socket left behind by a process that did not close it is replaced
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = listener.Close()
+	listener, err = listen(l)
+	assert.Nil(t, err)
+	_ = listener.Close()
+}
+
+func TestListen_UnixSocketPathNotASocket(t *testing.T) {
+	path := tempSocket(t)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("keep me"), 0600))
+	_, err := listen(ListenerConfiguration{Network: ListenUnix, Address: path})
+	assert.EqualError(t, err, path+" exists and is not a socket")
+}
+
+func TestServer_RunOnUnixSocket(t *testing.T) {
+	path := tempSocket(t)
+	s := NewServer(Configuration{Listeners: []ListenerConfiguration{{Network: ListenUnix, Address: path}}})
+	killCh := make(chan bool)
+	doneCh := make(chan struct{})
+	go func() {
+		s.Run(killCh)
+		close(doneCh)
+	}()
+
+	client := pnet.UnixSocketHttpClient(path)
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://localhost/healthz"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if assert.Nil(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	killCh <- true
+	<-doneCh
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
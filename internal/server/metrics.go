@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+//serverMetrics are counters exposed in the Prometheus text format on /metrics
+type serverMetrics struct {
+	reloadSuccesses int64
+	reloadFailures  int64
+	//lastReload is the unix time of the last reload attempt, and lastReloadOK is 1 if it succeeded
+	lastReload   int64
+	lastReloadOK int32
+}
+
+func (s *server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	b := &strings.Builder{}
+	writeMetric(b, "pscan_active_jobs", "gauge", "Jobs submitted but not yet completed.", "", atomic.LoadInt64(&s.activeJobs))
+	writeMetric(b, "pscan_config_reloads_total", "counter", "Configuration reload attempts.", `result="success"`, atomic.LoadInt64(&s.metrics.reloadSuccesses))
+	writeMetric(b, "pscan_config_reloads_total", "", "", `result="failure"`, atomic.LoadInt64(&s.metrics.reloadFailures))
+	writeMetric(b, "pscan_config_last_reload_timestamp_seconds", "gauge", "Unix time of the last configuration reload attempt.", "", atomic.LoadInt64(&s.metrics.lastReload))
+	writeMetric(b, "pscan_config_last_reload_success", "gauge", "1 if the last configuration reload succeeded.", "", int64(atomic.LoadInt32(&s.metrics.lastReloadOK)))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+//writeMetric writes one sample, preceded by HELP and TYPE lines if kind is set
+func writeMetric(b *strings.Builder, name, kind, help, labels string, value int64) {
+	if len(kind) > 0 {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	if len(labels) > 0 {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, labels, value)
+	} else {
+		fmt.Fprintf(b, "%s %d\n", name, value)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//Policy restricts which targets and ports this server will scan
+//An empty allow list allows everything, the deny lists are applied after the allow lists
+type Policy struct {
+	AllowedTargets []*net.IPNet
+	DeniedTargets  []*net.IPNet
+	AllowedPorts   []PortRange
+	DeniedPorts    []PortRange
+}
+
+//PortRange is an inclusive range of ports
+type PortRange struct {
+	From uint
+	To   uint
+}
+
+func (p PortRange) contains(port uint) bool {
+	return port >= p.From && port <= p.To
+}
+
+//Check returns an error describing every part of r this Policy does not allow, or nil if r is allowed
+func (p Policy) Check(r types.ScanRequest) error {
+	messages := make([]string, 0)
+
+	//ScanIPs are expected to be expanded to ip addresses, see expandTargets
+	for _, s := range r.ScanIPs {
+		ip, _ := pnet.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		if len(p.AllowedTargets) > 0 && !containsIP(p.AllowedTargets, ip) {
+			messages = append(messages, fmt.Sprintf("%s is not an allowed target", s))
+		} else if containsIP(p.DeniedTargets, ip) {
+			messages = append(messages, fmt.Sprintf("%s is a denied target", s))
+		}
+	}
+
+	if len(p.AllowedPorts) > 0 && !containsPort(p.AllowedPorts, r.ScanPort) {
+		messages = append(messages, fmt.Sprintf("port %d is not an allowed port", r.ScanPort))
+	} else if containsPort(p.DeniedPorts, r.ScanPort) {
+		messages = append(messages, fmt.Sprintf("port %d is a denied port", r.ScanPort))
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ranges []PortRange, port uint) bool {
+	for _, r := range ranges {
+		if r.contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+//parseTargets parses a list of CIDRs or single ip addresses
+func parseTargets(targets []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(targets))
+	for _, target := range targets {
+		if strings.Contains(target, "/") {
+			if _, network, err := net.ParseCIDR(target); err != nil {
+				return nil, fmt.Errorf("%s is not a valid cidr", target)
+			} else {
+				networks = append(networks, network)
+			}
+		} else if ip := net.ParseIP(target); ip == nil {
+			return nil, fmt.Errorf("%s is not a valid ip address or cidr", target)
+		} else if ip4 := ip.To4(); ip4 != nil {
+			networks = append(networks, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	return networks, nil
+}
+
+//parsePortRanges parses a list of ports, or port ranges such as 8000-8100
+func parsePortRanges(ports []string) ([]PortRange, error) {
+	ranges := make([]PortRange, 0, len(ports))
+	for _, p := range ports {
+		bounds := strings.SplitN(p, "-", 2)
+		from, err := parsePort(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid port or port range", p)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = parsePort(bounds[1]); err != nil || to < from {
+				return nil, fmt.Errorf("%s is not a valid port or port range", p)
+			}
+		}
+		ranges = append(ranges, PortRange{From: from, To: to})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (uint, error) {
+	if port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32); err != nil {
+		return 0, err
+	} else if !pnet.ValidPort(uint(port)) {
+		return 0, fmt.Errorf("%d is not within valid port range", port)
+	} else {
+		return uint(port), nil
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy(t *testing.T, args CommandLineArgs) Policy {
+	policy, err := args.preparePolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *policy
+}
+
+func TestPolicy_EmptyAllowsEverything(t *testing.T) {
+	assert.Nil(t, Policy{}.Check(types.ScanRequest{ScanIPs: []string{"8.8.8.8", "::1"}, ScanPort: 22}))
+}
+
+func TestPolicy_Targets(t *testing.T) {
+	policy := testPolicy(t, CommandLineArgs{
+		AllowedTargets: []string{"10.0.0.0/8", "2001:db8::/32"},
+		DeniedTargets:  []string{"10.0.0.1"},
+	})
+	assert.Nil(t, policy.Check(types.ScanRequest{ScanIPs: []string{"10.1.2.3", "2001:db8::1"}, ScanPort: 22}))
+	assert.EqualError(t, policy.Check(types.ScanRequest{ScanIPs: []string{"8.8.8.8", "10.0.0.1"}, ScanPort: 22}),
+		"8.8.8.8 is not an allowed target\n10.0.0.1 is a denied target")
+}
+
+func TestPolicy_Ports(t *testing.T) {
+	policy := testPolicy(t, CommandLineArgs{
+		AllowedPorts: []string{"1-1024"},
+		DeniedPorts:  []string{"25"},
+	})
+	assert.Nil(t, policy.Check(types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 443}))
+	assert.EqualError(t, policy.Check(types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 8080}), "port 8080 is not an allowed port")
+	assert.EqualError(t, policy.Check(types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 25}), "port 25 is a denied port")
+}
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := parsePortRanges([]string{"80", "8000-8100"})
+	assert.Nil(t, err)
+	assert.Equal(t, []PortRange{{80, 80}, {8000, 8100}}, ranges)
+
+	for _, bad := range []string{"0", "http", "10-", "70000"} {
+		_, err := parsePortRanges([]string{bad})
+		assert.NotNil(t, err, bad)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+//rateLimiter is a token bucket, refilled at rate tokens per second up to burst tokens
+//A rate of zero allows everything
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimiter(rate float64, burst uint) *rateLimiter {
+	r := &rateLimiter{now: time.Now}
+	r.update(rate, burst)
+	return r
+}
+
+//update changes the rate and burst, keeping the tokens already earned up to the new burst
+func (r *rateLimiter) update(rate float64, burst uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill()
+	unlimited := r.rate <= 0
+	r.rate = rate
+	r.burst = float64(burst)
+	//a bucket that was unlimited starts full, rather than rejecting the next submission
+	if unlimited || r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+}
+
+//allow takes a token if one is available
+func (r *rateLimiter) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return true
+	}
+	r.refill()
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+//refill adds the tokens earned since the last call. r.mu must be held
+func (r *rateLimiter) refill() {
+	now := r.now()
+	if elapsed := now.Sub(r.last); !r.last.IsZero() && elapsed > 0 {
+		r.tokens += elapsed.Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newRateLimiter(1, 2)
+	r.now = func() time.Time {
+		return now
+	}
+	assert.True(t, r.allow())
+	assert.True(t, r.allow())
+	assert.False(t, r.allow())
+
+	now = now.Add(time.Second)
+	assert.True(t, r.allow())
+	assert.False(t, r.allow())
+
+	now = now.Add(time.Minute)
+	assert.True(t, r.allow())
+	assert.True(t, r.allow())
+	assert.False(t, r.allow())
+}
+
+func TestRateLimiter_ZeroRateIsUnlimited(t *testing.T) {
+	r := newRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		assert.True(t, r.allow())
+	}
+	r.update(1, 1)
+	assert.True(t, r.allow())
+	assert.False(t, r.allow())
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+)
+
+//ConfigLoader produces a fresh Configuration, typically by re-reading flags, environment variables and the config file
+type ConfigLoader func() (*Configuration, error)
+
+//SetConfigLoader sets how Reload obtains a new Configuration. Without one, Reload always fails
+func (s *server) SetConfigLoader(load ConfigLoader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loader = load
+}
+
+//currentConfig returns the Configuration in effect. Reloadable settings must always be read through here
+func (s *server) currentConfig() Configuration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+//Reload loads a new Configuration and applies the settings that can change while running:
+//log level, dial, drain and banner timeouts, limits other than max concurrent probes, auth keys, policy and source profiles
+//Changes to other settings are reported, and take effect on restart. In-flight jobs are not interrupted
+func (s *server) Reload() error {
+	err := s.reload()
+	atomic.StoreInt64(&s.metrics.lastReload, time.Now().Unix())
+	if err != nil {
+		atomic.AddInt64(&s.metrics.reloadFailures, 1)
+		atomic.StoreInt32(&s.metrics.lastReloadOK, 0)
+		s.log.Error("configuration reload failed, keeping current configuration", plog.Fields{"error": err})
+		return err
+	}
+	atomic.AddInt64(&s.metrics.reloadSuccesses, 1)
+	atomic.StoreInt32(&s.metrics.lastReloadOK, 1)
+	return nil
+}
+
+func (s *server) reload() error {
+	s.mu.RLock()
+	load := s.loader
+	s.mu.RUnlock()
+	if load == nil {
+		return fmt.Errorf("server has no configuration loader")
+	}
+
+	next, err := load()
+	if err != nil {
+		return err
+	}
+	updated := next.withDefaults()
+
+	s.mu.Lock()
+	current := s.config
+	restart := restartRequired(current, updated)
+
+	applied := current
+	applied.LogLevel = updated.LogLevel
+	applied.Timeouts.Dial = updated.Timeouts.Dial
+	applied.Timeouts.Drain = updated.Timeouts.Drain
+	applied.Timeouts.Banner = updated.Timeouts.Banner
+	applied.Timeouts.TLS = updated.Timeouts.TLS
+	applied.Timeouts.HTTP = updated.Timeouts.HTTP
+	applied.Timeouts.Discovery = updated.Timeouts.Discovery
+	applied.Timeouts.Script = updated.Timeouts.Script
+	applied.Limits.MaxActiveJobs = updated.Limits.MaxActiveJobs
+	applied.Limits.MaxIPsPerScan = updated.Limits.MaxIPsPerScan
+	applied.Limits.SubmitRate = updated.Limits.SubmitRate
+	applied.Limits.SubmitBurst = updated.Limits.SubmitBurst
+	applied.Limits.MaxBannerBytes = updated.Limits.MaxBannerBytes
+	applied.Auth = updated.Auth
+	applied.Policy = updated.Policy
+	applied.Sources = updated.Sources
+	applied.Detection = updated.Detection
+	applied.Scripts = updated.Scripts
+	applied.Baseline = updated.Baseline
+	applied.Alerts = updated.Alerts
+	applied.Export = updated.Export
+	s.config = applied
+	s.mu.Unlock()
+
+	s.log.SetLevel(applied.LogLevel)
+	s.submitLimit.update(applied.Limits.SubmitRate, applied.Limits.SubmitBurst)
+
+	fields := plog.Fields{"config_file": applied.ConfigFile, "auth": applied.Auth.Enabled()}
+	if len(restart) > 0 {
+		fields["restart_required"] = strings.Join(restart, ",")
+	}
+	s.log.Info("configuration reloaded", fields)
+	return nil
+}
+
+//restartRequired returns the names of settings that differ between current and next, but can not be applied while running
+func restartRequired(current, next Configuration) []string {
+	changed := make([]string, 0)
+	for _, setting := range []struct {
+		name string
+		a, b interface{}
+	}{
+		{"listen", current.listeners(), next.listeners()},
+		{"tracing", current.Tracing, next.Tracing},
+		{"storage", current.Storage, next.Storage},
+		{"timeouts.shutdown", current.Timeouts.Shutdown, next.Timeouts.Shutdown},
+		{"timeouts.read", current.Timeouts.Read, next.Timeouts.Read},
+		{"timeouts.write", current.Timeouts.Write, next.Timeouts.Write},
+		{"limits.max_concurrent_probes", current.Limits.MaxConcurrentProbes, next.Limits.MaxConcurrentProbes},
+		{"limits.max_concurrent_scripts", current.Limits.MaxConcurrentScripts, next.Limits.MaxConcurrentScripts},
+	} {
+		if !reflect.DeepEqual(setting.a, setting.b) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+//adminReload reloads configuration on request, e.g POST /admin/reload
+func (s *server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.requestLog(r).Info("configuration reload requested")
+	if err := s.Reload(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Reload_AppliesReloadableSettings(t *testing.T) {
+	s := NewServer(Configuration{ListenPort: 8080})
+	s.SetConfigLoader(func() (*Configuration, error) {
+		return &Configuration{
+			ListenPort: 9090,
+			LogLevel:   plog.DebugLevel,
+			Timeouts:   TimeoutConfiguration{Dial: time.Second},
+			Limits:     LimitConfiguration{MaxIPsPerScan: 5, SubmitRate: 1, SubmitBurst: 1},
+			Auth:       AuthConfiguration{APIKeys: []string{"0123456789abcdef"}},
+			Policy:     Policy{DeniedPorts: []PortRange{{25, 25}}},
+		}, nil
+	})
+
+	assert.Nil(t, s.Reload())
+	config := s.currentConfig()
+	assert.Equal(t, plog.DebugLevel, s.log.Level())
+	assert.Equal(t, time.Second, config.Timeouts.Dial)
+	assert.Equal(t, uint(5), config.Limits.MaxIPsPerScan)
+	assert.True(t, config.Auth.Enabled())
+	assert.Len(t, config.Policy.DeniedPorts, 1)
+	//the listen port can not change while running
+	assert.Equal(t, uint(8080), config.ListenPort)
+	assert.True(t, s.submitLimit.allow())
+	assert.False(t, s.submitLimit.allow())
+	assert.Equal(t, int64(1), s.metrics.reloadSuccesses)
+}
+
+func TestServer_Reload_KeepsConfigurationOnFailure(t *testing.T) {
+	s := NewServer(Configuration{Limits: LimitConfiguration{MaxIPsPerScan: 3}})
+	assert.EqualError(t, s.Reload(), "server has no configuration loader")
+
+	s.SetConfigLoader(func() (*Configuration, error) {
+		return nil, fmt.Errorf("config file is not valid")
+	})
+	assert.EqualError(t, s.Reload(), "config file is not valid")
+	assert.Equal(t, uint(3), s.currentConfig().Limits.MaxIPsPerScan)
+	assert.Equal(t, int64(2), s.metrics.reloadFailures)
+	assert.Equal(t, int32(0), s.metrics.lastReloadOK)
+}
+
+func TestRestartRequired(t *testing.T) {
+	current := Configuration{ListenPort: 8080}.withDefaults()
+	next := current
+	assert.Empty(t, restartRequired(current, next))
+
+	next.ListenPort = 9090
+	next.Storage = StorageConfiguration{Type: StorageFile, Path: "/tmp/jobs.json"}
+	next.Limits.MaxIPsPerScan = 1
+	assert.Equal(t, []string{"listen", "storage"}, restartRequired(current, next))
+}
+
+func TestServer_AdminReloadAndMetrics(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.SetConfigLoader(func() (*Configuration, error) {
+		return &Configuration{}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	s.adminReload(rec, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.adminReload(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, `pscan_config_reloads_total{result="success"} 1`), body)
+	assert.True(t, strings.Contains(body, `pscan_config_reloads_total{result="failure"} 0`), body)
+	assert.True(t, strings.Contains(body, "pscan_config_last_reload_success 1"), body)
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jbornemann/portscan/internal/cron"
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//maxScheduleRuns is how many of its most recent runs a schedule keeps. The results of older runs are deleted, so a
+//frequent schedule does not grow the job store without bound
+const maxScheduleRuns = 100
+
+//runScheduler submits the scans of schedules as they come due, checking at the start of each minute until stop is closed
+//A run missed while the server was stopped is made once, when the scheduler first checks
+func (s *server) runScheduler(stop <-chan struct{}) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			s.runDueSchedules(now)
+		}
+	}
+}
+
+//runDueSchedules runs each schedule not paused whose next run is at or before now
+//Runs are submitted without holding scheduleMu, as resolving their targets may be slow, so schedules may be changed
+//meanwhile. A run is recorded on the schedule as it then stands, and not at all if it was deleted
+func (s *server) runDueSchedules(now time.Time) {
+	s.scheduleMu.Lock()
+	due := make([]types.Schedule, 0)
+	for _, schedule := range s.jobs.Schedules() {
+		if schedule.Paused || schedule.NextRun == nil || schedule.NextRun.After(now) {
+			continue
+		}
+		due = append(due, schedule)
+	}
+	s.scheduleMu.Unlock()
+
+	for _, schedule := range due {
+		run := s.runSchedule(schedule, now)
+		s.scheduleMu.Lock()
+		if current, found := s.jobs.LoadSchedule(schedule.ID); found {
+			s.recordRun(&current, run, now)
+			s.jobs.SaveSchedule(current)
+		} else {
+			s.log.Warn("schedule deleted while its run was submitted", plog.Fields{"schedule_id": schedule.ID, "scan_id": run.ScanID})
+		}
+		s.scheduleMu.Unlock()
+	}
+}
+
+//runSchedule submits the scan of schedule, returning the run to record on it
+//The scan is checked as a submission is, so targets are resolved again and policy applied as it now stands
+//A run is skipped while the scan of the previous run is still in progress
+func (s *server) runSchedule(schedule types.Schedule, now time.Time) types.ScheduleRun {
+	log := s.log.With(plog.Fields{"schedule_id": schedule.ID})
+	run := types.ScheduleRun{Time: now}
+	if last := len(schedule.Runs) - 1; last >= 0 && schedule.Runs[last].ScanID != 0 {
+		if resp, found := s.jobs.Load(schedule.Runs[last].ScanID); found && !resp.Ready {
+			run.Error = fmt.Sprintf("skipped, scan %d of the previous run has not completed", schedule.Runs[last].ScanID)
+		}
+	}
+	if len(run.Error) == 0 {
+		ctx, span := s.tracer.Start(context.Background(), "schedule.run")
+		span.SetAttribute("schedule_id", schedule.ID)
+		if j, _, err := s.newJob(ctx, log, schedule.Scan); err != nil {
+			run.Error = err.Error()
+			span.SetError(err)
+		} else {
+			j.ScanID = rand.Uint64()
+			j.RequestID = fmt.Sprintf("schedule-%d", schedule.ID)
+			j.Schedule = schedule.ID
+			j.Previous = s.previousScan(schedule)
+			j.Trace = span.Context()
+			_, j.queued = s.tracer.Start(ctx, "job.queue")
+			j.queued.SetAttribute("scan_id", j.ScanID)
+			if s.enqueue(j) {
+				run.ScanID = j.ScanID
+			} else {
+				j.queued.End()
+				run.Error = "server is shutting down"
+			}
+		}
+		span.End()
+	}
+	if len(run.Error) > 0 {
+		log.Warn("scheduled scan not submitted", plog.Fields{"error": run.Error})
+	} else {
+		log.Info("scheduled scan submitted", plog.Fields{"scan_id": run.ScanID})
+	}
+	return run
+}
+
+//recordRun adds run to the runs of schedule, deleting the results of those no longer kept, and records when the next
+//is due, unless schedule was paused
+func (s *server) recordRun(schedule *types.Schedule, run types.ScheduleRun, now time.Time) {
+	schedule.Runs = append(schedule.Runs, run)
+	if dropped := len(schedule.Runs) - maxScheduleRuns; dropped > 0 {
+		for _, old := range schedule.Runs[:dropped] {
+			if old.ScanID != 0 {
+				s.jobs.Delete(old.ScanID)
+			}
+		}
+		schedule.Runs = append([]types.ScheduleRun(nil), schedule.Runs[dropped:]...)
+	}
+	if !schedule.Paused {
+		schedule.NextRun = nextRun(schedule.Cron, now)
+	}
+}
+
+//previousScan returns the scan of the most recent run of schedule that completed, or 0 if none has
+func (s *server) previousScan(schedule types.Schedule) uint64 {
+	for i := len(schedule.Runs) - 1; i >= 0; i-- {
+		if id := schedule.Runs[i].ScanID; id != 0 {
+			if resp, found := s.jobs.Load(id); found && resp.Ready {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+//nextRun returns when a schedule of spec next runs after now, or nil if it never does
+func nextRun(spec string, now time.Time) *time.Time {
+	c, err := cron.Parse(spec)
+	if err != nil {
+		return nil
+	}
+	next := c.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+//createSchedule stores a new schedule, checking its scan as a submission would be so it is refused now rather than
+//failing on each run
+func (s *server) createSchedule(w http.ResponseWriter, r *http.Request) {
+	log := s.requestLog(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("could not read schedule request body", plog.Fields{"error": err})
+		return
+	}
+	var request types.ScheduleRequest
+	if err := json.Unmarshal(bs, &request); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Warn("bad schedule request body", plog.Fields{"error": err, "body_bytes": len(bs)})
+		return
+	}
+	if valid, err := request.Validate(); !valid {
+		log.Warn("schedule request not valid", plog.Fields{"error": err})
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if _, status, err := s.newJob(r.Context(), log, request.Scan); err != nil {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	now := time.Now()
+	schedule := types.Schedule{
+		ID:      rand.Uint64(),
+		Name:    request.Name,
+		Cron:    request.Cron,
+		Scan:    request.Scan,
+		Created: now,
+		NextRun: nextRun(request.Cron, now),
+		Runs:    make([]types.ScheduleRun, 0),
+	}
+	s.scheduleMu.Lock()
+	s.jobs.SaveSchedule(schedule)
+	s.scheduleMu.Unlock()
+	log.Info("created schedule", plog.Fields{"schedule_id": schedule.ID, "cron": schedule.Cron})
+	s.writeJSON(w, r, http.StatusOK, schedule)
+}
+
+//listSchedules returns every schedule, in the order they were created
+func (s *server) listSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	schedules := s.jobs.Schedules()
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Created.Before(schedules[j].Created)
+	})
+	s.writeJSON(w, r, http.StatusOK, types.ScheduleListResponse{Schedules: schedules})
+}
+
+//pauseSchedule stops a schedule running until it is resumed
+func (s *server) pauseSchedule(w http.ResponseWriter, r *http.Request) {
+	s.updateSchedule(w, r, func(schedule *types.Schedule) {
+		schedule.Paused = true
+		schedule.NextRun = nil
+	})
+}
+
+//resumeSchedule runs a paused schedule again, from its next time after now. Runs missed while paused are not made
+func (s *server) resumeSchedule(w http.ResponseWriter, r *http.Request) {
+	s.updateSchedule(w, r, func(schedule *types.Schedule) {
+		schedule.Paused = false
+		schedule.NextRun = nextRun(schedule.Cron, time.Now())
+	})
+}
+
+//deleteSchedule forgets a schedule, and the results of its runs
+func (s *server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	s.updateSchedule(w, r, nil)
+}
+
+//updateSchedule applies update to the schedule a ScheduleIDRequest names and returns it, or deletes it if update is nil
+func (s *server) updateSchedule(w http.ResponseWriter, r *http.Request, update func(*types.Schedule)) {
+	log := s.requestLog(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error("could not read schedule request body", plog.Fields{"error": err})
+		return
+	}
+	var request types.ScheduleIDRequest
+	if err := json.Unmarshal(bs, &request); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Warn("bad schedule request body", plog.Fields{"error": err, "body_bytes": len(bs)})
+		return
+	}
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	schedule, found := s.jobs.LoadSchedule(request.ID)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if update == nil {
+		s.jobs.DeleteSchedule(request.ID)
+		for _, run := range schedule.Runs {
+			if run.ScanID != 0 {
+				s.jobs.Delete(run.ScanID)
+			}
+		}
+		log.Info("deleted schedule", plog.Fields{"schedule_id": request.ID})
+	} else {
+		update(&schedule)
+		s.jobs.SaveSchedule(schedule)
+		log.Info("updated schedule", plog.Fields{"schedule_id": request.ID, "paused": schedule.Paused})
+	}
+	s.writeJSON(w, r, http.StatusOK, schedule)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//callHandler calls a handler of s with body, returning the response
+func callHandler(s *server, handler http.HandlerFunc, method string, body interface{}) *httptest.ResponseRecorder {
+	bs, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, "/schedule", bytes.NewBuffer(bs)))
+	return rec
+}
+
+func TestServer_CreateSchedule(t *testing.T) {
+	policy := Policy{}
+	policy.DeniedPorts = []PortRange{{25, 25}}
+	s := NewServer(Configuration{Policy: policy})
+
+	rec := callHandler(s, s.createSchedule, http.MethodPost, types.ScheduleRequest{Cron: "61 * * * *", Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 80}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "61 is not a valid minute", rec.Body.String())
+
+	rec = callHandler(s, s.createSchedule, http.MethodPost, types.ScheduleRequest{Cron: "@daily", Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 25}})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "port 25 is a denied port", rec.Body.String())
+
+	before := time.Now()
+	rec = callHandler(s, s.createSchedule, http.MethodPost, types.ScheduleRequest{Name: "nightly", Cron: "@daily", Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 80}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var schedule types.Schedule
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &schedule))
+	assert.Equal(t, "nightly", schedule.Name)
+	assert.False(t, schedule.Paused)
+	if assert.NotNil(t, schedule.NextRun) {
+		assert.True(t, schedule.NextRun.After(before))
+		assert.Equal(t, 0, schedule.NextRun.Hour())
+	}
+
+	rec = callHandler(s, s.listSchedules, http.MethodGet, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list types.ScheduleListResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	if assert.Len(t, list.Schedules, 1) {
+		assert.Equal(t, schedule.ID, list.Schedules[0].ID)
+	}
+}
+
+func TestServer_PauseResumeDeleteSchedule(t *testing.T) {
+	s := NewServer(Configuration{})
+	rec := callHandler(s, s.createSchedule, http.MethodPost, types.ScheduleRequest{Cron: "@hourly", Scan: types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 80}})
+	var schedule types.Schedule
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &schedule))
+
+	rec = callHandler(s, s.pauseSchedule, http.MethodPost, types.ScheduleIDRequest{ID: schedule.ID})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	paused, _ := s.jobs.LoadSchedule(schedule.ID)
+	assert.True(t, paused.Paused)
+	assert.Nil(t, paused.NextRun)
+	//paused schedules do not run
+	s.runDueSchedules(time.Now().Add(24 * time.Hour))
+	paused, _ = s.jobs.LoadSchedule(schedule.ID)
+	assert.Empty(t, paused.Runs)
+
+	rec = callHandler(s, s.resumeSchedule, http.MethodPost, types.ScheduleIDRequest{ID: schedule.ID})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resumed, _ := s.jobs.LoadSchedule(schedule.ID)
+	assert.False(t, resumed.Paused)
+	assert.NotNil(t, resumed.NextRun)
+
+	s.jobs.Store(3, types.QueryResponse{Ready: true})
+	resumed.Runs = []types.ScheduleRun{{ScanID: 3}}
+	s.jobs.SaveSchedule(resumed)
+	rec = callHandler(s, s.deleteSchedule, http.MethodPost, types.ScheduleIDRequest{ID: schedule.ID})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, found := s.jobs.LoadSchedule(schedule.ID)
+	assert.False(t, found)
+	//the results of its runs go with it
+	_, found = s.jobs.Load(3)
+	assert.False(t, found)
+	rec = callHandler(s, s.deleteSchedule, http.MethodPost, types.ScheduleIDRequest{ID: schedule.ID})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_RunDueSchedules(t *testing.T) {
+	port := openPort(t)
+	s := NewServer(Configuration{})
+	go s.processWork()
+	next := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.jobs.SaveSchedule(types.Schedule{ID: 1, Cron: "*/5 * * * *", Scan: types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port}, NextRun: &next})
+	//a schedule whose previous run is still in progress is skipped
+	s.jobs.Store(7, types.QueryResponse{Ready: false})
+	s.jobs.SaveSchedule(types.Schedule{ID: 2, Cron: "*/5 * * * *", Scan: types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port}, NextRun: &next, Runs: []types.ScheduleRun{{ScanID: 7}}})
+
+	//not yet due
+	s.runDueSchedules(next.Add(-time.Second))
+	schedule, _ := s.jobs.LoadSchedule(1)
+	assert.Empty(t, schedule.Runs)
+
+	s.runDueSchedules(next.Add(time.Second))
+	schedule, _ = s.jobs.LoadSchedule(1)
+	if assert.Len(t, schedule.Runs, 1) {
+		assert.Empty(t, schedule.Runs[0].Error)
+		assert.NotZero(t, schedule.Runs[0].ScanID)
+	}
+	assert.Equal(t, next.Add(5*time.Minute), *schedule.NextRun)
+	skipped, _ := s.jobs.LoadSchedule(2)
+	if assert.Len(t, skipped.Runs, 2) {
+		assert.Equal(t, "skipped, scan 7 of the previous run has not completed", skipped.Runs[1].Error)
+		assert.Zero(t, skipped.Runs[1].ScanID)
+	}
+
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(5 * time.Second)
+	result, found := s.jobs.Load(schedule.Runs[0].ScanID)
+	assert.True(t, found)
+	assert.True(t, result.Ready)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN}}, result.Status)
+
+	//runs after shutdown are recorded as not submitted
+	s.runDueSchedules(next.Add(time.Hour))
+	schedule, _ = s.jobs.LoadSchedule(1)
+	if assert.Len(t, schedule.Runs, 2) {
+		assert.Equal(t, "server is shutting down", schedule.Runs[1].Error)
+	}
+}
+
+//blockingResolver resolves every host to 127.0.0.1, once release is closed
+type blockingResolver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	close(b.started)
+	<-b.release
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestServer_RunDueSchedules_DoesNotBlockUpdates(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.stopAccepting()
+	resolver := &blockingResolver{started: make(chan struct{}), release: make(chan struct{})}
+	s.resolver = resolver
+	next := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.jobs.SaveSchedule(types.Schedule{ID: 1, Cron: "*/5 * * * *", Scan: types.ScanRequest{ScanIPs: []string{"web.example.com"}, ScanPort: 80}, NextRun: &next})
+
+	ran := make(chan struct{})
+	go func() {
+		s.runDueSchedules(next)
+		close(ran)
+	}()
+	<-resolver.started
+	//the schedule can be paused while the targets of its run are resolved
+	rec := callHandler(s, s.pauseSchedule, http.MethodPost, types.ScheduleIDRequest{ID: 1})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	close(resolver.release)
+	<-ran
+
+	schedule, _ := s.jobs.LoadSchedule(1)
+	assert.True(t, schedule.Paused)
+	assert.Nil(t, schedule.NextRun)
+	if assert.Len(t, schedule.Runs, 1) {
+		assert.Equal(t, "server is shutting down", schedule.Runs[0].Error)
+	}
+}
+
+func TestServer_RunDueSchedules_DeletesResultsOfDroppedRuns(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.stopAccepting()
+	next := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	runs := make([]types.ScheduleRun, 0, maxScheduleRuns)
+	for id := uint64(1); id <= maxScheduleRuns; id++ {
+		s.jobs.Store(id, types.QueryResponse{Ready: true})
+		runs = append(runs, types.ScheduleRun{ScanID: id})
+	}
+	s.jobs.SaveSchedule(types.Schedule{ID: 1, Cron: "*/5 * * * *", Scan: types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 80}, NextRun: &next, Runs: runs})
+
+	s.runDueSchedules(next)
+	schedule, _ := s.jobs.LoadSchedule(1)
+	assert.Len(t, schedule.Runs, maxScheduleRuns)
+	assert.Equal(t, uint64(2), schedule.Runs[0].ScanID)
+	_, found := s.jobs.Load(1)
+	assert.False(t, found)
+	_, found = s.jobs.Load(2)
+	assert.True(t, found)
+}
+
+func TestServer_PreviousScan(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.jobs.Store(1, types.QueryResponse{Ready: true})
+	s.jobs.Store(2, types.QueryResponse{Ready: false})
+	schedule := types.Schedule{ID: 1, Runs: []types.ScheduleRun{{ScanID: 1}, {ScanID: 2}, {Error: "server is shutting down"}}}
+	//runs not submitted, or still in progress, are passed over
+	assert.Equal(t, uint64(1), s.previousScan(schedule))
+	assert.Equal(t, uint64(0), s.previousScan(types.Schedule{ID: 2}))
+}
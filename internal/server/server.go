@@ -5,185 +5,447 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net"
 	"net/http"
-	"strconv"
+	"os"
 	"sync"
-	"time"
+	"sync/atomic"
 
-	pnet "github.com/jbornemann/portscan/internal/net"
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/internal/trace"
+	"github.com/jbornemann/portscan/pkg/scanner"
 	"github.com/jbornemann/portscan/pkg/types"
 )
 
-//CommandLineArgs represents unmodified, direct arguments to start a port scan server
-type CommandLineArgs struct {
-	ListenPort string
-}
-
-//ValidateAndPrepare for a CommandLineArgs prepares a server configuration if the arguments given are valid
-//ValidateAndPrepare will return an error if these CommandLineArgs are not valid
-func (c CommandLineArgs) ValidateAndPrepare() (*Configuration, error) {
-	if len(c.ListenPort) == 0 {
-		return nil, fmt.Errorf("must provide a listen port")
-	} else if port, err := strconv.ParseUint(c.ListenPort, 10, 32); err != nil {
-		return nil, fmt.Errorf("listen port is not valid")
-	} else if !pnet.ValidPort(uint(port)) {
-		return nil, fmt.Errorf("listen port is not within valid port range")
-	} else {
-		return &Configuration{
-			ListenPort: uint(port),
-		}, nil
+//techniqueOrDefault returns technique, or types.TechniqueConnect if none is given
+func techniqueOrDefault(technique string) string {
+	if len(technique) == 0 {
+		return types.TechniqueConnect
 	}
+	return technique
 }
 
-//Configuration represents the runtime configuration for this port scan server
-type Configuration struct {
-	ListenPort uint
-}
-
-func getState(ip string, port uint) types.State {
-	if con, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), 5*time.Second); err != nil {
-		return types.CLOSED
-	} else {
-		_ = con.Close()
-		return types.OPEN
+//protocolOrDefault returns protocol, or types.TCP if none is given
+func protocolOrDefault(protocol string) string {
+	if len(protocol) == 0 {
+		return types.TCP
 	}
+	return protocol
 }
 
 type job struct {
 	ScanID uint64
 	Port   uint
-	IPs    []string
+	//Protocol is types.TCP or types.UDP
+	Protocol string
+	//Technique is types.TechniqueConnect or types.TechniqueSYN, for tcp jobs
+	Technique string
+	IPs       []string
+	//Targets maps ips expanded from a cidr or hostname to that target
+	Targets map[string]string
+	//RequestID is the id of the HTTP request that submitted this job, for log correlation
+	RequestID string
+	//Banner is the banner mode requested, if any
+	Banner string
+	//Detect identifies the services on open ports
+	Detect bool
+	//TLS records the tls certificates and versions of open ports
+	TLS bool
+	//HTTP fingerprints open ports that speak http
+	HTTP bool
+	//Discover pings each ip first, and ProbeDown probes the port of those found down rather than skipping it
+	Discover  bool
+	ProbeDown bool
+	//Probe names the prober checking the port in place of a connect or udp probe, if any
+	Probe string
+	//Scripts name the scripts run against the port of ips where it is open
+	Scripts []string
+	//Source names the source profile probes are sent from. Empty dials as the system would by default
+	Source string
+	//Trace is the span of the HTTP request that submitted this job
+	Trace trace.SpanContext
+	//Completed holds results from before this job was checkpointed, for a resumed job
+	Completed []types.IPStatus
+	//Schedule is the id of the schedule this job is a run of, if any, and Previous the scan of its last completed
+	//run, that alerts compare the results of this job to
+	Schedule uint64
+	Previous uint64
+	//queued measures the time this job waits between submission and processing
+	queued *trace.Span
 }
 
 type server struct {
+	//mu guards config and loader, which change on Reload
+	mu     sync.RWMutex
 	config Configuration
+	loader ConfigLoader
 
-	//Map of ScanID to QueryResponse
-	jobs   sync.Map
+	log    *plog.Logger
+	tracer *trace.Tracer
+
+	jobs   jobStore
 	workCh chan job
+	//resolver looks up the addresses of hostname targets
+	resolver scanner.Resolver
+	//scanner probes the ips of jobs, bounding concurrent probes across all jobs
+	scanner *scanner.Scanner
+	//probers are the probers scan requests may name
+	probers *scanner.Registry
+	//scripts runs the scripts of jobs, bounding concurrent scripts across all jobs
+	scripts *scanner.ScriptRunner
+	//scheduleMu is held while a schedule is read and saved again, so runs and updates of a schedule are not lost
+	scheduleMu sync.Mutex
+
+	//activeJobs counts jobs submitted but not yet completed
+	activeJobs int64
+	//shuttingDown is set to 1 once the server has been told to stop
+	shuttingDown int32
+	//accepting is held for reading while a job is handed to workCh, and for writing to stop accepting jobs
+	accepting sync.RWMutex
+	//running counts jobs being processed, for draining on shutdown
+	running sync.WaitGroup
+	//probeCtx is cancelled when the drain period ends, interrupting probes in progress
+	probeCtx     context.Context
+	cancelProbes context.CancelFunc
+
+	submitLimit *rateLimiter
+	metrics     serverMetrics
 }
 
 //NewServer returns a new server for the provided Configuration
+//NewServer will log the reason and return nil if the server can not be created, e.g if its job store can not be opened
 func NewServer(config Configuration) *server {
+	config = config.withDefaults()
+	log := plog.New(os.Stderr, config.LogLevel)
+	jobs, err := newJobStore(config.Storage)
+	if err != nil {
+		log.Error("could not open job store", plog.Fields{"error": err, "storage": config.Storage.Type})
+		return nil
+	}
+	sc := scanner.New(config.Limits.MaxConcurrentProbes)
+	if err := sc.SynError(); err != nil {
+		log.Info("syn scanning is not available, syn scans will connect instead", plog.Fields{"error": err})
+	}
+	if names := scanner.DefaultRegistry.Names(); len(names) > 0 {
+		log.Info("probers are registered", plog.Fields{"probers": names})
+	}
+	probeCtx, cancelProbes := context.WithCancel(context.Background())
 	return &server{
 		config: config,
-		jobs:   sync.Map{},
-		workCh: make(chan job),
+		log:    log,
+		tracer: trace.NewTracer(config.Tracing.exporter(), func(err error) {
+			log.Warn("could not export spans", plog.Fields{"error": err})
+		}),
+		jobs:        jobs,
+		workCh:      make(chan job),
+		resolver:    net.DefaultResolver,
+		scanner:     sc,
+		probers:     scanner.DefaultRegistry,
+		scripts:     scanner.NewScriptRunner(config.Limits.MaxConcurrentScripts),
+		submitLimit: newRateLimiter(config.Limits.SubmitRate, config.Limits.SubmitBurst),
+		metrics:     serverMetrics{lastReloadOK: 1},
+
+		probeCtx:     probeCtx,
+		cancelProbes: cancelProbes,
 	}
 }
 
+//requestIDHeader carries the request id back to the caller, and may be set by the caller to supply its own
+const requestIDHeader = "X-Request-ID"
+
+//withRequestID tags each HTTP call with a request id, available to handlers through plog.RequestID
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = plog.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(plog.WithRequestID(r.Context(), id)))
+	})
+}
+
+//validRequestID returns true if a caller supplied request id is safe to use in logs
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//withTracing wraps each HTTP call in a span, continuing the caller's trace if a traceparent header was sent
+func (s *server) withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.Extract(r.Header); ok {
+			ctx = trace.ContextWithRemote(ctx, sc)
+		}
+		ctx, span := s.tracer.Start(ctx, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		defer span.End()
+		span.SetKind(trace.SpanKindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("request_id", plog.RequestID(ctx))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", rec.status)
+	})
+}
+
+//statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+//requestLog returns a Logger tagged with the request id and trace id of r
+func (s *server) requestLog(r *http.Request) *plog.Logger {
+	fields := plog.Fields{"request_id": plog.RequestID(r.Context())}
+	if span := trace.SpanFromContext(r.Context()); span != nil {
+		fields["trace_id"] = span.Context().TraceID.String()
+	}
+	return s.log.With(fields)
+}
+
 //Run starts the server. Send to killCh to shutdown the server.
 func (s *server) Run(killCh <-chan bool) {
 	mux := http.NewServeMux()
-	mux.Handle("/submit", http.HandlerFunc(s.submitRequest))
-	mux.Handle("/query", http.HandlerFunc(s.query))
+	mux.Handle("/submit", s.withAuth(s.submitRequest))
+	mux.Handle("/query", s.withAuth(s.query))
+	mux.Handle("/diff", s.withAuth(s.diff))
+	mux.Handle("/healthz", http.HandlerFunc(s.healthz))
+	mux.Handle("/readyz", http.HandlerFunc(s.readyz))
+	mux.Handle("/version", http.HandlerFunc(s.version))
+	mux.Handle("/metrics", http.HandlerFunc(s.metricsHandler))
+	mux.Handle("/admin/reload", s.withAuth(s.adminReload))
+	mux.Handle("/schedule/create", s.withAuth(s.createSchedule))
+	mux.Handle("/schedule/list", s.withAuth(s.listSchedules))
+	mux.Handle("/schedule/pause", s.withAuth(s.pauseSchedule))
+	mux.Handle("/schedule/resume", s.withAuth(s.resumeSchedule))
+	mux.Handle("/schedule/delete", s.withAuth(s.deleteSchedule))
 	mux.Handle("*", http.NotFoundHandler())
+	config := s.currentConfig()
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", s.config.ListenPort),
-		Handler: mux,
+		Handler:      withRequestID(s.withTracing(mux)),
+		ReadTimeout:  config.Timeouts.Read,
+		WriteTimeout: config.Timeouts.Write,
 	}
 
-	go func() {
-		log.Printf("listening on %d\n", s.config.ListenPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalln(err.Error())
+	//open every listener before serving any, so a bad address fails startup rather than leaving a partial server
+	addresses := config.listeners()
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, l := range addresses {
+		listener, err := listen(l)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			s.log.Fatal("could not listen", plog.Fields{"address": l.String(), "error": err})
 		}
-	}()
+		listeners = append(listeners, listener)
+	}
+	for i, listener := range listeners {
+		go func(address string, listener net.Listener) {
+			s.log.Info("listening", plog.Fields{"address": address, "auth": config.Auth.Enabled()})
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				s.log.Fatal("could not listen", plog.Fields{"address": address, "error": err})
+			}
+		}(addresses[i].String(), listener)
+	}
 
 	//Begin processing port scan requests received in the background
 	go s.processWork()
+	if !config.Storage.persistent() {
+		s.log.Warn("jobs unfinished at shutdown will not be resumed on next start, as that requires file storage", plog.Fields{"storage": config.Storage.Type})
+	}
+	s.resumeCheckpoints()
+	stopScheduler := make(chan struct{})
+	go s.runScheduler(stopScheduler)
 
 	<-killCh
-	log.Println("shutting down")
+	s.log.Info("shutting down")
+	close(stopScheduler)
+	s.stopAccepting()
 	//Give server some time to gracefully respond to active connections
-	waitCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+	waitCtx, done := context.WithTimeout(context.Background(), config.Timeouts.Shutdown)
 	defer done()
 	if err := server.Shutdown(waitCtx); err != nil && err != http.ErrServerClosed {
-		log.Fatalln(err.Error())
+		s.log.Error("could not shutdown gracefully", plog.Fields{"error": err})
 	}
 	close(s.workCh)
-	log.Println("goodbye")
+	s.drain(s.currentConfig().Timeouts.Drain)
+	flushCtx, flushed := context.WithTimeout(context.Background(), config.Timeouts.Shutdown)
+	defer flushed()
+	if err := s.tracer.Shutdown(flushCtx); err != nil {
+		s.log.Warn("could not flush spans", plog.Fields{"error": err})
+	}
+	_ = s.scanner.Close()
+	s.log.Info("goodbye")
 }
 
 func (s *server) submitRequest(w http.ResponseWriter, r *http.Request) {
+	log := s.requestLog(r)
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if bs, err := ioutil.ReadAll(r.Body); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf(err.Error())
+		log.Error("could not read submit request body", plog.Fields{"error": err})
 		return
 	} else {
 		var request types.ScanRequest
 		if err := json.Unmarshal(bs, &request); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("bad submit request body (%s)", string(bs))
+			log.Warn("bad submit request body", plog.Fields{"error": err, "body_bytes": len(bs)})
 			return
 		}
-		log.Printf("got request to scan : %+v", request)
-		if valid, err := request.Validate(); !valid {
-			log.Printf("request not valid: %+v", request)
-			w.WriteHeader(http.StatusBadRequest)
-			if err != nil {
-				_, _ = w.Write([]byte(err.Error()))
-			}
+		log.Info("got request to scan", plog.Fields{"ip_count": len(request.ScanIPs), "port": request.ScanPort})
+		//the rate is checked before targets are expanded, so refused submissions resolve no hostnames
+		if !s.submitLimit.allow() {
+			log.Warn("submission rate exceeded")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		j, status, err := s.newJob(r.Context(), log, request)
+		if err != nil {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 		scanId := rand.Uint64()
-		s.workCh <- job{
-			ScanID: scanId,
-			Port:   request.ScanPort,
-			IPs:    request.ScanIPs,
+		_, queued := s.tracer.Start(r.Context(), "job.queue")
+		queued.SetAttribute("scan_id", scanId)
+		j.ScanID = scanId
+		j.RequestID = plog.RequestID(r.Context())
+		j.Trace = trace.SpanFromContext(r.Context()).Context()
+		j.queued = queued
+		if !s.enqueue(j) {
+			queued.End()
+			log.Warn("refused submission while shutting down")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		}
-		log.Printf("%v submitted for work", scanId)
-		s.jobs.Store(scanId, types.QueryResponse{
-			Ready:    false,
-			ScanPort: request.ScanPort,
-		})
+		log.Info("submitted for work", plog.Fields{"scan_id": scanId})
 		resp := types.ScanResponse{
 			ScanID: scanId,
 		}
 		bs, err := json.Marshal(&resp)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf(err.Error())
+			log.Error("could not marshal response", plog.Fields{"error": err})
 			return
 		}
 		_, _ = w.Write(bs)
 	}
 }
 
+//newJob checks request as a submission is checked, returning the job that scans it, or the status and error to refuse it with
+//The caller sets the scan id, request id and trace of the job
+func (s *server) newJob(ctx context.Context, log *plog.Logger, request types.ScanRequest) (job, int, error) {
+	if valid, err := request.Validate(); !valid {
+		log.Warn("request not valid", plog.Fields{"error": err})
+		return job{}, http.StatusBadRequest, err
+	}
+	config := s.currentConfig()
+	resolveCtx, resolved := context.WithTimeout(ctx, config.Timeouts.Dial)
+	targets, err := scanner.ExpandTargets(resolveCtx, s.resolver, request.ScanIPs, config.Limits.MaxIPsPerScan)
+	resolved()
+	if err != nil {
+		log.Warn("request targets could not be expanded", plog.Fields{"error": err, "target_count": len(request.ScanIPs)})
+		return job{}, http.StatusBadRequest, err
+	}
+	request.ScanIPs = targets.IPs
+	if profile, found := config.Sources[request.Source]; len(request.Source) > 0 && !found {
+		log.Warn("request names an unknown source profile", plog.Fields{"source": request.Source})
+		return job{}, http.StatusBadRequest, fmt.Errorf("source profile %s is not configured", request.Source)
+	} else if err := profile.reaches(request.ScanIPs); err != nil {
+		log.Warn("request targets can not be reached from its source profile", plog.Fields{"error": err, "source": request.Source})
+		return job{}, http.StatusBadRequest, err
+	}
+	if len(request.Probe) > 0 {
+		if err := s.probers.Check(request.Probe, protocolOrDefault(request.Protocol)); err != nil {
+			log.Warn("request names a prober that can not be used", plog.Fields{"error": err, "probe": request.Probe})
+			return job{}, http.StatusBadRequest, err
+		}
+	}
+	for _, name := range request.Scripts {
+		if _, err := scanner.FindScript(config.Scripts.Directory, name); err != nil {
+			log.Warn("request names a script that can not be run", plog.Fields{"error": err, "script": name})
+			return job{}, http.StatusBadRequest, err
+		}
+	}
+	if err := config.Policy.Check(request); err != nil {
+		log.Warn("request denied by policy", plog.Fields{"error": err})
+		return job{}, http.StatusForbidden, err
+	}
+	return job{
+		Port:      request.ScanPort,
+		Protocol:  protocolOrDefault(request.Protocol),
+		Technique: techniqueOrDefault(request.Technique),
+		IPs:       request.ScanIPs,
+		Targets:   targets.Targets,
+		Source:    request.Source,
+		Banner:    request.Banner,
+		Detect:    request.Detect,
+		TLS:       request.TLS,
+		HTTP:      request.HTTP,
+		Discover:  request.Discover,
+		ProbeDown: request.ProbeDown,
+		Probe:     request.Probe,
+		Scripts:   request.Scripts,
+	}, 0, nil
+}
+
 func (s *server) query(w http.ResponseWriter, r *http.Request) {
+	log := s.requestLog(r)
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if bs, err := ioutil.ReadAll(r.Body); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf(err.Error())
+		log.Error("could not read query request body", plog.Fields{"error": err})
 		return
 	} else {
 		var req types.QueryRequest
 		if err := json.Unmarshal(bs, &req); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("bad query request body (%s)", string(bs))
+			log.Warn("bad query request body", plog.Fields{"error": err, "body_bytes": len(bs)})
 			return
 		}
+		log.Debug("query", plog.Fields{"scan_id": req.ScanID})
 		var resp types.QueryResponse
 		if work, found := s.jobs.Load(req.ScanID); found {
-			resp = work.(types.QueryResponse)
+			resp = work
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if len(req.Service) > 0 || len(req.Product) > 0 {
+			filtered := make([]types.IPStatus, 0)
+			for _, status := range resp.Status {
+				if req.Matches(status) {
+					filtered = append(filtered, status)
+				}
+			}
+			resp.Status = filtered
+		}
 		bs, err := json.Marshal(&resp)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf(err.Error())
+			log.Error("could not marshal response", plog.Fields{"error": err})
 			return
 		}
 		_, _ = w.Write(bs)
@@ -196,26 +458,161 @@ func (s *server) processWork() {
 	}
 }
 
+//processJob probes every ip of job. If probes are interrupted by shutdown, the job's progress is checkpointed instead
 func (s *server) processJob(job job) {
-	wg := &sync.WaitGroup{}
+	defer s.running.Done()
+	defer atomic.AddInt64(&s.activeJobs, -1)
+	job.queued.End()
+	ctx, span := s.tracer.Start(trace.ContextWithRemote(context.Background(), job.Trace), "job.process")
+	defer span.End()
+	span.SetAttribute("scan_id", job.ScanID)
+	span.SetAttribute("ip_count", len(job.IPs))
+	span.SetAttribute("port", job.Port)
+	span.SetAttribute("protocol", job.Protocol)
+
+	log := s.log.With(plog.Fields{"request_id": job.RequestID, "scan_id": job.ScanID, "trace_id": span.Context().TraceID.String()})
+	log.Debug("processing job", plog.Fields{"ip_count": len(job.IPs), "port": job.Port, "protocol": job.Protocol, "source": job.Source})
+	config := s.currentConfig()
+	source, found := config.Sources[job.Source]
+	if len(job.Source) > 0 {
+		span.SetAttribute("source", job.Source)
+	}
+	if len(job.Probe) > 0 {
+		span.SetAttribute("probe", job.Probe)
+	}
+	//a profile removed by a reload after the job was submitted leaves every ip unprobed, so the job is checkpointed
+	//and resumed on a later start with the profile restored
+	toProbe := job.IPs
+	if len(job.Source) > 0 && !found {
+		log.Error("source profile of job is no longer configured", plog.Fields{"source": job.Source})
+		toProbe = nil
+	}
 	results := make([]types.IPStatus, len(job.IPs))
+	probed := make([]bool, len(job.IPs))
+	index := make(map[string]int, len(job.IPs))
 	for i, ip := range job.IPs {
-		wg.Add(1)
-		go func(index int, ip string) {
-			state := getState(ip, job.Port)
-			results[index] = types.IPStatus{
-				IP:    ip,
-				State: state,
+		index[ip] = i
+	}
+	options := scanner.Options{
+		Protocol:       job.Protocol,
+		Technique:      job.Technique,
+		Banner:         job.Banner,
+		Detect:         job.Detect,
+		TLS:            job.TLS,
+		HTTP:           job.HTTP,
+		Discover:       job.Discover,
+		ProbeDown:      job.ProbeDown,
+		Prober:         job.Probe,
+		Dialer:         source.dialer(config.Timeouts.Dial),
+		Source:         source.scanSource(),
+		Timeouts:       config.Timeouts.scanTimeouts(),
+		MaxIPs:         uint(len(toProbe)),
+		MaxBannerBytes: config.Limits.MaxBannerBytes,
+		ServiceProbes:  config.Detection.Probes,
+		Probers:        s.probers,
+		Scripts:        job.Scripts,
+		ScriptDir:      config.Scripts.Directory,
+		ScriptRunner:   s.scripts,
+		OnProbe: func(ip string, port uint) func(*scanner.Result) {
+			_, dial := s.tracer.Start(ctx, "probe.dial")
+			dial.SetAttribute("ip", ip)
+			dial.SetAttribute("port", port)
+			dial.SetAttribute("protocol", job.Protocol)
+			return func(r *scanner.Result) {
+				defer dial.End()
+				if r == nil {
+					log.Debug("probe interrupted", plog.Fields{"ip": ip, "port": port})
+					dial.SetError(fmt.Errorf("interrupted by shutdown"))
+					return
+				}
+				dial.SetAttribute("state", string(r.State))
+				if job.Discover {
+					dial.SetAttribute("host", string(r.Host))
+				}
+			}
+		},
+	}
+	if _, reason := s.scanner.Technique(options); len(reason) > 0 {
+		log.Warn("connecting instead of syn scanning", plog.Fields{"reason": reason})
+	}
+	span.SetAttribute("technique", job.Technique)
+	scanned, err := s.scanner.ScanExpansion(s.probeCtx, scanner.Expansion{IPs: toProbe, Targets: job.Targets}, []uint{job.Port}, options)
+	if err != nil {
+		log.Error("job can not be scanned", plog.Fields{"error": err})
+	} else {
+		for r := range scanned {
+			fields := plog.Fields{"ip": r.IP, "port": r.Port, "protocol": job.Protocol}
+			if r.Err != nil {
+				fields["error"] = r.Err
 			}
-			wg.Done()
-		}(i, ip)
+			log.Debug("probe "+string(r.State), fields)
+			results[index[r.IP]] = r.IPStatus
+			probed[index[r.IP]] = true
+		}
+	}
+
+	completed := append(make([]types.IPStatus, 0, len(job.Completed)+len(results)), job.Completed...)
+	remaining := make([]string, 0)
+	for i, ip := range job.IPs {
+		if probed[i] {
+			completed = append(completed, results[i])
+		} else {
+			remaining = append(remaining, ip)
+		}
+	}
+	if len(remaining) > 0 {
+		s.jobs.SaveCheckpoint(checkpoint{
+			ScanID:    job.ScanID,
+			Port:      job.Port,
+			Protocol:  job.Protocol,
+			Technique: job.Technique,
+			Remaining: remaining,
+			Targets:   job.Targets,
+			Completed: completed,
+			RequestID: job.RequestID,
+			Source:    job.Source,
+			Banner:    job.Banner,
+			Detect:    job.Detect,
+			TLS:       job.TLS,
+			HTTP:      job.HTTP,
+			Discover:  job.Discover,
+			ProbeDown: job.ProbeDown,
+			Probe:     job.Probe,
+			Scripts:   job.Scripts,
+			Schedule:  job.Schedule,
+			Previous:  job.Previous,
+		})
+		span.SetAttribute("checkpointed", true)
+		log.Warn("checkpointed unfinished job", plog.Fields{"remaining": len(remaining), "completed": len(completed)})
+		return
 	}
-	wg.Wait()
-	log.Printf("%v completed", job.ScanID)
+
+	log.Info("completed")
 	resp := types.QueryResponse{
 		Ready:    true,
 		ScanPort: job.Port,
-		Status:   results,
+		Protocol: job.Protocol,
+		Source:   job.Source,
+		Status:   completed,
+	}
+	//the baseline is that configured on completion, so a reload applies to jobs already running
+	if baseline := s.currentConfig().Baseline; baseline.Enabled() {
+		resp.Baseline = baseline.evaluate(resp)
+		span.SetAttribute("baseline_passed", resp.Baseline.Passed)
+		if resp.Baseline.Evaluated == 0 {
+			log.Warn("no baseline rule applies to the addresses scanned", plog.Fields{"baseline": baseline.File})
+		} else if !resp.Baseline.Passed {
+			log.Warn("baseline violated", plog.Fields{"baseline": baseline.File, "violations": len(resp.Baseline.Violations)})
+		}
 	}
 	s.jobs.Store(job.ScanID, resp)
+	s.jobs.DeleteCheckpoint(job.ScanID)
+	//exports and alerts are interrupted with probes once the drain timeout is reached, so they can not hold up shutdown
+	dispatchCtx := trace.ContextWithSpan(s.probeCtx, span)
+	if export := s.currentConfig().Export; len(export.Exporters) > 0 {
+		s.exportResult(dispatchCtx, log, export, job, resp)
+	}
+	if alerts := s.currentConfig().Alerts; len(alerts.Rules) > 0 {
+		s.sendAlerts(dispatchCtx, log, alerts, job, resp)
+	}
 }
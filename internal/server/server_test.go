@@ -1,8 +1,25 @@
 package server
 
 import (
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/internal/trace"
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCommandLineArgs_ValidateAndPrepare_ListenPortMustBeProvided(t *testing.T) {
@@ -37,3 +54,359 @@ func TestCommandLineArgs_ValidateAndPrepare(t *testing.T) {
 	assert.NotNil(t, config)
 	assert.Equal(t, uint(8080), config.ListenPort)
 }
+
+func TestCommandLineArgs_ValidateAndPrepare_LogLevelMustBeValid(t *testing.T) {
+	args := CommandLineArgs{
+		ListenPort: "8080",
+		LogLevel:   "loud",
+	}
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, config)
+	assert.EqualError(t, err, "loud is not a valid log level")
+
+	args.LogLevel = "debug"
+	config, err = args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, plog.DebugLevel, config.LogLevel)
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = plog.RequestID(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", nil))
+	assert.NotEmpty(t, seen)
+	assert.Equal(t, seen, rec.Header().Get(requestIDHeader))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set(requestIDHeader, "from-client-1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "from-client-1", seen)
+
+	req = httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.NotEqual(t, "bad id\n", seen)
+}
+
+func TestCommandLineArgs_ValidateAndPrepare_Tracing(t *testing.T) {
+	args := CommandLineArgs{
+		ListenPort: "8080",
+	}
+	config, err := args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, TraceExporterNone, config.Tracing.Exporter)
+
+	args.TraceExporter = "jaeger"
+	_, err = args.ValidateAndPrepare()
+	assert.EqualError(t, err, "jaeger is not a valid trace exporter")
+
+	args.TraceExporter = TraceExporterOTLP
+	_, err = args.ValidateAndPrepare()
+	assert.EqualError(t, err, "must provide a trace endpoint for the otlp trace exporter")
+	args.TraceEndpoint = "localhost:4318"
+	_, err = args.ValidateAndPrepare()
+	assert.EqualError(t, err, "trace endpoint is not a valid http(s) url")
+	args.TraceEndpoint = "http://localhost:4318/v1/traces"
+	config, err = args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:4318/v1/traces", config.Tracing.Endpoint)
+
+	args.TraceExporter = TraceExporterFile
+	_, err = args.ValidateAndPrepare()
+	assert.EqualError(t, err, "must provide a trace file for the file trace exporter")
+	args.TraceFile = "/tmp/spans.jsonl"
+	config, err = args.ValidateAndPrepare()
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/spans.jsonl", config.Tracing.File)
+}
+
+func TestWithTracing_ContinuesCallerTrace(t *testing.T) {
+	s := NewServer(Configuration{})
+	var seen trace.SpanContext
+	handler := s.withTracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = trace.SpanFromContext(r.Context()).Context()
+	}))
+
+	caller := trace.NewRootContext()
+	req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	trace.Inject(caller, req.Header)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, caller.TraceID, seen.TraceID)
+	assert.NotEqual(t, caller.SpanID, seen.SpanID)
+}
+
+func TestServer_SubmitRequest_EnforcesLimitsAndPolicy(t *testing.T) {
+	policy := Policy{}
+	policy.DeniedPorts = []PortRange{{25, 25}}
+	s := NewServer(Configuration{
+		Limits: LimitConfiguration{MaxIPsPerScan: 1},
+		Policy: policy,
+	})
+
+	submit := func(req types.ScanRequest) *httptest.ResponseRecorder {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.submitRequest(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(bs)))
+		return rec
+	}
+
+	rec := submit(types.ScanRequest{ScanIPs: []string{"10.0.0.1", "10.0.0.2"}, ScanPort: 80})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "scan requests may contain at most 1 ips", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"10.0.0.1"}, ScanPort: 25})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "port 25 is a denied port", rec.Body.String())
+}
+
+//countingResolver counts the lookups made of it, resolving every host to 127.0.0.1
+type countingResolver struct {
+	lookups int32
+}
+
+func (c *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&c.lookups, 1)
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestServer_SubmitRequest_RateLimitedBeforeResolving(t *testing.T) {
+	s := NewServer(Configuration{Limits: LimitConfiguration{SubmitRate: 0.001, SubmitBurst: 1}})
+	resolver := &countingResolver{}
+	s.resolver = resolver
+
+	submit := func(req types.ScanRequest) int {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.submitRequest(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(bs)))
+		return rec.Code
+	}
+	//a submission counts against the rate whether or not it is valid
+	assert.Equal(t, http.StatusBadRequest, submit(types.ScanRequest{ScanIPs: []string{"web.example.com"}}))
+	assert.Equal(t, http.StatusTooManyRequests, submit(types.ScanRequest{ScanIPs: []string{"web.example.com"}, ScanPort: 80}))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&resolver.lookups))
+}
+
+func TestServer_Query_FiltersByService(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.jobs.Store(1, types.QueryResponse{Ready: true, ScanPort: 22, Status: []types.IPStatus{
+		{IP: "10.0.0.1", State: types.OPEN, Service: &types.Service{Name: "ssh", Product: "OpenSSH", Version: "8.9p1"}},
+		{IP: "10.0.0.2", State: types.OPEN, Service: &types.Service{Name: "ssh", Product: "Dropbear sshd"}},
+		{IP: "10.0.0.3", State: types.CLOSED},
+	}})
+
+	query := func(req types.QueryRequest) types.QueryResponse {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.query(rec, httptest.NewRequest(http.MethodPost, "/query", bytes.NewBuffer(bs)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var resp types.QueryResponse
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+	assert.Len(t, query(types.QueryRequest{ScanID: 1}).Status, 3)
+	assert.Len(t, query(types.QueryRequest{ScanID: 1, Service: "ssh"}).Status, 2)
+	resp := query(types.QueryRequest{ScanID: 1, Product: "openssh"})
+	assert.Len(t, resp.Status, 1)
+	assert.Equal(t, "10.0.0.1", resp.Status[0].IP)
+}
+
+//startJobs enqueues jobs on a new server with config, after calling setup if set, and stops it accepting more
+func startJobs(t *testing.T, config Configuration, setup func(*server), jobs ...job) *server {
+	s := NewServer(config)
+	if setup != nil {
+		setup(s)
+	}
+	go s.processWork()
+	for _, j := range jobs {
+		assert.True(t, s.enqueue(j))
+	}
+	s.stopAccepting()
+	close(s.workCh)
+	return s
+}
+
+//runJobs processes jobs to completion on a new server with config, after calling setup if set
+func runJobs(t *testing.T, config Configuration, setup func(*server), jobs ...job) *server {
+	s := startJobs(t, config, setup, jobs...)
+	s.drain(5 * time.Second)
+	return s
+}
+
+//udpEcho listens on a local udp port, answering each datagram
+func udpEcho(t *testing.T) uint {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], from)
+		}
+	}()
+	return uint(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestServer_ProcessJobWithBanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("220 ftp ready\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	port := uint(l.Addr().(*net.TCPAddr).Port)
+	s := runJobs(t, Configuration{}, nil, job{ScanID: 1, Port: port, Protocol: types.TCP, Banner: types.BannerPassive, IPs: []string{"127.0.0.1"}})
+
+	result, _ := s.jobs.Load(1)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN, Banner: "220 ftp ready"}}, result.Status)
+}
+
+func TestServer_ProcessUDPJob(t *testing.T) {
+	s := runJobs(t, Configuration{}, nil, job{ScanID: 1, Port: udpEcho(t), Protocol: types.UDP, IPs: []string{"127.0.0.1"}})
+
+	result, found := s.jobs.Load(1)
+	assert.True(t, found)
+	assert.Equal(t, types.UDP, result.Protocol)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN}}, result.Status)
+}
+
+func TestServer_ProcessJobWithSyn(t *testing.T) {
+	if _, reason := scanner.New(1).Technique(scanner.Options{Technique: types.TechniqueSYN}); len(reason) > 0 {
+		t.Skip("syn scanning is not available")
+	}
+	port := openPort(t)
+	s := runJobs(t, Configuration{}, nil, job{ScanID: 1, Port: port, Protocol: types.TCP, Technique: types.TechniqueSYN, IPs: []string{"127.0.0.1", "::1"}})
+
+	result, _ := s.jobs.Load(1)
+	assert.Len(t, result.Status, 2)
+	assert.Equal(t, types.IPStatus{IP: "127.0.0.1", State: types.OPEN}, result.Status[0])
+}
+
+func TestServer_ProcessJobWithDiscovery(t *testing.T) {
+	port := openPort(t)
+	s := runJobs(t, Configuration{Timeouts: TimeoutConfiguration{Discovery: 500 * time.Millisecond}}, nil,
+		job{ScanID: 1, Port: port, Protocol: types.TCP, IPs: []string{"127.0.0.1", "100::1"}, Discover: true},
+		job{ScanID: 2, Port: port, Protocol: types.TCP, IPs: []string{"100::1"}, Discover: true, ProbeDown: true})
+
+	result, _ := s.jobs.Load(1)
+	assert.Len(t, result.Status, 2)
+	assert.Equal(t, types.OPEN, result.Status[0].State)
+	assert.Equal(t, types.HOST_UP, result.Status[0].Host)
+	assert.Equal(t, types.IPStatus{IP: "100::1", State: types.SKIPPED, Host: types.HOST_DOWN}, result.Status[1])
+
+	result, _ = s.jobs.Load(2)
+	assert.Len(t, result.Status, 1)
+	assert.Equal(t, types.CLOSED, result.Status[0].State)
+	assert.Equal(t, types.HOST_DOWN, result.Status[0].Host)
+}
+
+//refusingProber reports every port it checks closed
+type refusingProber struct{}
+
+func (refusingProber) Name() string {
+	return "refusing"
+}
+
+func (refusingProber) Protocols() []string {
+	return []string{types.TCP}
+}
+
+func (refusingProber) Probe(ctx context.Context, target scanner.Target) (types.IPStatus, error) {
+	return types.IPStatus{State: types.CLOSED, Banner: fmt.Sprintf("refused %d", target.Port)}, nil
+}
+
+func TestServer_SubmitRequest_SelectsProber(t *testing.T) {
+	port := openPort(t)
+	s := NewServer(Configuration{})
+	s.probers = scanner.NewRegistry()
+	assert.Nil(t, s.probers.Register(refusingProber{}))
+	go s.processWork()
+
+	submit := func(req types.ScanRequest) *httptest.ResponseRecorder {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.submitRequest(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(bs)))
+		return rec
+	}
+
+	rec := submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Probe: "acme"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "no prober named acme is registered", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Protocol: types.UDP, Probe: "refusing"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "prober refusing can not check udp ports", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Probe: "refusing"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp types.ScanResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(time.Second)
+
+	result, found := s.jobs.Load(resp.ScanID)
+	assert.True(t, found)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.CLOSED, Banner: fmt.Sprintf("refused %d", port)}}, result.Status)
+}
+
+func TestServer_SubmitRequest_RunsScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "port.sh"), []byte("#!/bin/sh\necho \"{\\\"port\\\": $PSCAN_PORT}\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	port := openPort(t)
+	s := NewServer(Configuration{Scripts: ScriptConfiguration{Directory: dir}})
+	go s.processWork()
+
+	submit := func(req types.ScanRequest) *httptest.ResponseRecorder {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.submitRequest(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(bs)))
+		return rec
+	}
+
+	rec := submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Scripts: []string{"missing.sh"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "script missing.sh is not installed", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Scripts: []string{"port.sh"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp types.ScanResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(5 * time.Second)
+
+	result, found := s.jobs.Load(resp.ScanID)
+	assert.True(t, found)
+	if assert.Len(t, result.Status, 1) {
+		assert.Equal(t, []types.ScriptResult{{Name: "port.sh", Output: json.RawMessage(fmt.Sprintf(`{"port":%d}`, port))}}, result.Status[0].Scripts)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	plog "github.com/jbornemann/portscan/internal/log"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//enqueue hands j to the workers, storing its pending result first so a quick job's result is never overwritten
+//enqueue returns false, doing nothing, if the server has stopped accepting jobs
+func (s *server) enqueue(j job) bool {
+	s.accepting.RLock()
+	defer s.accepting.RUnlock()
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		return false
+	}
+	s.jobs.Store(j.ScanID, types.QueryResponse{
+		Ready:    false,
+		ScanPort: j.Port,
+		Protocol: j.Protocol,
+		Source:   j.Source,
+	})
+	atomic.AddInt64(&s.activeJobs, 1)
+	s.running.Add(1)
+	s.workCh <- j
+	return true
+}
+
+//stopAccepting refuses new jobs. Once it returns no further jobs will be sent to workCh
+func (s *server) stopAccepting() {
+	s.accepting.Lock()
+	defer s.accepting.Unlock()
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+//drain waits up to timeout for running jobs to complete. Jobs still running after timeout have their probes
+//interrupted, and checkpoint their progress to the job store to be resumed on next start
+func (s *server) drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	s.log.Info("draining jobs", plog.Fields{"active_jobs": atomic.LoadInt64(&s.activeJobs), "drain_timeout": timeout.String()})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		s.log.Info("all jobs drained")
+		return
+	case <-timer.C:
+	}
+
+	if s.currentConfig().Storage.persistent() {
+		s.log.Warn("drain timeout reached, checkpointing unfinished jobs", plog.Fields{"active_jobs": atomic.LoadInt64(&s.activeJobs)})
+	} else {
+		s.log.Warn("drain timeout reached, unfinished jobs will be lost, as they are only resumed with file storage", plog.Fields{"active_jobs": atomic.LoadInt64(&s.activeJobs)})
+	}
+	s.cancelProbes()
+	//interrupted probes return promptly, after which each job saves its checkpoint
+	<-done
+}
+
+//resumeCheckpoints restarts the jobs interrupted by a previous shutdown, probing only the ips not yet probed
+func (s *server) resumeCheckpoints() {
+	config := s.currentConfig()
+	for _, c := range s.jobs.Checkpoints() {
+		if _, found := config.Sources[c.Source]; len(c.Source) > 0 && !found {
+			//keep the checkpoint, so the job resumes on a later start with its source profile configured again
+			s.log.Error("not resuming checkpointed job, its source profile is not configured", plog.Fields{"scan_id": c.ScanID, "source": c.Source})
+			continue
+		}
+		s.log.Info("resuming checkpointed job", plog.Fields{"scan_id": c.ScanID, "request_id": c.RequestID, "remaining": len(c.Remaining)})
+		atomic.AddInt64(&s.activeJobs, 1)
+		s.running.Add(1)
+		go s.processJob(job{
+			ScanID:    c.ScanID,
+			Port:      c.Port,
+			Protocol:  protocolOrDefault(c.Protocol),
+			Technique: techniqueOrDefault(c.Technique),
+			IPs:       c.Remaining,
+			Targets:   c.Targets,
+			RequestID: c.RequestID,
+			Source:    c.Source,
+			Banner:    c.Banner,
+			Detect:    c.Detect,
+			TLS:       c.TLS,
+			HTTP:      c.HTTP,
+			Discover:  c.Discover,
+			ProbeDown: c.ProbeDown,
+			Probe:     c.Probe,
+			Scripts:   c.Scripts,
+			Completed: c.Completed,
+			Schedule:  c.Schedule,
+			Previous:  c.Previous,
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//openPort returns a local port accepting connections, closed when the test ends
+func openPort(t *testing.T) uint {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.ParseUint(port, 10, 32)
+	return uint(p)
+}
+
+func TestServer_EnqueueRefusedAfterStopAccepting(t *testing.T) {
+	s := NewServer(Configuration{})
+	s.stopAccepting()
+	assert.False(t, s.enqueue(job{ScanID: 1, Port: 80, IPs: []string{"127.0.0.1"}}))
+	_, found := s.jobs.Load(1)
+	assert.False(t, found)
+	assert.Equal(t, int64(0), s.activeJobs)
+}
+
+func TestServer_DrainWaitsForRunningJobs(t *testing.T) {
+	port := openPort(t)
+	s := NewServer(Configuration{})
+	go s.processWork()
+	assert.True(t, s.enqueue(job{ScanID: 1, Port: port, IPs: []string{"127.0.0.1"}}))
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(5 * time.Second)
+
+	resp, found := s.jobs.Load(1)
+	assert.True(t, found)
+	assert.True(t, resp.Ready)
+	assert.Equal(t, types.OPEN, resp.Status[0].State)
+	assert.Empty(t, s.jobs.Checkpoints())
+}
+
+func TestServer_DrainCheckpointsUnfinishedJobs(t *testing.T) {
+	s := NewServer(Configuration{Limits: LimitConfiguration{MaxConcurrentProbes: 1}})
+	//hold the only probe slot with a probe of a silent udp port, so the job can not make progress before the drain timeout
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	hold, release := context.WithCancel(context.Background())
+	defer release()
+	started := make(chan struct{})
+	_, err = s.scanner.Scan(hold, []string{"127.0.0.1"}, []uint{uint(silent.LocalAddr().(*net.UDPAddr).Port)}, scanner.Options{
+		Protocol: types.UDP,
+		Timeouts: scanner.Timeouts{Dial: time.Minute},
+		OnProbe: func(ip string, port uint) func(*scanner.Result) {
+			close(started)
+			return func(*scanner.Result) {}
+		},
+	})
+	assert.Nil(t, err)
+	<-started
+	go s.processWork()
+	assert.True(t, s.enqueue(job{ScanID: 1, Port: 80, IPs: []string{"127.0.0.1", "127.0.0.2"}, RequestID: "abc"}))
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(10 * time.Millisecond)
+
+	resp, found := s.jobs.Load(1)
+	assert.True(t, found)
+	assert.False(t, resp.Ready)
+	checkpoints := s.jobs.Checkpoints()
+	assert.Len(t, checkpoints, 1)
+	assert.Equal(t, uint64(1), checkpoints[0].ScanID)
+	assert.Equal(t, "abc", checkpoints[0].RequestID)
+	assert.ElementsMatch(t, []string{"127.0.0.1", "127.0.0.2"}, checkpoints[0].Remaining)
+	assert.Equal(t, int64(0), s.activeJobs)
+}
+
+func TestServer_ResumeCheckpoints(t *testing.T) {
+	port := openPort(t)
+	s := NewServer(Configuration{})
+	s.jobs.SaveCheckpoint(checkpoint{
+		ScanID:    7,
+		Port:      port,
+		Remaining: []string{"127.0.0.1"},
+		Completed: []types.IPStatus{{IP: "127.0.0.2", State: types.CLOSED}},
+	})
+	s.resumeCheckpoints()
+	s.running.Wait()
+
+	resp, found := s.jobs.Load(7)
+	assert.True(t, found)
+	assert.True(t, resp.Ready)
+	assert.Equal(t, port, resp.ScanPort)
+	assert.ElementsMatch(t, []types.IPStatus{{IP: "127.0.0.2", State: types.CLOSED}, {IP: "127.0.0.1", State: types.OPEN}}, resp.Status)
+	assert.Empty(t, s.jobs.Checkpoints())
+}
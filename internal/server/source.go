@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/scanner"
+)
+
+//SourceArgs are the unmodified arguments of one source profile, see CommandLineArgs.Sources
+type SourceArgs struct {
+	Address   string `yaml:"address"`
+	Ports     string `yaml:"ports"`
+	Interface string `yaml:"interface"`
+}
+
+//SourceProfile is a named choice of where outbound probes come from, selected by a ScanRequest
+type SourceProfile struct {
+	Name string
+	//Address is the local ip probes are sent from. Nil leaves the choice to the routing table
+	Address net.IP
+	//Ports is the range of local ports probes are sent from. The zero value uses an ephemeral port
+	Ports PortRange
+	//Interface is the network interface probes are bound to, with SO_BINDTODEVICE
+	Interface string
+}
+
+//sourcePortAttempts bounds how many ports of a source port range are tried before a dial fails
+const sourcePortAttempts = 8
+
+//sourceDialer dials from a SourceProfile
+type sourceDialer struct {
+	profile SourceProfile
+	timeout time.Duration
+}
+
+//dialer returns a dialer that sends probes from this profile. The zero SourceProfile dials as the system would by default
+func (p SourceProfile) dialer(timeout time.Duration) scanner.Dialer {
+	if p.Address == nil && p.Ports == (PortRange{}) && len(p.Interface) == 0 {
+		return &net.Dialer{Timeout: timeout}
+	}
+	return &sourceDialer{profile: p, timeout: timeout}
+}
+
+//reaches returns an error naming the first of ips this profile can not send probes to, as its Address is of the
+//other address family
+func (p SourceProfile) reaches(ips []string) error {
+	if p.Address == nil {
+		return nil
+	}
+	v4 := p.Address.To4() != nil
+	for _, address := range ips {
+		if ip, _ := pnet.ParseIP(address); ip != nil && (ip.To4() != nil) != v4 {
+			return fmt.Errorf("source profile %s address %s can not reach %s, as it is of another address family", p.Name, p.Address, address)
+		}
+	}
+	return nil
+}
+
+//scanSource returns where syn scans and pings of this profile are sent from
+func (p SourceProfile) scanSource() scanner.Source {
+	return scanner.Source{
+		Address:   p.Address,
+		Ports:     scanner.PortRange{From: p.Ports.From, To: p.Ports.To},
+		Interface: p.Interface,
+	}
+}
+
+func (d *sourceDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.profile.Ports == (PortRange{}) {
+		return d.netDialer(network, 0).DialContext(ctx, network, address)
+	}
+	//start at a random port of the range, so concurrent probes rarely contend for the same one
+	size := d.profile.Ports.To - d.profile.Ports.From + 1
+	start := uint(rand.Intn(int(size)))
+	var err error
+	for i := uint(0); i < size && i < sourcePortAttempts; i++ {
+		port := d.profile.Ports.From + (start+i)%size
+		var conn net.Conn
+		if conn, err = d.netDialer(network, port).DialContext(ctx, network, address); err == nil || !isAddrInUse(err) {
+			return conn, err
+		}
+	}
+	return nil, fmt.Errorf("no free source port in %d-%d, last error was: %s", d.profile.Ports.From, d.profile.Ports.To, err.Error())
+}
+
+func (d *sourceDialer) netDialer(network string, port uint) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout: d.timeout,
+		Control: sourceControl(d.profile.Interface, port != 0),
+	}
+	if d.profile.Address != nil || port != 0 {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: d.profile.Address, Port: int(port)}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: d.profile.Address, Port: int(port)}
+		}
+	}
+	return dialer
+}
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+func (c CommandLineArgs) prepareSources() (map[string]SourceProfile, error) {
+	sources := make(map[string]SourceProfile)
+	for name, args := range c.Sources {
+		if !validSourceName(name) {
+			return nil, fmt.Errorf("source profile name %q may only contain letters, digits, - and _", name)
+		}
+		profile := SourceProfile{Name: name, Interface: args.Interface}
+		if len(args.Address) > 0 {
+			if profile.Address = net.ParseIP(args.Address); profile.Address == nil {
+				return nil, fmt.Errorf("source profile %s address %s is not a valid ip address", name, args.Address)
+			}
+		}
+		if len(args.Ports) > 0 {
+			if ports, err := parsePortRanges([]string{args.Ports}); err != nil {
+				return nil, fmt.Errorf("source profile %s ports are not valid: %s", name, err.Error())
+			} else {
+				profile.Ports = ports[0]
+			}
+		}
+		if len(profile.Interface) > 0 {
+			if !bindToDeviceSupported {
+				return nil, fmt.Errorf("source profile %s: interface binding is only supported on linux", name)
+			} else if _, err := net.InterfaceByName(profile.Interface); err != nil {
+				return nil, fmt.Errorf("source profile %s interface %s does not exist", name, profile.Interface)
+			}
+		}
+		if err := checkSource(profile); err != nil {
+			return nil, fmt.Errorf("source profile %s can not be used, error was: %s", name, err.Error())
+		}
+		sources[name] = profile
+	}
+	return sources, nil
+}
+
+func validSourceName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//checkSource binds a socket as profile would, so that an address not on this host,
+//or missing privileges for interface binding, are reported with the configuration rather than as closed ports
+func checkSource(profile SourceProfile) error {
+	config := net.ListenConfig{Control: sourceControl(profile.Interface, false)}
+	address := ""
+	if profile.Address != nil {
+		address = profile.Address.String()
+	}
+	conn, err := config.ListenPacket(context.Background(), "udp", net.JoinHostPort(address, strconv.Itoa(0)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
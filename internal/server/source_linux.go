@@ -0,0 +1,31 @@
+package server
+
+import (
+	"syscall"
+)
+
+const bindToDeviceSupported = true
+
+//sourceControl binds sockets to iface, if given, and allows reuse of a source port still in TIME_WAIT if reuse is true
+func sourceControl(iface string, reuse bool) func(network, address string, c syscall.RawConn) error {
+	if len(iface) == 0 && !reuse {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if reuse {
+				if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+			}
+			if len(iface) > 0 {
+				sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
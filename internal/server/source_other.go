@@ -0,0 +1,21 @@
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const bindToDeviceSupported = false
+
+//sourceControl refuses to bind sockets to an interface, which is only supported on linux
+//Source ports in TIME_WAIT are not reused, the next port of the range is tried instead
+func sourceControl(iface string, reuse bool) func(network, address string, c syscall.RawConn) error {
+	if len(iface) == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is only supported on linux", iface)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/scanner"
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLineArgs_PrepareSources(t *testing.T) {
+	sources, err := CommandLineArgs{Sources: map[string]SourceArgs{
+		"loopback": {Address: "127.0.0.1", Ports: "40000-40099"},
+		"any":      {},
+	}}.prepareSources()
+	assert.Nil(t, err)
+	assert.Equal(t, SourceProfile{Name: "loopback", Address: net.ParseIP("127.0.0.1"), Ports: PortRange{From: 40000, To: 40099}}, sources["loopback"])
+	assert.Equal(t, SourceProfile{Name: "any"}, sources["any"])
+
+	tests := []struct {
+		name string
+		args SourceArgs
+		err  string
+	}{
+		{"bad name!", SourceArgs{}, `source profile name "bad name!" may only contain letters, digits, - and _`},
+		{"vlan20", SourceArgs{Address: "nowhere"}, "source profile vlan20 address nowhere is not a valid ip address"},
+		{"vlan20", SourceArgs{Ports: "40100-40000"}, "source profile vlan20 ports are not valid: "},
+		{"vlan20", SourceArgs{Interface: "does-not-exist0"}, "source profile vlan20 interface does-not-exist0 does not exist"},
+		{"vlan20", SourceArgs{Address: "192.0.2.1"}, "source profile vlan20 can not be used, error was: "},
+	}
+	if !bindToDeviceSupported {
+		tests[3].err = "source profile vlan20: interface binding is only supported on linux"
+	}
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			_, err := CommandLineArgs{Sources: map[string]SourceArgs{tt.name: tt.args}}.prepareSources()
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+//acceptFrom returns the address of the next connection accepted by listener
+func acceptFrom(t *testing.T, listener net.Listener) <-chan net.Addr {
+	from := make(chan net.Addr, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(from)
+			return
+		}
+		from <- conn.RemoteAddr()
+		_ = conn.Close()
+	}()
+	return from
+}
+
+func TestSourceProfile_DialFromPortRange(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	profile := SourceProfile{Name: "loopback", Address: net.ParseIP("127.0.0.1"), Ports: PortRange{From: 40200, To: 40209}}
+	for i := 0; i < 3; i++ {
+		from := acceptFrom(t, listener)
+		conn, err := profile.dialer(time.Second).DialContext(context.Background(), "tcp", listener.Addr().String())
+		if !assert.Nil(t, err) {
+			return
+		}
+		addr := (<-from).(*net.TCPAddr)
+		_ = conn.Close()
+		assert.Equal(t, "127.0.0.1", addr.IP.String())
+		assert.True(t, PortRange{From: 40200, To: 40209}.contains(uint(addr.Port)), "source port %d", addr.Port)
+	}
+}
+
+func TestSourceProfile_DialNoFreePort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	//hold the only port of the range
+	held, err := net.Listen("tcp", "127.0.0.1:40210")
+	if err != nil {
+		t.Skip("source port for test is in use")
+	}
+	defer held.Close()
+
+	profile := SourceProfile{Address: net.ParseIP("127.0.0.1"), Ports: PortRange{From: 40210, To: 40210}}
+	_, err = profile.dialer(time.Second).DialContext(context.Background(), "tcp", listener.Addr().String())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "no free source port in 40210-40210")
+	}
+}
+
+func TestSourceProfile_ScanUDPFromPortRange(t *testing.T) {
+	profile := SourceProfile{Address: net.ParseIP("127.0.0.1"), Ports: PortRange{From: 40300, To: 40309}}
+	results, err := scanner.New(1).Scan(context.Background(), []string{"127.0.0.1"}, []uint{udpEcho(t)}, scanner.Options{
+		Protocol: types.UDP,
+		Dialer:   profile.dialer(time.Second),
+		Source:   profile.scanSource(),
+	})
+	assert.Nil(t, err)
+	r := <-results
+	assert.Equal(t, types.OPEN, r.State)
+}
+
+func TestSourceProfile_BindToDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("interface binding is only supported on linux")
+	}
+	loopback := ""
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+		}
+	}
+	if len(loopback) == 0 {
+		t.Skip("no loopback interface")
+	}
+	if err := checkSource(SourceProfile{Interface: loopback}); err != nil {
+		t.Skipf("can not bind to device without privileges: %s", err.Error())
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	from := acceptFrom(t, listener)
+	conn, err := SourceProfile{Interface: loopback}.dialer(time.Second).DialContext(context.Background(), "tcp", listener.Addr().String())
+	if assert.Nil(t, err) {
+		<-from
+		_ = conn.Close()
+	}
+}
+
+func TestServer_SubmitRequest_SelectsSource(t *testing.T) {
+	port := openPort(t)
+	s := NewServer(Configuration{Sources: map[string]SourceProfile{
+		"loopback": {Name: "loopback", Address: net.ParseIP("127.0.0.1")},
+	}})
+	go s.processWork()
+
+	submit := func(req types.ScanRequest) *httptest.ResponseRecorder {
+		bs, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		s.submitRequest(rec, httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(bs)))
+		return rec
+	}
+
+	rec := submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Source: "vlan20"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "source profile vlan20 is not configured", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1", "::1"}, ScanPort: port, Source: "loopback"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "source profile loopback address 127.0.0.1 can not reach ::1, as it is of another address family", rec.Body.String())
+
+	rec = submit(types.ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: port, Source: "loopback"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp types.ScanResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	s.stopAccepting()
+	close(s.workCh)
+	s.drain(time.Second)
+
+	result, found := s.jobs.Load(resp.ScanID)
+	assert.True(t, found)
+	assert.Equal(t, "loopback", result.Source)
+	assert.Equal(t, []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN}}, result.Status)
+}
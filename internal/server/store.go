@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//jobStore holds the latest QueryResponse for each scan id, checkpoints of jobs interrupted by shutdown, and schedules
+type jobStore interface {
+	Load(scanID uint64) (types.QueryResponse, bool)
+	Store(scanID uint64, resp types.QueryResponse)
+	//Delete forgets the QueryResponse of a scan id, once it is no longer kept
+	Delete(scanID uint64)
+	//SaveCheckpoint records the progress of an unfinished job, so it may be resumed on next start
+	SaveCheckpoint(c checkpoint)
+	//DeleteCheckpoint forgets the checkpoint of a job, once it has completed
+	DeleteCheckpoint(scanID uint64)
+	//Checkpoints returns every saved checkpoint
+	Checkpoints() []checkpoint
+	//LoadSchedule returns the schedule of id, and false if there is none
+	LoadSchedule(id uint64) (types.Schedule, bool)
+	//SaveSchedule records a schedule, replacing any of the same id
+	SaveSchedule(schedule types.Schedule)
+	//DeleteSchedule forgets the schedule of id, returning false if there was none
+	DeleteSchedule(id uint64) bool
+	//Schedules returns every saved schedule
+	Schedules() []types.Schedule
+	//Ping returns an error if the store can not currently serve reads and writes
+	Ping() error
+}
+
+//checkpoint is the progress of a job that was interrupted before every ip was probed
+type checkpoint struct {
+	ScanID    uint64            `json:"id"`
+	Port      uint              `json:"port"`
+	Protocol  string            `json:"protocol,omitempty"`
+	Technique string            `json:"technique,omitempty"`
+	Remaining []string          `json:"remaining"`
+	Targets   map[string]string `json:"targets,omitempty"`
+	Completed []types.IPStatus  `json:"completed"`
+	RequestID string            `json:"request_id,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Banner    string            `json:"banner,omitempty"`
+	Detect    bool              `json:"detect,omitempty"`
+	TLS       bool              `json:"tls,omitempty"`
+	HTTP      bool              `json:"http,omitempty"`
+	Discover  bool              `json:"discover,omitempty"`
+	ProbeDown bool              `json:"probe_down,omitempty"`
+	Probe     string            `json:"probe,omitempty"`
+	Scripts   []string          `json:"scripts,omitempty"`
+	Schedule  uint64            `json:"schedule,omitempty"`
+	Previous  uint64            `json:"previous,omitempty"`
+}
+
+//memoryStore is a jobStore that lives only as long as the process
+type memoryStore struct {
+	jobs        sync.Map
+	checkpoints sync.Map
+	schedules   sync.Map
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Load(scanID uint64) (types.QueryResponse, bool) {
+	if work, found := m.jobs.Load(scanID); found {
+		return work.(types.QueryResponse), true
+	}
+	return types.QueryResponse{}, false
+}
+
+func (m *memoryStore) Store(scanID uint64, resp types.QueryResponse) {
+	m.jobs.Store(scanID, resp)
+}
+
+func (m *memoryStore) Delete(scanID uint64) {
+	m.jobs.Delete(scanID)
+}
+
+func (m *memoryStore) SaveCheckpoint(c checkpoint) {
+	m.checkpoints.Store(c.ScanID, c)
+}
+
+func (m *memoryStore) DeleteCheckpoint(scanID uint64) {
+	m.checkpoints.Delete(scanID)
+}
+
+func (m *memoryStore) Checkpoints() []checkpoint {
+	checkpoints := make([]checkpoint, 0)
+	m.checkpoints.Range(func(key, value interface{}) bool {
+		checkpoints = append(checkpoints, value.(checkpoint))
+		return true
+	})
+	return checkpoints
+}
+
+func (m *memoryStore) LoadSchedule(id uint64) (types.Schedule, bool) {
+	if schedule, found := m.schedules.Load(id); found {
+		return schedule.(types.Schedule), true
+	}
+	return types.Schedule{}, false
+}
+
+func (m *memoryStore) SaveSchedule(schedule types.Schedule) {
+	m.schedules.Store(schedule.ID, schedule)
+}
+
+func (m *memoryStore) DeleteSchedule(id uint64) bool {
+	_, found := m.schedules.Load(id)
+	m.schedules.Delete(id)
+	return found
+}
+
+func (m *memoryStore) Schedules() []types.Schedule {
+	schedules := make([]types.Schedule, 0)
+	m.schedules.Range(func(key, value interface{}) bool {
+		schedules = append(schedules, value.(types.Schedule))
+		return true
+	})
+	return schedules
+}
+
+func (m *memoryStore) Ping() error {
+	return nil
+}
+
+func newJobStore(config StorageConfiguration) (jobStore, error) {
+	switch config.Type {
+	case StorageFile:
+		return newFileStore(config.Path)
+	default:
+		return newMemoryStore(), nil
+	}
+}
+
+//fileStore is a jobStore that keeps every result in a JSON file, so results survive a restart
+//The whole file is rewritten on each change, which suits the modest number of scans a single server holds
+type fileStore struct {
+	path string
+
+	mu       sync.Mutex
+	contents fileStoreContents
+	//err is the most recent failure to write the file, reported by Ping
+	err error
+}
+
+//fileStoreContents is the layout of the job store file
+type fileStoreContents struct {
+	Jobs        map[uint64]types.QueryResponse `json:"jobs"`
+	Checkpoints map[uint64]checkpoint          `json:"checkpoints"`
+	Schedules   map[uint64]types.Schedule      `json:"schedules"`
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	f := &fileStore{
+		path: path,
+		contents: fileStoreContents{
+			Jobs:        map[uint64]types.QueryResponse{},
+			Checkpoints: map[uint64]checkpoint{},
+			Schedules:   map[uint64]types.Schedule{},
+		},
+	}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, f.persist()
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read job store %s, error was: %s", path, err.Error())
+	}
+	if err := json.Unmarshal(bs, &f.contents); err != nil {
+		return nil, fmt.Errorf("job store %s is corrupt, error was: %s", path, err.Error())
+	}
+	if f.contents.Jobs == nil {
+		f.contents.Jobs = map[uint64]types.QueryResponse{}
+	}
+	if f.contents.Checkpoints == nil {
+		f.contents.Checkpoints = map[uint64]checkpoint{}
+	}
+	if f.contents.Schedules == nil {
+		f.contents.Schedules = map[uint64]types.Schedule{}
+	}
+	return f, nil
+}
+
+func (f *fileStore) Load(scanID uint64) (types.QueryResponse, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, found := f.contents.Jobs[scanID]
+	return resp, found
+}
+
+func (f *fileStore) Store(scanID uint64, resp types.QueryResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contents.Jobs[scanID] = resp
+	f.err = f.persist()
+}
+
+func (f *fileStore) Delete(scanID uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.contents.Jobs[scanID]; !found {
+		return
+	}
+	delete(f.contents.Jobs, scanID)
+	f.err = f.persist()
+}
+
+func (f *fileStore) SaveCheckpoint(c checkpoint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contents.Checkpoints[c.ScanID] = c
+	f.err = f.persist()
+}
+
+func (f *fileStore) DeleteCheckpoint(scanID uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.contents.Checkpoints[scanID]; !found {
+		return
+	}
+	delete(f.contents.Checkpoints, scanID)
+	f.err = f.persist()
+}
+
+func (f *fileStore) Checkpoints() []checkpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	checkpoints := make([]checkpoint, 0, len(f.contents.Checkpoints))
+	for _, c := range f.contents.Checkpoints {
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints
+}
+
+func (f *fileStore) LoadSchedule(id uint64) (types.Schedule, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedule, found := f.contents.Schedules[id]
+	return schedule, found
+}
+
+func (f *fileStore) SaveSchedule(schedule types.Schedule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contents.Schedules[schedule.ID] = schedule
+	f.err = f.persist()
+}
+
+func (f *fileStore) DeleteSchedule(id uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.contents.Schedules[id]; !found {
+		return false
+	}
+	delete(f.contents.Schedules, id)
+	f.err = f.persist()
+	return true
+}
+
+func (f *fileStore) Schedules() []types.Schedule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedules := make([]types.Schedule, 0, len(f.contents.Schedules))
+	for _, schedule := range f.contents.Schedules {
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+func (f *fileStore) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+//persist atomically replaces the file with the current contents. f.mu must be held
+func (f *fileStore) persist() error {
+	bs, err := json.Marshal(&f.contents)
+	if err != nil {
+		return fmt.Errorf("bug! could not marshal job store, error was: %s", err.Error())
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		return fmt.Errorf("could not write job store %s, error was: %s", f.path, err.Error())
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("could not write job store %s, error was: %s", f.path, err.Error())
+	}
+	return nil
+}
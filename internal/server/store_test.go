@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := newMemoryStore()
+	_, found := store.Load(1)
+	assert.False(t, found)
+	store.Store(1, types.QueryResponse{Ready: true, ScanPort: 80})
+	resp, found := store.Load(1)
+	assert.True(t, found)
+	assert.Equal(t, uint(80), resp.ScanPort)
+	assert.Nil(t, store.Ping())
+	store.Delete(1)
+	_, found = store.Load(1)
+	assert.False(t, found)
+
+	store.SaveCheckpoint(checkpoint{ScanID: 1, Port: 80, Remaining: []string{"127.0.0.1"}})
+	assert.Len(t, store.Checkpoints(), 1)
+	store.DeleteCheckpoint(1)
+	assert.Empty(t, store.Checkpoints())
+
+	store.SaveSchedule(types.Schedule{ID: 1, Cron: "@daily"})
+	assert.Len(t, store.Schedules(), 1)
+	assert.True(t, store.DeleteSchedule(1))
+	_, found = store.LoadSchedule(1)
+	assert.False(t, found)
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscan-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+
+	store, err := newFileStore(path)
+	assert.Nil(t, err)
+	store.Store(1, types.QueryResponse{Ready: true, ScanPort: 80, Status: []types.IPStatus{{IP: "127.0.0.1", State: types.OPEN}}})
+	assert.Nil(t, store.Ping())
+
+	reopened, err := newFileStore(path)
+	assert.Nil(t, err)
+	resp, found := reopened.Load(1)
+	assert.True(t, found)
+	assert.Equal(t, types.OPEN, resp.Status[0].State)
+}
+
+func TestFileStore_Checkpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscan-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+
+	store, err := newFileStore(path)
+	assert.Nil(t, err)
+	store.SaveCheckpoint(checkpoint{ScanID: 1, Port: 80, Remaining: []string{"127.0.0.1"}})
+	store.SaveCheckpoint(checkpoint{ScanID: 2, Port: 80, Remaining: []string{"127.0.0.2"}})
+	store.DeleteCheckpoint(2)
+
+	reopened, err := newFileStore(path)
+	assert.Nil(t, err)
+	checkpoints := reopened.Checkpoints()
+	assert.Len(t, checkpoints, 1)
+	assert.Equal(t, []string{"127.0.0.1"}, checkpoints[0].Remaining)
+}
+
+func TestFileStore_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscan-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("{"), 0600))
+
+	_, err = newFileStore(path)
+	assert.NotNil(t, err)
+	assert.Nil(t, NewServer(Configuration{Storage: StorageConfiguration{Type: StorageFile, Path: path}}))
+}
+
+func TestFileStore_Schedules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pscan-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jobs.json")
+
+	store, err := newFileStore(path)
+	assert.Nil(t, err)
+	store.SaveSchedule(types.Schedule{ID: 1, Cron: "@daily", Runs: []types.ScheduleRun{{ScanID: 5}}})
+	store.SaveSchedule(types.Schedule{ID: 2, Cron: "@hourly"})
+	assert.True(t, store.DeleteSchedule(2))
+	assert.False(t, store.DeleteSchedule(2))
+
+	reopened, err := newFileStore(path)
+	assert.Nil(t, err)
+	schedules := reopened.Schedules()
+	assert.Len(t, schedules, 1)
+	schedule, found := reopened.LoadSchedule(1)
+	assert.True(t, found)
+	assert.Equal(t, uint64(5), schedule.Runs[0].ScanID)
+}
@@ -0,0 +1,269 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//FileExporter appends finished spans to a local file, one JSON object per line
+type FileExporter struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+//NewFileExporter returns a FileExporter writing to path. The file is created on first export
+func NewFileExporter(path string) *FileExporter {
+	return &FileExporter{path: path}
+}
+
+type fileSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (f *FileExporter) Export(ctx context.Context, spans []SpanData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open trace file, error was: %s", err.Error())
+		}
+		f.file = file
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, span := range spans {
+		out := fileSpan{
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			Start:      span.Start,
+			End:        span.End,
+			DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.Parent != (SpanID{}) {
+			out.ParentID = span.Parent.String()
+		}
+		if err := enc.Encode(&out); err != nil {
+			return fmt.Errorf("could not encode span, error was: %s", err.Error())
+		}
+	}
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write trace file, error was: %s", err.Error())
+	}
+	return nil
+}
+
+func (f *FileExporter) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+//OTLPExporter sends finished spans to an OpenTelemetry collector using OTLP over HTTP with JSON encoding
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+//NewOTLPExporter returns an OTLPExporter posting to endpoint, e.g http://localhost:4318/v1/traces
+//Spans are reported with a service.name resource attribute of service
+func NewOTLPExporter(endpoint, service string, client *http.Client) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   client,
+	}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func otlpSpanKind(kind SpanKind) int {
+	switch kind {
+	case SpanKindServer:
+		return otlpSpanKindServer
+	case SpanKindClient:
+		return otlpSpanKindClient
+	default:
+		return otlpSpanKindInternal
+	}
+}
+
+func (o *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpSpanKind(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = span.Parent.String()
+		}
+		if len(span.Error) > 0 {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		out = append(out, s)
+	}
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: otlpAttributes(map[string]interface{}{"service.name": o.service}),
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "github.com/jbornemann/portscan"},
+						Spans: out,
+					},
+				},
+			},
+		},
+	}
+	bs, err := json.Marshal(&req)
+	if err != nil {
+		return fmt.Errorf("bug! could not marshal spans, error was: %s", err.Error())
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewBuffer(bs))
+	if err != nil {
+		return fmt.Errorf("could not create otlp request, error was: %s", err.Error())
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not export spans, error was: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (o *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, k := range keys {
+		v := attrs[k]
+		var value otlpValue
+		switch t := v.(type) {
+		case string:
+			value.StringValue = &t
+		case bool:
+			value.BoolValue = &t
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			s := fmt.Sprintf("%d", t)
+			value.IntValue = &s
+		case float32:
+			f := float64(t)
+			value.DoubleValue = &f
+		case float64:
+			value.DoubleValue = &t
+		default:
+			s := fmt.Sprintf("%v", t)
+			value.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: k, Value: value})
+	}
+	return out
+}
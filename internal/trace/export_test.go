@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSpan() SpanData {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return SpanData{
+		Name:       "probe.dial",
+		Kind:       SpanKindClient,
+		Context:    NewRootContext(),
+		Parent:     newSpanID(),
+		Start:      start,
+		End:        start.Add(1500 * time.Millisecond),
+		Attributes: map[string]interface{}{"ip": "127.0.0.1", "port": uint(80)},
+		Error:      "refused",
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.jsonl")
+
+	exporter := NewFileExporter(path)
+	span := testSpan()
+	assert.Nil(t, exporter.Export(context.Background(), []SpanData{span, span}))
+	assert.Nil(t, exporter.Shutdown(context.Background()))
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var got fileSpan
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &got))
+		assert.Equal(t, span.Context.TraceID.String(), got.TraceID)
+		assert.Equal(t, span.Parent.String(), got.ParentID)
+		assert.Equal(t, float64(1500), got.DurationMS)
+		assert.Equal(t, "refused", got.Error)
+		assert.Equal(t, "client", got.Kind)
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(bs, &got) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	span := testSpan()
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "pscan", server.Client())
+	assert.Nil(t, exporter.Export(context.Background(), []SpanData{span}))
+
+	assert.Len(t, got.ResourceSpans, 1)
+	assert.Equal(t, "service.name", got.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "pscan", *got.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Len(t, spans, 1)
+	assert.Equal(t, span.Context.TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, span.Context.SpanID.String(), spans[0].SpanID)
+	assert.Equal(t, span.Parent.String(), spans[0].ParentSpanID)
+	assert.Equal(t, fmt.Sprintf("%d", span.Start.UnixNano()), spans[0].StartTimeUnixNano)
+	assert.Equal(t, otlpSpanKindClient, spans[0].Kind)
+	assert.Equal(t, otlpStatusError, spans[0].Status.Code)
+	assert.Equal(t, "ip", spans[0].Attributes[0].Key)
+	assert.Equal(t, "port", spans[0].Attributes[1].Key)
+	assert.Equal(t, "80", *spans[0].Attributes[1].Value.IntValue)
+}
+
+func TestOTLPExporter_ReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL, "pscan", server.Client())
+	err := exporter.Export(context.Background(), []SpanData{testSpan()})
+	assert.EqualError(t, err, "otlp endpoint returned status 503")
+}
@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//TraceID identifies a whole trace, shared by every span within it
+type TraceID [16]byte
+
+//SpanID identifies a single span within a trace
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+//SpanContext is the portion of a span that is propagated across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+//IsValid returns true if both the trace and span ids are set
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+//Traceparent formats the SpanContext as a W3C traceparent header value
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, c.SpanID, flags)
+}
+
+//ParseTraceparent parses a W3C traceparent header value, e.g 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+//ParseTraceparent will return an error if value is not a well-formed traceparent
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("%s is not a valid traceparent", value)
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, nil
+}
+
+//NewRootContext returns a sampled SpanContext beginning a new trace
+func NewRootContext() SpanContext {
+	return SpanContext{
+		TraceID: newTraceID(),
+		SpanID:  newSpanID(),
+		Sampled: true,
+	}
+}
+
+//NewChildContext returns a SpanContext for a new span within the trace of parent, sampled as parent is
+func NewChildContext(parent SpanContext) SpanContext {
+	return SpanContext{
+		TraceID: parent.TraceID,
+		SpanID:  newSpanID(),
+		Sampled: parent.Sampled,
+	}
+}
+
+const traceparentHeader = "traceparent"
+
+//Inject sets the traceparent header for sc on h, if sc is valid
+func Inject(sc SpanContext, h http.Header) {
+	if sc.IsValid() {
+		h.Set(traceparentHeader, sc.Traceparent())
+	}
+}
+
+//Extract returns the SpanContext carried by the traceparent header of h
+//Extract returns false if there is no header, or it is malformed
+func Extract(h http.Header) (SpanContext, bool) {
+	value := h.Get(traceparentHeader)
+	if len(value) == 0 {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(value)
+	return sc, err == nil
+}
+
+//SpanKind is the role of a span in a call between services
+type SpanKind int
+
+const (
+	//SpanKindInternal is the kind of a span not part of a call between services, and of spans not given a kind
+	SpanKindInternal SpanKind = iota
+	//SpanKindServer is the kind of a span handling a call from a client
+	SpanKindServer
+	//SpanKindClient is the kind of a span making a call to a server
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+//SpanData is a finished span, as handed to an Exporter
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+}
+
+//Span is an in-progress unit of work. A nil *Span is valid, and does nothing
+type Span struct {
+	tracer *Tracer
+
+	mu   sync.Mutex
+	data SpanData
+	done bool
+}
+
+//Context returns the SpanContext of this span, for propagation
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+//SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+//SetKind records the role of the span in a call between services
+func (s *Span) SetKind(kind SpanKind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Kind = kind
+}
+
+//SetError marks the span as failed with err. A nil err is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+//End finishes the span and hands it to the Tracer for export. Calls after the first are ignored
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+	if data.Context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+//ContextWithSpan returns a copy of ctx carrying span as the current span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//SpanFromContext returns the current span of ctx, or nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+//ContextWithRemote returns a copy of ctx whose next span will be a child of the remote SpanContext sc
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func parentOf(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context(), true
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok && sc.IsValid() {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
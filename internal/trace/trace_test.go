@@ -0,0 +1,135 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Nil(t, err)
+	assert.True(t, sc.IsValid())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	sc, err = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.Nil(t, err)
+	assert.False(t, sc.Sampled)
+}
+
+func TestParseTraceparent_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"junk",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceparent(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestNewChildContext(t *testing.T) {
+	parent := NewRootContext()
+	parent.Sampled = false
+	child := NewChildContext(parent)
+	assert.Equal(t, parent.TraceID, child.TraceID)
+	assert.NotEqual(t, parent.SpanID, child.SpanID)
+	assert.False(t, child.Sampled)
+	assert.True(t, child.IsValid())
+}
+
+func TestInjectExtract(t *testing.T) {
+	h := http.Header{}
+	Inject(SpanContext{}, h)
+	_, ok := Extract(h)
+	assert.False(t, ok)
+
+	sc := NewRootContext()
+	Inject(sc, h)
+	got, ok := Extract(h)
+	assert.True(t, ok)
+	assert.Equal(t, sc, got)
+}
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (m *memoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func (m *memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestTracer_ChildSpans(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, nil)
+
+	remote := NewRootContext()
+	ctx, parent := tracer.Start(ContextWithRemote(context.Background(), remote), "parent")
+	parent.SetKind(SpanKindServer)
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("port", 80)
+	child.End()
+	child.End()
+	parent.End()
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+
+	assert.Len(t, exporter.spans, 2)
+	c, p := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, "child", c.Name)
+	assert.Equal(t, 80, c.Attributes["port"])
+	assert.Equal(t, SpanKindInternal, c.Kind)
+	assert.Equal(t, SpanKindServer, p.Kind)
+	assert.Equal(t, remote.TraceID, p.Context.TraceID)
+	assert.Equal(t, remote.SpanID, p.Parent)
+	assert.Equal(t, p.Context.TraceID, c.Context.TraceID)
+	assert.Equal(t, p.Context.SpanID, c.Parent)
+	assert.False(t, c.End.Before(c.Start))
+}
+
+func TestTracer_UnsampledSpansAreNotExported(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, nil)
+	remote := NewRootContext()
+	remote.Sampled = false
+	_, span := tracer.Start(ContextWithRemote(context.Background(), remote), "span")
+	span.End()
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, exporter.spans, 0)
+}
+
+func TestTracer_WithoutExporter(t *testing.T) {
+	tracer := NewTracer(nil, nil)
+	ctx, span := tracer.Start(context.Background(), "span")
+	assert.True(t, span.Context().IsValid())
+	assert.Equal(t, span, SpanFromContext(ctx))
+	span.End()
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+}
+
+func TestSpan_NilIsSafe(t *testing.T) {
+	var span *Span
+	span.SetAttribute("k", "v")
+	span.SetKind(SpanKindClient)
+	span.SetError(nil)
+	span.End()
+	assert.False(t, span.Context().IsValid())
+}
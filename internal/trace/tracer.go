@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 128
+	batchInterval = 2 * time.Second
+	queueSize     = 2048
+)
+
+//Exporter delivers finished spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+//Tracer starts spans and exports them in batches in the background
+//A Tracer without an Exporter still creates spans, so trace context is propagated, but exports nothing
+type Tracer struct {
+	exporter Exporter
+	onError  func(error)
+	now      func() time.Time
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SpanData
+	done   chan struct{}
+}
+
+//NewTracer returns a Tracer exporting through exporter. exporter may be nil
+//onError is called with export failures, and may be nil
+func NewTracer(exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+	if exporter != nil {
+		t.queue = make(chan SpanData, queueSize)
+		go t.run()
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+//Start begins a span named name, as a child of the current or remote span of ctx if any
+//The returned context carries the new span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	sc := SpanContext{
+		SpanID:  newSpanID(),
+		Sampled: true,
+	}
+	var parent SpanID
+	if p, ok := parentOf(ctx); ok {
+		sc.TraceID = p.TraceID
+		sc.Sampled = p.Sampled
+		parent = p.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Context:    sc,
+			Parent:     parent,
+			Start:      t.now(),
+			Attributes: map[string]interface{}{},
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+//Shutdown exports any buffered spans and shuts down the Exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	//spans ending after shutdown are dropped
+	if t.queue == nil || t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		//never block the caller on a slow backend, drop the span instead
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//connInspector examines the connection getState made to an open port, recording what it learns on status
+type connInspector func(ctx context.Context, conn net.Conn, status *types.IPStatus)
+
+//bannerNudges are sent to services that wait for the client to speak first, in active banner mode
+var bannerNudges = map[uint]string{
+	80:   httpNudge,
+	3000: httpNudge,
+	5000: httpNudge,
+	8000: httpNudge,
+	8008: httpNudge,
+	8080: httpNudge,
+	8888: httpNudge,
+	9200: httpNudge,
+}
+
+const (
+	httpNudge = "HEAD / HTTP/1.0\r\n\r\n"
+	//genericNudge provokes a greeting or an error from most line based protocols
+	genericNudge = "\r\n\r\n"
+	//bannerIdle is how long to wait for more of a banner, once some has been read
+	bannerIdle = 200 * time.Millisecond
+)
+
+//tlsPorts speak tls first, so a plaintext nudge only earns an alert
+var tlsPorts = map[uint]bool{443: true, 465: true, 636: true, 853: true, 993: true, 995: true, 8443: true}
+
+//bannerGrabber reads what a service sends on connect
+type bannerGrabber struct {
+	port     uint
+	mode     string
+	maxBytes uint
+	timeout  time.Duration
+}
+
+//inspect records the banner read from conn on status
+func (b bannerGrabber) inspect(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+	banner, _ := b.read(ctx, conn)
+	status.Banner = sanitizeBanner(banner)
+}
+
+//read reads up to maxBytes within timeout. In active mode, a service that has said nothing by half of timeout
+//is sent a nudge appropriate to its port, and given the rest of timeout to reply. read returns true if it nudged
+func (b bannerGrabber) read(ctx context.Context, conn net.Conn) ([]byte, bool) {
+	deadline := time.Now().Add(b.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	buf := make([]byte, b.maxBytes)
+	read := 0
+	readUntil := func(until time.Time) {
+		for read < len(buf) {
+			//once a service has spoken, wait only briefly for the rest of what it has to say
+			if idle := time.Now().Add(bannerIdle); read > 0 && idle.Before(until) {
+				until = idle
+			}
+			_ = conn.SetReadDeadline(until)
+			n, err := conn.Read(buf[read:])
+			read += n
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	nudged := false
+	if b.mode == types.BannerActive {
+		readUntil(time.Now().Add(time.Until(deadline) / 2))
+		if read == 0 && !tlsPorts[b.port] {
+			nudge, found := bannerNudges[b.port]
+			if !found {
+				nudge = genericNudge
+			}
+			_ = conn.SetWriteDeadline(deadline)
+			_, _ = conn.Write([]byte(nudge))
+			nudged = true
+		}
+	}
+	readUntil(deadline)
+	return buf[:read], nudged
+}
+
+//sanitizeBanner renders bytes received from a service as a single line of printable ascii
+//Tabs and line endings are escaped as \t, \r and \n, other bytes outside printable ascii as \xNN
+func sanitizeBanner(bs []byte) string {
+	bs = bytes.TrimRight(bs, "\r\n\t \x00")
+	var sb strings.Builder
+	for _, b := range bs {
+		switch {
+		case b == '\\':
+			sb.WriteString(`\\`)
+		case b == '\r':
+			sb.WriteString(`\r`)
+		case b == '\n':
+			sb.WriteString(`\n`)
+		case b == '\t':
+			sb.WriteString(`\t`)
+		case b >= 0x20 && b < 0x7f:
+			sb.WriteByte(b)
+		default:
+			sb.WriteString(fmt.Sprintf(`\x%02x`, b))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,82 @@
+package scanner
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//tcpService accepts connections on a local port and hands each to serve
+func tcpService(t *testing.T, serve func(net.Conn)) uint {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return uint(l.Addr().(*net.TCPAddr).Port)
+}
+
+func grab(t *testing.T, port uint, b bannerGrabber) types.IPStatus {
+	b.port = port
+	status, ok := connect(&net.Dialer{Timeout: time.Second}, "127.0.0.1", port, b.inspect)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN, status.State)
+	return status
+}
+
+func TestBannerGrabber_Passive(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1\r\n"))
+		time.Sleep(time.Second)
+	})
+	start := time.Now()
+	status := grab(t, port, bannerGrabber{mode: types.BannerPassive, maxBytes: 256, timeout: 5 * time.Second})
+	assert.Equal(t, "SSH-2.0-OpenSSH_8.9p1", status.Banner)
+	//the grab ends shortly after the service stops sending, not at the timeout
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+func TestBannerGrabber_Truncates(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("220 mail.example.com ESMTP ready\r\n"))
+	})
+	status := grab(t, port, bannerGrabber{mode: types.BannerPassive, maxBytes: 8, timeout: time.Second})
+	assert.Equal(t, "220 mail", status.Banner)
+}
+
+func TestBannerGrabber_ActiveNudges(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+			_, _ = conn.Write([]byte("HTTP/1.0 400 Bad Request\r\nServer: test\r\n\r\n"))
+		}
+	})
+	status := grab(t, port, bannerGrabber{mode: types.BannerPassive, maxBytes: 256, timeout: 200 * time.Millisecond})
+	assert.Equal(t, "", status.Banner)
+
+	status = grab(t, port, bannerGrabber{mode: types.BannerActive, maxBytes: 256, timeout: 2 * time.Second})
+	assert.Equal(t, `HTTP/1.0 400 Bad Request\r\nServer: test`, status.Banner)
+}
+
+func TestSanitizeBanner(t *testing.T) {
+	assert.Equal(t, `220 ready\r\n250 ok`, sanitizeBanner([]byte("220 ready\r\n250 ok\r\n\r\n")))
+	assert.Equal(t, `\x1b[31mred\x00\tC:\\`, sanitizeBanner([]byte("\x1b[31mred\x00\tC:\\")))
+	assert.Equal(t, `caf\xc3\xa9`, sanitizeBanner([]byte("café")))
+}
@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/service"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//detectProbes bounds the probes sent to a port, on connections of their own, after its greeting matched nothing
+	detectProbes = 4
+	//detectBytes is the most read of a greeting or a response to a probe
+	detectBytes = 4096
+)
+
+//serviceDetector identifies the service on an open port from its greeting, then if need be from its responses
+//to probes of the database sent on new connections, dialed with dialer
+type serviceDetector struct {
+	port    uint
+	dialer  Dialer
+	probes  *service.Database
+	timeout time.Duration
+	//banner is the banner mode requested, if any. The greeting read for detection is also recorded as the banner
+	banner         string
+	maxBannerBytes uint
+}
+
+func (d serviceDetector) inspect(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+	mode := d.banner
+	if len(mode) == 0 {
+		mode = types.BannerPassive
+	}
+	greeting, nudged := bannerGrabber{port: d.port, mode: mode, maxBytes: detectBytes, timeout: d.timeout}.read(ctx, conn)
+	if len(d.banner) > 0 {
+		banner := greeting
+		if uint(len(banner)) > d.maxBannerBytes {
+			banner = banner[:d.maxBannerBytes]
+		}
+		status.Banner = sanitizeBanner(banner)
+	}
+	if nudged {
+		//a reply to a nudge is not what the service says on connect, so must not be matched as a greeting
+		greeting = nil
+	}
+
+	address := conn.RemoteAddr().String()
+	exchange := func(payload []byte) ([]byte, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		con, err := d.dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		defer con.Close()
+		_ = con.SetWriteDeadline(time.Now().Add(d.timeout))
+		if _, err := con.Write(payload); err != nil {
+			return nil, err
+		}
+		response, _ := bannerGrabber{port: d.port, mode: types.BannerPassive, maxBytes: detectBytes, timeout: d.timeout}.read(ctx, con)
+		return response, nil
+	}
+	if result, found := d.probes.Detect(d.port, greeting, exchange, detectProbes); found {
+		status.Service = &types.Service{Name: result.Service, Product: result.Product, Version: result.Version, Info: result.Info}
+	}
+}
@@ -0,0 +1,53 @@
+package scanner
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/service"
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func detect(t *testing.T, port uint, d serviceDetector) types.IPStatus {
+	d.port = port
+	d.dialer = &net.Dialer{Timeout: time.Second}
+	if d.probes == nil {
+		d.probes = service.Default()
+	}
+	status, ok := connect(d.dialer, "127.0.0.1", port, d.inspect)
+	assert.True(t, ok)
+	return status
+}
+
+func TestServiceDetector_Greeting(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n"))
+		time.Sleep(time.Second)
+	})
+	status := detect(t, port, serviceDetector{timeout: 2 * time.Second, banner: types.BannerPassive, maxBannerBytes: 7})
+	assert.Equal(t, &types.Service{Name: "ssh", Product: "OpenSSH", Version: "8.9p1", Info: "protocol 2.0"}, status.Service)
+	assert.Equal(t, "SSH-2.0", status.Banner)
+}
+
+func TestServiceDetector_Probes(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		//silent until sent a request, as http servers are
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nServer: gunicorn\r\n\r\n"))
+		}
+	})
+	status := detect(t, port, serviceDetector{timeout: 300 * time.Millisecond})
+	assert.Equal(t, &types.Service{Name: "http", Product: "gunicorn"}, status.Service)
+	assert.Empty(t, status.Banner)
+}
+
+func TestServiceDetector_Unknown(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("\x00\x01 who knows\r\n"))
+	})
+	status := detect(t, port, serviceDetector{timeout: 300 * time.Millisecond})
+	assert.Nil(t, status.Service)
+}
@@ -0,0 +1,299 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//pingAttempts is how many times an icmp echo is sent, as either it or its reply may be lost
+	pingAttempts = 2
+	//arpPollInterval is how often the neighbour table is read while waiting for an address to resolve
+	arpPollInterval = 100 * time.Millisecond
+	//arpComplete is the flag of a resolved entry of the neighbour table
+	arpComplete = 0x2
+
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+var (
+	//synPingPorts and ackPingPorts are pinged with raw sockets, the first being likely open, the second likely
+	//to be let through by firewalls that track connections
+	synPingPorts = []uint{443, 22}
+	ackPingPorts = []uint{80}
+	//connectPingPorts are pinged without raw sockets
+	connectPingPorts = []uint{80, 443, 22}
+	//arpTable is the system's ipv4 neighbour table, only found on linux
+	arpTable = "/proc/net/arp"
+)
+
+//hostDiscoverer finds whether a host is up, by whichever of an icmp echo, tcp pings and, on a local subnet, arp is
+//answered first. Hosts that answer none within timeout are down
+type hostDiscoverer struct {
+	dialer Dialer
+	source Source
+	//syn sends tcp pings as SYNs and ACKs. Nil connects instead
+	syn     *synScanner
+	timeout time.Duration
+}
+
+//hostPing pings a host, returning what it answered, and false if it did not answer before ctx was done
+type hostPing func(ctx context.Context) (reason string, up bool)
+
+//discover records in status whether the host at status.IP is up, and false if ctx was cancelled before it was known
+func (d hostDiscoverer) discover(ctx context.Context, status *types.IPStatus) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	ip, zone := pnet.ParseIP(status.IP)
+	if ip == nil {
+		return true
+	}
+	pings := []hostPing{d.echo(ip, zone)}
+	if v4 := ip.To4(); v4 != nil && d.syn != nil {
+		for _, port := range synPingPorts {
+			pings = append(pings, d.synPing(v4, port, false))
+		}
+		for _, port := range ackPingPorts {
+			pings = append(pings, d.synPing(v4, port, true))
+		}
+	} else {
+		for _, port := range connectPingPorts {
+			pings = append(pings, d.connectPing(status.IP, port))
+		}
+	}
+	local := onLink(ip)
+	if local {
+		//the pings above have the system resolve the address, which is all an arp ping needs
+		pings = append(pings, arpPing(ip))
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	answers := make(chan string, len(pings))
+	wg := &sync.WaitGroup{}
+	for _, ping := range pings {
+		wg.Add(1)
+		go func(ping hostPing) {
+			defer wg.Done()
+			if reason, up := ping(pingCtx); up {
+				answers <- reason
+			}
+		}(ping)
+	}
+	go func() {
+		wg.Wait()
+		close(answers)
+	}()
+	reason, up := <-answers
+	cancel()
+
+	if !up && ctx.Err() != nil {
+		return false
+	} else if !up {
+		status.Host = types.HOST_DOWN
+		return true
+	}
+	status.Host, status.HostReason = types.HOST_UP, reason
+	if local {
+		status.MAC, _ = arpLookup(ip)
+	}
+	return true
+}
+
+//synPing pings port of ip with a SYN, or if ack is set an ACK
+func (d hostDiscoverer) synPing(ip net.IP, port uint, ack bool) hostPing {
+	reason := "tcp syn " + strconv.FormatUint(uint64(port), 10)
+	if ack {
+		reason = "tcp ack " + strconv.FormatUint(uint64(port), 10)
+	}
+	return func(ctx context.Context) (string, bool) {
+		deadline, _ := ctx.Deadline()
+		return reason, d.syn.ping(ctx, d.source, ip, port, time.Until(deadline), ack)
+	}
+}
+
+//connectPing pings port of ip by connecting to it. A refused connection is as much an answer as an accepted one
+func (d hostDiscoverer) connectPing(ip string, port uint) hostPing {
+	reason := "tcp connect " + strconv.FormatUint(uint64(port), 10)
+	return func(ctx context.Context) (string, bool) {
+		con, err := d.dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.FormatUint(uint64(port), 10)))
+		if err == nil {
+			_ = con.Close()
+			return reason, true
+		}
+		return reason, errors.Is(err, syscall.ECONNREFUSED)
+	}
+}
+
+//echo pings ip with an icmp echo, from an unprivileged ping socket where the system allows, or a raw socket
+func (d hostDiscoverer) echo(ip net.IP, zone string) hostPing {
+	return func(ctx context.Context) (string, bool) {
+		v4 := ip.To4() != nil
+		var local net.IP
+		if d.source.Address != nil && (d.source.Address.To4() != nil) == v4 {
+			local = d.source.Address
+		}
+		conn, err := listenICMP(v4, local)
+		if err != nil {
+			return "", false
+		}
+		defer conn.Close()
+		//unblock the read below if ctx is done
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = conn.SetReadDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+
+		request, reply := byte(icmpEchoRequest), byte(icmpEchoReply)
+		if !v4 {
+			request, reply = icmpv6EchoRequest, icmpv6EchoReply
+		}
+		token := make([]byte, 8)
+		_, _ = rand.Read(token)
+		message := echoMessage(request, token)
+		var dst net.Addr = &net.IPAddr{IP: ip, Zone: zone}
+		if _, ok := conn.(*net.UDPConn); ok {
+			dst = &net.UDPAddr{IP: ip, Zone: zone}
+		}
+
+		deadline, _ := ctx.Deadline()
+		buf := make([]byte, 1500)
+		for attempt := 0; attempt < pingAttempts && ctx.Err() == nil; attempt++ {
+			if _, err := conn.WriteTo(message, dst); err != nil {
+				return "", false
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Until(deadline) / time.Duration(pingAttempts-attempt)))
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					break
+				}
+				//a raw socket sees every echo reply, so a reply is known by the token it echoes
+				if n >= 8+len(token) && buf[0] == reply && bytes.Equal(buf[8:8+len(token)], token) {
+					return "icmp echo", true
+				}
+			}
+		}
+		return "", false
+	}
+}
+
+//listenICMP returns a socket icmp echoes can be sent and received on, from local if given
+func listenICMP(v4 bool, local net.IP) (net.PacketConn, error) {
+	if conn, err := listenPing(v4, local); err == nil {
+		return conn, nil
+	}
+	network := "ip4:icmp"
+	if !v4 {
+		network = "ip6:ipv6-icmp"
+	}
+	address := ""
+	if local != nil {
+		address = local.String()
+	}
+	return net.ListenPacket(network, address)
+}
+
+//echoMessage returns an icmp echo request of type request carrying data. The identifier is left to the ping socket,
+//or is zero on a raw socket, as replies are matched by data
+func echoMessage(request byte, data []byte) []byte {
+	message := make([]byte, 8+len(data))
+	message[0] = request
+	binary.BigEndian.PutUint16(message[6:8], 1)
+	copy(message[8:], data)
+	//the system computes the checksum of icmpv6, over a pseudo header it alone knows the source of
+	if request == icmpEchoRequest {
+		binary.BigEndian.PutUint16(message[2:4], checksum(message))
+	}
+	return message
+}
+
+//arpPing waits for the system to resolve ip, which a host on a local subnet does by answering arp
+func arpPing(ip net.IP) hostPing {
+	return func(ctx context.Context) (string, bool) {
+		ticker := time.NewTicker(arpPollInterval)
+		defer ticker.Stop()
+		for {
+			if _, found := arpLookup(ip); found {
+				return "arp", true
+			}
+			select {
+			case <-ctx.Done():
+				return "", false
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+//onLink returns true if ip is an ipv4 address on a subnet of one of this host's interfaces, so reached by arp
+func onLink(ip net.IP) bool {
+	if ip.To4() == nil || ip.IsLoopback() {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//arpLookup returns the hardware address the neighbour table holds for ip, and false if it holds none
+func arpLookup(ip net.IP) (string, bool) {
+	f, err := os.Open(arpTable)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	mac, found := parseARPTable(f)[ip.String()]
+	return mac, found
+}
+
+//parseARPTable returns the hardware address of each resolved entry of a table in the format of /proc/net/arp
+func parseARPTable(r io.Reader) map[string]string {
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	//the first line names the columns
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil || flags&arpComplete == 0 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		entries[fields[0]] = fields[3]
+	}
+	return entries
+}
@@ -0,0 +1,35 @@
+package scanner
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+//listenPing opens an unprivileged ping socket, from local if given. The system fills in the identifier of echoes
+//sent, and passes the socket only the replies to them. Which groups may open one is set by net.ipv4.ping_group_range
+func listenPing(v4 bool, local net.IP) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr
+	if v4 {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], local.To4())
+		sa = sa4
+	} else {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], local.To16())
+		sa = sa6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "ping")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package scanner
+
+import (
+	"fmt"
+	"net"
+)
+
+//listenPing refuses to open a ping socket, which is only used on linux. Echoes are sent on a raw socket instead
+func listenPing(v4 bool, local net.IP) (net.PacketConn, error) {
+	return nil, fmt.Errorf("unprivileged ping sockets are only used on linux")
+}
@@ -0,0 +1,47 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseARPTable(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:01     *        eth0
+192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.9      0x1         0x6         aa:bb:cc:dd:ee:09     *        eth0
+`
+	assert.Equal(t, map[string]string{"192.168.1.1": "aa:bb:cc:dd:ee:01", "192.168.1.9": "aa:bb:cc:dd:ee:09"}, parseARPTable(strings.NewReader(table)))
+}
+
+func TestEchoMessage(t *testing.T) {
+	message := echoMessage(icmpEchoRequest, []byte("pscan"))
+	assert.Equal(t, byte(icmpEchoRequest), message[0])
+	assert.Equal(t, []byte("pscan"), message[8:])
+	//a message carrying its checksum sums to zero
+	assert.Equal(t, uint16(0), checksum(message))
+}
+
+func TestHostDiscoverer_Discover(t *testing.T) {
+	discoverer := hostDiscoverer{dialer: &net.Dialer{}, timeout: 500 * time.Millisecond}
+
+	status := types.IPStatus{IP: "127.0.0.1"}
+	assert.True(t, discoverer.discover(context.Background(), &status))
+	assert.Equal(t, types.HOST_UP, status.Host)
+	assert.NotEmpty(t, status.HostReason)
+
+	//100::/64 is reserved for discarding traffic, so nothing answers
+	status = types.IPStatus{IP: "100::1"}
+	assert.True(t, discoverer.discover(context.Background(), &status))
+	assert.Equal(t, types.IPStatus{IP: "100::1", Host: types.HOST_DOWN}, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, discoverer.discover(ctx, &types.IPStatus{IP: "100::1"}))
+}
@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//maxRedirects bounds the redirects followed from /, and the chain recorded
+	maxRedirects = 5
+	//titleBytes is the most of a body searched for its title
+	titleBytes = 64 * 1024
+	//maxTextLength bounds the runes kept of a title or header value
+	maxTextLength = 256
+	userAgent     = "pscan"
+)
+
+//securityHeaders are recorded from the final response when present
+var securityHeaders = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Frame-Options",
+	"X-Content-Type-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+//httpFingerprinter requests / from an open port over https, or failing that http, and follows redirects that stay
+//on the port. Every request is sent to the port probed, dialed with dialer, whatever host its url names
+type httpFingerprinter struct {
+	port    uint
+	dialer  Dialer
+	timeout time.Duration
+}
+
+func (h httpFingerprinter) inspect(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	host, serverName := status.IP, ""
+	if ip, _ := pnet.ParseIP(status.IP); ip != nil {
+		//a zone is meaningless to the server, and the address is dialed directly
+		host = ip.String()
+	}
+	//a target that is a hostname is sent as the host and server name
+	if pnet.ValidHostname(status.Target) {
+		host, serverName = status.Target, status.Target
+	}
+	schemes := []string{"https", "http"}
+	if status.TLS != nil {
+		schemes = schemes[:1]
+	}
+	address := conn.RemoteAddr().String()
+	for _, scheme := range schemes {
+		if info, ok := h.fetch(ctx, scheme, host, serverName, address, conn); ok {
+			status.HTTP = info
+			return
+		}
+		//the connection getState made is spent on the first attempt
+		conn = nil
+	}
+}
+
+//fetch requests / from scheme://host:port, the first request using conn if given, and follows redirects
+//fetch returns false if no response was received
+func (h httpFingerprinter) fetch(ctx context.Context, scheme, host, serverName, address string, conn net.Conn) (*types.HTTPInfo, bool) {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			if conn != nil {
+				c := conn
+				conn = nil
+				return c, nil
+			}
+			return h.dialer.DialContext(ctx, "tcp", address)
+		},
+		//as with tls inspection, untrusted certificates are expected and reported rather than refused
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: serverName},
+		DisableKeepAlives: true,
+		//leave time for plain http, should a port that is not https sit on the handshake
+		TLSHandshakeTimeout: h.timeout / 2,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	port := strconv.FormatUint(uint64(h.port), 10)
+	next := &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: "/"}
+	var info *types.HTTPInfo
+	redirects := make([]string, 0)
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next.String(), nil)
+		if err != nil {
+			break
+		}
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			break
+		}
+		info = describeResponse(resp)
+		_ = resp.Body.Close()
+
+		location, err := resp.Location()
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || err != nil {
+			break
+		}
+		redirects = append(redirects, location.String())
+		if len(redirects) > maxRedirects || !sameOrigin(location, host, port) {
+			break
+		}
+		next = location
+	}
+	if info != nil && len(redirects) > 0 {
+		info.Redirects = redirects
+	}
+	return info, info != nil
+}
+
+//sameOrigin returns true if u is http or https on host and port, so following it probes nothing new
+func sameOrigin(u *url.URL, host, port string) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	p := u.Port()
+	if len(p) == 0 && u.Scheme == "http" {
+		p = "80"
+	} else if len(p) == 0 {
+		p = "443"
+	}
+	return strings.EqualFold(u.Hostname(), host) && p == port
+}
+
+func describeResponse(resp *http.Response) *types.HTTPInfo {
+	info := &types.HTTPInfo{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Server:     cleanText(resp.Header.Get("Server")),
+	}
+	for _, name := range securityHeaders {
+		if value := resp.Header.Get(name); len(value) > 0 {
+			if info.SecurityHeaders == nil {
+				info.SecurityHeaders = make(map[string]string)
+			}
+			info.SecurityHeaders[name] = cleanText(value)
+		}
+	}
+	if body, err := ioutil.ReadAll(io.LimitReader(resp.Body, titleBytes)); err == nil || len(body) > 0 {
+		if title := titlePattern.FindSubmatch(body); title != nil {
+			info.Title = cleanText(html.UnescapeString(string(title[1])))
+		}
+	}
+	return info
+}
+
+//cleanText returns s as valid utf-8 without control characters, with runs of whitespace collapsed to a single space,
+//truncated to maxTextLength runes
+func cleanText(s string) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		if !unicode.IsControl(r) {
+			runes = append(runes, r)
+		}
+	}
+	if len(runes) > maxTextLength {
+		runes = runes[:maxTextLength]
+	}
+	return string(runes)
+}
@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func website() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test/1.0")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		_, _ = w.Write([]byte("<html><head><TITLE>\n  Caf\xc3\xa9 &amp; Bar\x07 </TITLE></head></html>"))
+	})
+	return mux
+}
+
+func fingerprint(t *testing.T, serverURL string, target string) types.IPStatus {
+	u, _ := url.Parse(serverURL)
+	port, _ := strconv.ParseUint(u.Port(), 10, 16)
+	dialer := &net.Dialer{Timeout: time.Second}
+	h := httpFingerprinter{port: uint(port), dialer: dialer, timeout: 2 * time.Second}
+	r := Result{IPStatus: types.IPStatus{IP: "127.0.0.1", Target: target}}
+	assert.True(t, getState(context.Background(), dialer, uint(port), h.inspect, &r))
+	return r.IPStatus
+}
+
+func TestHTTPFingerprinter(t *testing.T) {
+	s := httptest.NewServer(website())
+	defer s.Close()
+	status := fingerprint(t, s.URL, "")
+	if assert.NotNil(t, status.HTTP) {
+		assert.Equal(t, s.URL+"/home", status.HTTP.URL)
+		assert.Equal(t, http.StatusOK, status.HTTP.StatusCode)
+		assert.Equal(t, "test/1.0", status.HTTP.Server)
+		assert.Equal(t, "Café & Bar", status.HTTP.Title)
+		assert.Equal(t, []string{s.URL + "/home"}, status.HTTP.Redirects)
+		assert.Equal(t, map[string]string{"X-Frame-Options": "DENY", "Strict-Transport-Security": "max-age=31536000"}, status.HTTP.SecurityHeaders)
+	}
+}
+
+func TestHTTPFingerprinter_HTTPS(t *testing.T) {
+	s := httptest.NewTLSServer(website())
+	defer s.Close()
+	port := s.URL[len("https://127.0.0.1:"):]
+	status := fingerprint(t, s.URL, "www.example.com")
+	if assert.NotNil(t, status.HTTP) {
+		assert.Equal(t, "https://www.example.com:"+port+"/home", status.HTTP.URL)
+		assert.Equal(t, "Café & Bar", status.HTTP.Title)
+	}
+}
+
+func TestHTTPFingerprinter_NotHTTP(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1\r\n"))
+	})
+	status := fingerprint(t, "http://127.0.0.1:"+strconv.FormatUint(uint64(port), 10), "")
+	assert.Equal(t, types.OPEN, status.State)
+	assert.Nil(t, status.HTTP)
+}
+
+func TestSameOrigin(t *testing.T) {
+	u := func(s string) *url.URL {
+		parsed, _ := url.Parse(s)
+		return parsed
+	}
+	assert.True(t, sameOrigin(u("http://www.example.com/home"), "WWW.example.com", "80"))
+	assert.True(t, sameOrigin(u("https://10.0.0.1:8443/"), "10.0.0.1", "8443"))
+	assert.False(t, sameOrigin(u("https://10.0.0.1/"), "10.0.0.1", "80"))
+	assert.False(t, sameOrigin(u("http://other.example.com/"), "www.example.com", "80"))
+	assert.False(t, sameOrigin(u("ftp://10.0.0.1:80/"), "10.0.0.1", "80"))
+}
+
+func TestHTTPFingerprinter_RedirectLeavingPort(t *testing.T) {
+	s := httptest.NewServer(http.RedirectHandler("https://www.example.com/", http.StatusMovedPermanently))
+	defer s.Close()
+	status := fingerprint(t, s.URL, "")
+	if assert.NotNil(t, status.HTTP) {
+		assert.Equal(t, s.URL+"/", status.HTTP.URL)
+		assert.Equal(t, http.StatusMovedPermanently, status.HTTP.StatusCode)
+		assert.Equal(t, []string{"https://www.example.com/"}, status.HTTP.Redirects)
+	}
+}
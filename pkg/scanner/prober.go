@@ -0,0 +1,132 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//Target is the port of an ip a Prober checks
+type Target struct {
+	IP       string
+	Port     uint
+	Protocol string
+	//Expanded is the cidr or hostname IP was expanded from, if any
+	Expanded string
+	//Dialer dials from the source of the scan, and should be used for any connection the Prober makes
+	Dialer Dialer
+	//Timeout is how long the probe is given, the dial timeout of the scan
+	Timeout time.Duration
+}
+
+//Prober is a check of a port the built in probes do not make, such as a proprietary handshake. A scan selecting a
+//Prober by name, see Options.Prober, probes every port with it in place of a connect or udp probe
+//A Prober is used by many probes at once, so must be safe for concurrent use
+type Prober interface {
+	//Name is how scans select the Prober
+	Name() string
+	//Protocols are those of types.TCP and types.UDP the Prober can check
+	Protocols() []string
+	//Probe returns the status of target, with its State set. Its IP, Target and host fields are set by the scanner
+	//An error reports the port closed, unless ctx was cancelled, when the probe was interrupted
+	Probe(ctx context.Context, target Target) (types.IPStatus, error)
+}
+
+//Registry holds probers by name, for scans to select from
+type Registry struct {
+	mu      sync.RWMutex
+	probers map[string]Prober
+}
+
+//NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{probers: make(map[string]Prober)}
+}
+
+//DefaultRegistry is the Registry scans select from when Options.Probers is nil, and the one a pscan server uses
+var DefaultRegistry = NewRegistry()
+
+//Register adds p to DefaultRegistry, see Registry.Register. It is meant to be called from the init function of
+//a package providing a Prober, so a binary importing the package may scan with it
+func Register(p Prober) error {
+	return DefaultRegistry.Register(p)
+}
+
+//Register adds p to the registry, and returns an error if it has no name, a prober of the same name is registered,
+//or it names a protocol other than types.TCP or types.UDP
+func (r *Registry) Register(p Prober) error {
+	name := p.Name()
+	if len(name) == 0 {
+		return fmt.Errorf("probers must have a name")
+	}
+	if len(p.Protocols()) == 0 {
+		return fmt.Errorf("prober %s must support tcp or udp", name)
+	}
+	for _, protocol := range p.Protocols() {
+		if protocol != types.TCP && protocol != types.UDP {
+			return fmt.Errorf("prober %s names %s, which is not a supported protocol", name, protocol)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.probers[name]; found {
+		return fmt.Errorf("a prober named %s is already registered", name)
+	}
+	r.probers[name] = p
+	return nil
+}
+
+//Lookup returns the prober registered as name
+func (r *Registry) Lookup(name string) (Prober, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, found := r.probers[name]
+	return p, found
+}
+
+//Names returns the names of every registered prober, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.probers))
+	for name := range r.probers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Check returns an error if no prober is registered as name, or if it can not check ports of protocol
+func (r *Registry) Check(name string, protocol string) error {
+	p, found := r.Lookup(name)
+	if !found {
+		return fmt.Errorf("no prober named %s is registered", name)
+	}
+	for _, supported := range p.Protocols() {
+		if supported == protocol {
+			return nil
+		}
+	}
+	return fmt.Errorf("prober %s can not check %s ports", name, protocol)
+}
+
+//getProberState records the state of r.IP:port, as checked by p, on r, and returns false if ctx was cancelled
+//before the State was known
+func getProberState(ctx context.Context, p Prober, target Target, r *Result) bool {
+	status, err := p.Probe(ctx, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		r.State, r.Err = types.CLOSED, err
+		return true
+	}
+	status.IP, status.Target = r.IP, r.Target
+	status.Host, status.HostReason, status.MAC = r.Host, r.HostReason, r.MAC
+	r.IPStatus = status
+	return true
+}
@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//helloProber sends HELLO, and reports ports answering WELCOME open, with the rest of the line as their banner
+type helloProber struct {
+	name      string
+	protocols []string
+}
+
+func (h helloProber) Name() string {
+	return h.name
+}
+
+func (h helloProber) Protocols() []string {
+	return h.protocols
+}
+
+func (h helloProber) Probe(ctx context.Context, target Target) (types.IPStatus, error) {
+	conn, err := target.Dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.IP, strconv.FormatUint(uint64(target.Port), 10)))
+	if err != nil {
+		return types.IPStatus{}, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(target.Timeout))
+	if _, err := conn.Write([]byte("HELLO\r\n")); err != nil {
+		return types.IPStatus{}, err
+	}
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	if !strings.HasPrefix(line, "WELCOME") {
+		return types.IPStatus{IP: "ignored", State: types.CLOSED}, nil
+	}
+	return types.IPStatus{State: types.OPEN, Banner: strings.TrimSpace(strings.TrimPrefix(line, "WELCOME"))}, nil
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register(helloProber{name: "hello", protocols: []string{types.TCP}}))
+	assert.Nil(t, r.Register(helloProber{name: "echo", protocols: []string{types.TCP, types.UDP}}))
+	assert.EqualError(t, r.Register(helloProber{name: "hello", protocols: []string{types.TCP}}), "a prober named hello is already registered")
+	assert.EqualError(t, r.Register(helloProber{protocols: []string{types.TCP}}), "probers must have a name")
+	assert.EqualError(t, r.Register(helloProber{name: "none"}), "prober none must support tcp or udp")
+	assert.EqualError(t, r.Register(helloProber{name: "sctp", protocols: []string{"sctp"}}), "prober sctp names sctp, which is not a supported protocol")
+	assert.Equal(t, []string{"echo", "hello"}, r.Names())
+
+	assert.Nil(t, r.Check("hello", types.TCP))
+	assert.EqualError(t, r.Check("hello", types.UDP), "prober hello can not check udp ports")
+	assert.EqualError(t, r.Check("goodbye", types.TCP), "no prober named goodbye is registered")
+}
+
+func TestScanner_Scan_WithProber(t *testing.T) {
+	hello := tcpService(t, func(conn net.Conn) {
+		if line, _ := bufio.NewReader(conn).ReadString('\n'); line == "HELLO\r\n" {
+			_, _ = conn.Write([]byte("WELCOME acme/2.1\r\n"))
+		}
+	})
+	other := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1\r\n"))
+	})
+	probers := NewRegistry()
+	assert.Nil(t, probers.Register(helloProber{name: "hello", protocols: []string{types.TCP}}))
+
+	s := New(4)
+	defer s.Close()
+	results, err := s.Scan(context.Background(), []string{"127.0.0.1/32"}, []uint{hello, other}, Options{
+		Prober:   "hello",
+		Probers:  probers,
+		Timeouts: Timeouts{Dial: time.Second},
+	})
+	assert.Nil(t, err)
+	collected := collect(results)
+	if assert.Len(t, collected, 2) {
+		byPort := map[uint]types.IPStatus{collected[0].Port: collected[0].IPStatus, collected[1].Port: collected[1].IPStatus}
+		assert.Equal(t, types.IPStatus{IP: "127.0.0.1", Target: "127.0.0.1/32", State: types.OPEN, Banner: "acme/2.1"}, byPort[hello])
+		assert.Equal(t, types.IPStatus{IP: "127.0.0.1", Target: "127.0.0.1/32", State: types.CLOSED}, byPort[other])
+	}
+
+	_, err = s.Scan(context.Background(), []string{"127.0.0.1"}, []uint{hello}, Options{Protocol: types.UDP, Prober: "hello", Probers: probers})
+	assert.EqualError(t, err, "prober hello can not check udp ports")
+}
+
+//failingProber fails every probe, blocking until ctx is done if wait is true
+type failingProber struct {
+	wait bool
+}
+
+func (f failingProber) Name() string {
+	return "failing"
+}
+
+func (f failingProber) Protocols() []string {
+	return []string{types.TCP}
+}
+
+func (f failingProber) Probe(ctx context.Context, target Target) (types.IPStatus, error) {
+	if f.wait {
+		<-ctx.Done()
+		return types.IPStatus{}, ctx.Err()
+	}
+	return types.IPStatus{}, fmt.Errorf("handshake refused")
+}
+
+func TestGetProberState(t *testing.T) {
+	r := Result{IPStatus: types.IPStatus{IP: "127.0.0.1", Host: types.HOST_UP}}
+	assert.True(t, getProberState(context.Background(), failingProber{}, Target{IP: "127.0.0.1", Port: 7000}, &r))
+	assert.Equal(t, types.CLOSED, r.State)
+	assert.Equal(t, types.HOST_UP, r.Host)
+	assert.EqualError(t, r.Err, "handshake refused")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	r = Result{IPStatus: types.IPStatus{IP: "127.0.0.1"}}
+	assert.False(t, getProberState(ctx, failingProber{wait: true}, Target{IP: "127.0.0.1", Port: 7000}, &r))
+}
@@ -0,0 +1,493 @@
+//Package scanner probes the state of tcp and udp ports, and optionally inspects what listens on open ones, in process
+//It is the engine a pscan server runs its jobs on
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/service"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//Dialer is satisfied by net.Dialer
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+//Resolver is satisfied by net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+//PortRange is an inclusive range of ports
+type PortRange struct {
+	From uint
+	To   uint
+}
+
+//Source is where the probes a Dialer can not make, syn scans and pings, are sent from
+type Source struct {
+	//Address is the local ip syn scans and icmp pings are sent from. Nil leaves the choice to the routing table
+	Address net.IP
+	//Ports is the range of local ports syn scans are sent from. The zero value uses ports outside the range the
+	//system picks the ports of its own connections from
+	Ports PortRange
+	//Interface is the network interface the Dialer binds to, if any. Syn scans can not be bound, so connect instead
+	Interface string
+}
+
+//Timeouts bound each part of a probe. Zero values are replaced with the defaults below
+type Timeouts struct {
+	//Dial is how long a single probe waits for a connection, or a reply
+	Dial time.Duration
+	//Banner is how long an open port is given to send its banner, and to answer each detection probe
+	Banner time.Duration
+	//TLS is how long each tls handshake is given
+	TLS time.Duration
+	//HTTP is how long an open port is given to answer every request of its fingerprint
+	HTTP time.Duration
+	//Discovery is how long a host is given to answer any of its pings
+	Discovery time.Duration
+	//Script is how long each script is given to run against an open port
+	Script time.Duration
+}
+
+const (
+	DefaultDialTimeout      = 5 * time.Second
+	DefaultBannerTimeout    = 2 * time.Second
+	DefaultTLSTimeout       = 5 * time.Second
+	DefaultHTTPTimeout      = 5 * time.Second
+	DefaultDiscoveryTimeout = 1 * time.Second
+	DefaultScriptTimeout    = 10 * time.Second
+	DefaultMaxBannerBytes   = 256
+	//DefaultMaxIPs bounds the ips targets of a Scan may expand to
+	DefaultMaxIPs = 1024
+	//discoveryConcurrency bounds the hosts a scan pings at once
+	discoveryConcurrency = 64
+)
+
+//Options choose how the ports of a Scan are probed, and what is learned of open ones. The fields shared with
+//types.ScanRequest mean the same, and are validated the same
+type Options struct {
+	//Protocol is types.TCP or types.UDP. Empty scans tcp
+	Protocol string
+	//Technique is types.TechniqueConnect or types.TechniqueSYN. Empty connects
+	Technique string
+	//Banner optionally records what open tcp ports send on connect, types.BannerPassive or types.BannerActive
+	Banner string
+	Detect bool
+	TLS    bool
+	HTTP   bool
+	//Discover pings each ip first, and ProbeDown probes the ports of those found down rather than skipping them
+	Discover  bool
+	ProbeDown bool
+	//Prober optionally names a Prober of Probers, which checks each port in place of a connect or udp probe
+	Prober string
+	//Scripts optionally name executables of ScriptDir, run in turn against each open port, see ScriptRunner
+	Scripts []string
+
+	//Dialer dials tcp probes, and udp probes. Nil dials with a net.Dialer given Timeouts.Dial
+	Dialer Dialer
+	Source Source
+	//Resolver looks up the addresses of hostname targets. Nil uses net.DefaultResolver
+	Resolver Resolver
+	Timeouts Timeouts
+	//MaxIPs bounds the ips targets may expand to. Zero is DefaultMaxIPs
+	MaxIPs uint
+	//MaxBannerBytes is the most of a banner recorded. Zero is DefaultMaxBannerBytes
+	MaxBannerBytes uint
+	//ServiceProbes are matched to detect services. Nil uses service.Default()
+	ServiceProbes *service.Database
+	//Probers are those Prober may name. Nil uses DefaultRegistry
+	Probers *Registry
+	//ScriptDir is the directory Scripts are run from. Scripts can not be run without one
+	ScriptDir string
+	//ScriptRunner runs Scripts. Nil runs them with a ScriptRunner of the scan's own
+	ScriptRunner *ScriptRunner
+	//MaxScriptOutputBytes is the most each script may print. Zero is DefaultMaxScriptOutputBytes
+	MaxScriptOutputBytes uint
+	//OnProbe, if set, is called as each probe starts. The function it returns, if not nil, is called with the result
+	//of the probe, or nil if the probe was interrupted, e.g to time or trace probes
+	OnProbe func(ip string, port uint) func(*Result)
+}
+
+//withDefaults returns a copy of o, with defaults in place of zero values
+func (o Options) withDefaults() Options {
+	if len(o.Protocol) == 0 {
+		o.Protocol = types.TCP
+	}
+	if len(o.Technique) == 0 {
+		o.Technique = types.TechniqueConnect
+	}
+	if o.Timeouts.Dial == 0 {
+		o.Timeouts.Dial = DefaultDialTimeout
+	}
+	if o.Timeouts.Banner == 0 {
+		o.Timeouts.Banner = DefaultBannerTimeout
+	}
+	if o.Timeouts.TLS == 0 {
+		o.Timeouts.TLS = DefaultTLSTimeout
+	}
+	if o.Timeouts.HTTP == 0 {
+		o.Timeouts.HTTP = DefaultHTTPTimeout
+	}
+	if o.Timeouts.Discovery == 0 {
+		o.Timeouts.Discovery = DefaultDiscoveryTimeout
+	}
+	if o.Timeouts.Script == 0 {
+		o.Timeouts.Script = DefaultScriptTimeout
+	}
+	if o.Dialer == nil {
+		o.Dialer = &net.Dialer{Timeout: o.Timeouts.Dial}
+	}
+	if o.Resolver == nil {
+		o.Resolver = net.DefaultResolver
+	}
+	if o.MaxIPs == 0 {
+		o.MaxIPs = DefaultMaxIPs
+	}
+	if o.MaxBannerBytes == 0 {
+		o.MaxBannerBytes = DefaultMaxBannerBytes
+	}
+	if o.ServiceProbes == nil {
+		o.ServiceProbes = service.Default()
+	}
+	if o.Probers == nil {
+		o.Probers = DefaultRegistry
+	}
+	if len(o.Scripts) > 0 && o.ScriptRunner == nil {
+		o.ScriptRunner = NewScriptRunner(DefaultMaxConcurrentScripts)
+	}
+	if o.MaxScriptOutputBytes == 0 {
+		o.MaxScriptOutputBytes = DefaultMaxScriptOutputBytes
+	}
+	return o
+}
+
+//validate returns an error describing every option that is not valid, for a scan of ports
+func (o Options) validate(ports []uint) error {
+	messages := make([]string, 0)
+	if len(ports) == 0 {
+		messages = append(messages, "you must provide a list of ports")
+	}
+	for _, port := range ports {
+		if !pnet.ValidPort(port) {
+			messages = append(messages, fmt.Sprintf("%d is not a valid port number", port))
+		}
+	}
+	request := types.ScanRequest{
+		ScanIPs:   []string{},
+		ScanPort:  1,
+		Protocol:  o.Protocol,
+		Technique: o.Technique,
+		Banner:    o.Banner,
+		Detect:    o.Detect,
+		TLS:       o.TLS,
+		HTTP:      o.HTTP,
+		Discover:  o.Discover,
+		ProbeDown: o.ProbeDown,
+		Probe:     o.Prober,
+		Scripts:   o.Scripts,
+	}
+	if _, err := request.Validate(); err != nil {
+		messages = append(messages, err.Error())
+	}
+	if len(o.Prober) > 0 {
+		if err := o.Probers.Check(o.Prober, o.Protocol); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	for _, name := range o.Scripts {
+		if _, err := FindScript(o.ScriptDir, name); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+//Result is the status of one port of one ip
+type Result struct {
+	types.IPStatus
+	Port uint
+	//Err is why a port is closed or open|filtered, e.g the error a dial failed with, if there was one
+	Err error
+}
+
+//Scanner runs scans, bounding the probes in progress across all of them
+type Scanner struct {
+	//probes holds a token for each probe in progress
+	probes chan struct{}
+	//syn sends syn scans. Nil if raw sockets can not be opened, in which case syn scans connect instead
+	syn    *synScanner
+	synErr error
+}
+
+//New returns a Scanner running at most concurrency probes at once, across all of its scans
+//Syn scans are available if a raw socket can be opened, see SynError
+func New(concurrency uint) *Scanner {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	syn, err := newSynScanner()
+	return &Scanner{probes: make(chan struct{}, concurrency), syn: syn, synErr: err}
+}
+
+//SynError returns why syn scans are not available, or nil if they are
+func (s *Scanner) SynError() error {
+	return s.synErr
+}
+
+//Close releases the raw socket of syn scans, if one was opened
+func (s *Scanner) Close() error {
+	if s.syn != nil {
+		return s.syn.close()
+	}
+	return nil
+}
+
+//Technique returns the technique tcp ports are probed with for options, and why it is not the one requested
+//if a syn scan falls back to connecting
+func (s *Scanner) Technique(options Options) (string, string) {
+	options = options.withDefaults()
+	if options.Technique != types.TechniqueSYN || options.Protocol != types.TCP {
+		return options.Technique, ""
+	}
+	reason := s.synUnavailable(options.Source, options.Banner != "" || options.Detect || options.TLS || options.HTTP)
+	if len(reason) > 0 {
+		return types.TechniqueConnect, reason
+	}
+	return types.TechniqueSYN, ""
+}
+
+//Scan probes each of ports on every ip targets expand to, see ExpandTargets. Results are sent on the returned
+//channel as probes complete, which is closed once every probe is done. It must be read until closed
+//Probes interrupted by the cancellation of ctx send no result
+func (s *Scanner) Scan(ctx context.Context, targets []string, ports []uint, options Options) (<-chan Result, error) {
+	options = options.withDefaults()
+	if err := options.validate(ports); err != nil {
+		return nil, err
+	}
+	e, err := ExpandTargets(ctx, options.Resolver, targets, options.MaxIPs)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanExpansion(ctx, e, ports, options), nil
+}
+
+//ScanExpansion is Scan, of targets already expanded
+func (s *Scanner) ScanExpansion(ctx context.Context, e Expansion, ports []uint, options Options) (<-chan Result, error) {
+	options = options.withDefaults()
+	if err := options.validate(ports); err != nil {
+		return nil, err
+	}
+	return s.scanExpansion(ctx, e, ports, options), nil
+}
+
+//scanExpansion is ScanExpansion, of options already defaulted and validated
+func (s *Scanner) scanExpansion(ctx context.Context, e Expansion, ports []uint, options Options) <-chan Result {
+	technique, _ := s.Technique(options)
+	probers := make([]prober, len(ports))
+	for i, port := range ports {
+		probers[i] = s.prober(port, technique, options)
+	}
+	discoverer := s.discoverer(options)
+	//discovery slots are apart from s.probes, so that hosts being pinged do not hold up the ports of hosts already up
+	discoveries := make(chan struct{}, discoveryConcurrency)
+	results := make(chan Result)
+	wg := &sync.WaitGroup{}
+	for _, ip := range e.IPs {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			//each host is discovered once, before any of its ports are probed
+			host := types.IPStatus{IP: ip, Target: e.Targets[ip]}
+			if discoverer != nil {
+				select {
+				case discoveries <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				found := discoverer.discover(ctx, &host)
+				<-discoveries
+				if !found {
+					return
+				}
+			}
+			for i, port := range ports {
+				wg.Add(1)
+				go func(p prober, port uint) {
+					defer wg.Done()
+					select {
+					case s.probes <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					defer func() {
+						<-s.probes
+					}()
+					var done func(*Result)
+					if options.OnProbe != nil {
+						done = options.OnProbe(ip, port)
+					}
+					r := Result{IPStatus: host, Port: port}
+					if !p.probe(ctx, &r) {
+						if done != nil {
+							done(nil)
+						}
+						return
+					}
+					if done != nil {
+						done(&r)
+					}
+					results <- r
+				}(probers[i], port)
+			}
+		}(ip)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+//prober probes one port of ips, as options ask
+type prober struct {
+	port    uint
+	options Options
+	syn     *synScanner
+	inspect connInspector
+	//custom checks each port in place of the built in probes, if the scan selected a Prober
+	custom Prober
+}
+
+func (s *Scanner) prober(port uint, technique string, options Options) prober {
+	p := prober{port: port, options: options}
+	if technique == types.TechniqueSYN {
+		p.syn = s.syn
+	}
+	dialer := options.Dialer
+	inspectors := make([]connInspector, 0)
+	if options.TLS {
+		//tls goes first, as a handshake needs a connection nothing has yet been sent on
+		inspectors = append(inspectors, tlsInspector{dialer: dialer, timeout: options.Timeouts.TLS}.inspect)
+	}
+	if options.HTTP {
+		inspectors = append(inspectors, httpFingerprinter{port: port, dialer: dialer, timeout: options.Timeouts.HTTP}.inspect)
+	}
+	if options.Detect {
+		inspectors = append(inspectors, serviceDetector{
+			port:           port,
+			dialer:         dialer,
+			probes:         options.ServiceProbes,
+			timeout:        options.Timeouts.Banner,
+			banner:         options.Banner,
+			maxBannerBytes: options.MaxBannerBytes,
+		}.inspect)
+	} else if len(options.Banner) > 0 {
+		inspectors = append(inspectors, bannerGrabber{port: port, mode: options.Banner, maxBytes: options.MaxBannerBytes, timeout: options.Timeouts.Banner}.inspect)
+	}
+	p.inspect = chainInspectors(dialer, inspectors...)
+	if len(options.Prober) > 0 {
+		p.custom, _ = options.Probers.Lookup(options.Prober)
+	}
+	return p
+}
+
+//discoverer returns the hostDiscoverer of a scan, or nil if options do not ask for discovery
+func (s *Scanner) discoverer(options Options) *hostDiscoverer {
+	if !options.Discover {
+		return nil
+	}
+	d := &hostDiscoverer{dialer: options.Dialer, source: options.Source, timeout: options.Timeouts.Discovery}
+	if len(s.synUnavailable(options.Source, false)) == 0 {
+		d.syn = s.syn
+	}
+	return d
+}
+
+//probe records the state of r.IP's port on r, and what scripts found of it if it is open, and returns false if ctx
+//was cancelled before it was known
+func (p prober) probe(ctx context.Context, r *Result) bool {
+	if !p.state(ctx, r) {
+		return false
+	}
+	if r.State == types.OPEN && len(p.options.Scripts) > 0 {
+		return p.options.ScriptRunner.run(ctx, p.options, r)
+	}
+	return true
+}
+
+//state records the state of r.IP's port on r, and returns false if ctx was cancelled before it was known
+func (p prober) state(ctx context.Context, r *Result) bool {
+	if r.Host == types.HOST_DOWN && !p.options.ProbeDown {
+		r.State = types.SKIPPED
+		return true
+	}
+	if p.custom != nil {
+		target := Target{
+			IP:       r.IP,
+			Port:     p.port,
+			Protocol: p.options.Protocol,
+			Expanded: r.Target,
+			Dialer:   p.options.Dialer,
+			Timeout:  p.options.Timeouts.Dial,
+		}
+		return getProberState(ctx, p.custom, target, r)
+	} else if p.options.Protocol == types.UDP {
+		return getUDPState(ctx, p.options.Dialer, p.port, p.options.Timeouts.Dial, r)
+	} else if ip, _ := pnet.ParseIP(r.IP); p.syn != nil && ip.To4() != nil {
+		//the raw socket is ipv4 only, so ipv6 addresses of a syn scan are connected to
+		return p.syn.getState(ctx, p.options.Source, ip, p.port, p.options.Timeouts.Dial, r)
+	}
+	return getState(ctx, p.options.Dialer, p.port, p.inspect, r)
+}
+
+//getState records the state of tcp r.IP:port, dialed with dialer, on r, and returns false if ctx was cancelled
+//before the State was known. An open port's connection is passed to inspect, if given, before it is closed
+func getState(ctx context.Context, dialer Dialer, port uint, inspect connInspector, r *Result) bool {
+	con, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(r.IP, strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		r.State, r.Err = types.CLOSED, err
+		return true
+	}
+	defer con.Close()
+	r.State = types.OPEN
+	if inspect != nil {
+		inspect(ctx, con, &r.IPStatus)
+	}
+	return true
+}
+
+//chainInspectors returns an inspector running each of inspectors in turn. The first is given the connection getState
+//made, and the rest connections of their own dialed with dialer, as an inspector may leave its connection unusable
+func chainInspectors(dialer Dialer, inspectors ...connInspector) connInspector {
+	if len(inspectors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+		inspectors[0](ctx, conn, status)
+		for _, inspect := range inspectors[1:] {
+			con, err := dialer.DialContext(ctx, "tcp", conn.RemoteAddr().String())
+			if err != nil {
+				return
+			}
+			inspect(ctx, con, status)
+			_ = con.Close()
+		}
+	}
+}
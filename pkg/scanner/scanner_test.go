@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//connect probes tcp ip:port, as a connect scan would
+func connect(dialer Dialer, ip string, port uint, inspect connInspector) (types.IPStatus, bool) {
+	r := Result{IPStatus: types.IPStatus{IP: ip}}
+	ok := getState(context.Background(), dialer, port, inspect, &r)
+	return r.IPStatus, ok
+}
+
+//collect reads results until the channel is closed, sorted by port then ip
+func collect(results <-chan Result) []Result {
+	collected := make([]Result, 0)
+	for r := range results {
+		collected = append(collected, r)
+	}
+	sort.Slice(collected, func(i, j int) bool {
+		if collected[i].Port != collected[j].Port {
+			return collected[i].Port < collected[j].Port
+		}
+		return collected[i].IP < collected[j].IP
+	})
+	return collected
+}
+
+func TestScanner_Scan(t *testing.T) {
+	open := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("220 ready\r\n"))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := uint(l.Addr().(*net.TCPAddr).Port)
+	_ = l.Close()
+
+	s := New(4)
+	defer s.Close()
+	var mu sync.Mutex
+	started := 0
+	results, err := s.Scan(context.Background(), []string{"local.example.com"}, []uint{open, closed}, Options{
+		Banner:   types.BannerPassive,
+		Resolver: fakeResolver{"local.example.com": {{IP: net.ParseIP("127.0.0.1")}}},
+		Timeouts: Timeouts{Dial: time.Second, Banner: time.Second},
+		OnProbe: func(ip string, port uint) func(*Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			started++
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+	collected := collect(results)
+	if open > closed {
+		collected[0], collected[1] = collected[1], collected[0]
+	}
+	if assert.Len(t, collected, 2) {
+		assert.Equal(t, Result{IPStatus: types.IPStatus{IP: "127.0.0.1", State: types.OPEN, Target: "local.example.com", Banner: "220 ready"}, Port: open}, collected[0])
+		assert.Equal(t, types.CLOSED, collected[1].State)
+		assert.Equal(t, closed, collected[1].Port)
+		assert.NotNil(t, collected[1].Err)
+	}
+	assert.Equal(t, 2, started)
+}
+
+func TestScanner_Scan_NotValid(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	_, err := s.Scan(context.Background(), []string{"127.0.0.1"}, []uint{0}, Options{Protocol: types.UDP, Detect: true})
+	assert.EqualError(t, err, "0 is not a valid port number\nservices can only be detected on tcp scans")
+
+	_, err = s.Scan(context.Background(), []string{"10.0.0.0/8"}, []uint{22}, Options{})
+	assert.EqualError(t, err, "cidr 10.0.0.0/8 has more than the 1024 ips a scan request may contain")
+}
+
+func TestScanner_Scan_Interrupted(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := s.ScanExpansion(ctx, Expansion{IPs: []string{"127.0.0.1", "127.0.0.2"}}, []uint{22}, Options{})
+	assert.Nil(t, err)
+	assert.Empty(t, collect(results))
+}
+
+//countingDialer counts the dials of each address
+type countingDialer struct {
+	mu    sync.Mutex
+	dials map[string]int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials[address]++
+	d.mu.Unlock()
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+}
+
+func TestScanner_Scan_DiscoversOnce(t *testing.T) {
+	s := New(1)
+	defer s.Close()
+	dialer := &countingDialer{dials: make(map[string]int)}
+	//100::/64 is reserved for discarding traffic, so the host is found down, and its ports skipped
+	results, err := s.ScanExpansion(context.Background(), Expansion{IPs: []string{"100::1"}}, []uint{1, 2, 3}, Options{
+		Discover: true,
+		Dialer:   dialer,
+		Timeouts: Timeouts{Discovery: 200 * time.Millisecond},
+	})
+	assert.Nil(t, err)
+	collected := collect(results)
+	if assert.Len(t, collected, 3) {
+		for _, r := range collected {
+			assert.Equal(t, types.IPStatus{IP: "100::1", Host: types.HOST_DOWN, State: types.SKIPPED}, r.IPStatus)
+		}
+	}
+	//the host is pinged once, not once for each of its ports
+	expected := make(map[string]int)
+	for _, port := range connectPingPorts {
+		expected[net.JoinHostPort("100::1", strconv.FormatUint(uint64(port), 10))] = 1
+	}
+	assert.Equal(t, expected, dialer.dials)
+}
+
+func TestScanner_Technique(t *testing.T) {
+	s := &Scanner{}
+	technique, reason := s.Technique(Options{Technique: types.TechniqueSYN})
+	assert.Equal(t, types.TechniqueConnect, technique)
+	assert.Equal(t, "syn scanning is not available, see the log at startup", reason)
+
+	s.syn = &synScanner{}
+	technique, reason = s.Technique(Options{Technique: types.TechniqueSYN, Source: Source{Address: net.ParseIP("10.0.0.1")}})
+	assert.Equal(t, types.TechniqueSYN, technique)
+	assert.Equal(t, "", reason)
+	_, reason = s.Technique(Options{Technique: types.TechniqueSYN, Source: Source{Interface: "eth1"}})
+	assert.Equal(t, "syn scans can not be bound to an interface", reason)
+	_, reason = s.Technique(Options{Technique: types.TechniqueSYN, Banner: types.BannerPassive})
+	assert.Equal(t, "banners, detection, tls and http need a connection", reason)
+	technique, reason = s.Technique(Options{})
+	assert.Equal(t, types.TechniqueConnect, technique)
+	assert.Equal(t, "", reason)
+}
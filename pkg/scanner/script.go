@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//DefaultMaxConcurrentScripts bounds the scripts a ScriptRunner runs at once, if not given
+	DefaultMaxConcurrentScripts = 4
+	//DefaultMaxScriptOutputBytes is the most a script may write to stdout, if not given
+	DefaultMaxScriptOutputBytes = 64 * 1024
+	//maxScriptErrorBytes is the most of what a failing script writes to stderr kept for its error
+	maxScriptErrorBytes = 512
+)
+
+//scriptPath is the PATH scripts are run with, the only variable of the server's environment they are given
+const scriptPath = "/usr/local/bin:/usr/bin:/bin"
+
+//ScriptRunner runs operator installed executables against open ports, bounding how many run at once across all scans
+type ScriptRunner struct {
+	//slots holds a token for each script running
+	slots chan struct{}
+}
+
+//NewScriptRunner returns a ScriptRunner running at most concurrency scripts at once
+func NewScriptRunner(concurrency uint) *ScriptRunner {
+	if concurrency == 0 {
+		concurrency = DefaultMaxConcurrentScripts
+	}
+	return &ScriptRunner{slots: make(chan struct{}, concurrency)}
+}
+
+//FindScript returns the path of the script name in dir, and an error if it is not an executable regular file there
+//Symlinks are followed only while they stay within dir, so a script can not be used to run anything outside of it
+func FindScript(dir string, name string) (string, error) {
+	if len(dir) == 0 {
+		return "", fmt.Errorf("scripts are not enabled")
+	} else if !types.ValidScriptName(name) {
+		return "", fmt.Errorf("%s is not a valid script name", name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err == nil {
+		root, err = filepath.Abs(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("script directory %s can not be read", dir)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", fmt.Errorf("script %s is not installed", name)
+	} else if filepath.Dir(path) != root {
+		return "", fmt.Errorf("script %s links outside of the script directory", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return "", fmt.Errorf("script %s is not an executable file", name)
+	} else if info.Mode().Perm()&0002 != 0 {
+		return "", fmt.Errorf("script %s is writable by anyone, so will not be run", name)
+	}
+	return path, nil
+}
+
+//scriptInput is what a script is given on stdin, the status of the open port it runs against
+type scriptInput struct {
+	types.IPStatus
+	Port     uint   `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+//run runs each of the scripts of options against the open port of r, in turn, recording what each printed on r
+//It returns false if ctx was cancelled before every script finished
+func (s *ScriptRunner) run(ctx context.Context, options Options, r *Result) bool {
+	for _, name := range options.Scripts {
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		result := runScript(ctx, name, options, r)
+		<-s.slots
+		if ctx.Err() != nil {
+			return false
+		}
+		r.Scripts = append(r.Scripts, result)
+	}
+	return true
+}
+
+//runScript runs the script name against the open port of r, without a shell and with an environment of its own,
+//killing it and any process it started if it outlives the script timeout
+func runScript(ctx context.Context, name string, options Options, r *Result) types.ScriptResult {
+	result := types.ScriptResult{Name: name}
+	path, err := FindScript(options.ScriptDir, name)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	input, err := json.Marshal(scriptInput{IPStatus: r.IPStatus, Port: r.Port, Protocol: options.Protocol})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	cmd := exec.Command(path)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = []string{
+		"PATH=" + scriptPath,
+		"PSCAN_IP=" + r.IP,
+		"PSCAN_PORT=" + strconv.FormatUint(uint64(r.Port), 10),
+		"PSCAN_PROTOCOL=" + options.Protocol,
+		"PSCAN_TARGET=" + r.Target,
+	}
+	if r.Service != nil {
+		cmd.Env = append(cmd.Env, "PSCAN_SERVICE="+r.Service.Name)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &cappedBuffer{max: options.MaxScriptOutputBytes}
+	stderr := &cappedBuffer{max: maxScriptErrorBytes}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	isolate(cmd)
+
+	if err := cmd.Start(); err != nil {
+		result.Error = fmt.Sprintf("script could not be started, error was: %s", err.Error())
+		return result
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	runCtx, cancel := context.WithTimeout(ctx, options.Timeouts.Script)
+	defer cancel()
+	select {
+	case err = <-exited:
+	case <-runCtx.Done():
+		kill(cmd)
+		<-exited
+		result.Error = fmt.Sprintf("script did not finish within %s", options.Timeouts.Script)
+		return result
+	}
+
+	if err != nil {
+		result.Error = fmt.Sprintf("script failed, error was: %s", err.Error())
+		if msg := strings.TrimSpace(stderr.buf.String()); len(msg) > 0 {
+			result.Error = fmt.Sprintf("%s: %s", result.Error, sanitizeBanner([]byte(msg)))
+		}
+		return result
+	} else if stdout.overflowed {
+		result.Error = fmt.Sprintf("script wrote more than %d bytes", options.MaxScriptOutputBytes)
+		return result
+	}
+	output := bytes.TrimSpace(stdout.buf.Bytes())
+	if len(output) == 0 {
+		return result
+	}
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, output); err != nil || output[0] != '{' {
+		result.Error = "script output is not a json object"
+		return result
+	}
+	result.Output = compact.Bytes()
+	return result
+}
+
+//cappedBuffer keeps up to max bytes written to it, discarding the rest. The buffer is not embedded, so that copies
+//into a cappedBuffer can not bypass Write with bytes.Buffer's ReadFrom
+type cappedBuffer struct {
+	buf        bytes.Buffer
+	max        uint
+	overflowed bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := int(c.max) - c.buf.Len(); len(p) > room {
+		c.overflowed = true
+		if room > 0 {
+			c.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
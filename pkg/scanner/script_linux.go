@@ -0,0 +1,17 @@
+package scanner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+//isolate starts cmd in a process group of its own, so kill reaches anything it starts, and has it killed
+//should the scanner exit first
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+//kill kills the process group of a started cmd
+func kill(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
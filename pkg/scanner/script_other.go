@@ -0,0 +1,13 @@
+// +build !linux
+
+package scanner
+
+import "os/exec"
+
+//isolate does nothing, as process groups are only used on linux
+func isolate(cmd *exec.Cmd) {}
+
+//kill kills a started cmd. Processes it started are left running, and its output is read until they exit
+func kill(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//installScript writes an executable shell script named name to dir
+func installScript(t *testing.T, dir, name, body string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runScripts(dir string, timeout time.Duration, names ...string) Result {
+	options := Options{Scripts: names, ScriptDir: dir, Timeouts: Timeouts{Script: timeout}}.withDefaults()
+	r := Result{IPStatus: types.IPStatus{IP: "127.0.0.1", State: types.OPEN, Target: "local.example.com"}, Port: 7000}
+	options.ScriptRunner.run(context.Background(), options, &r)
+	return r
+}
+
+func TestScriptRunner(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scripts")
+	defer os.RemoveAll(dir)
+	installScript(t, dir, "env.sh", `read -r input; printf '{"ip":"%s","port":%s,"target":"%s","vars":%s}' "$PSCAN_IP" "$PSCAN_PORT" "$PSCAN_TARGET" "$(env | wc -l)"`)
+	installScript(t, dir, "stdin.sh", `cat`)
+	installScript(t, dir, "text.sh", `echo hello`)
+	installScript(t, dir, "fails.sh", `echo "no route to host" >&2; exit 3`)
+	installScript(t, dir, "silent.sh", `exit 0`)
+
+	r := runScripts(dir, 5*time.Second, "env.sh", "stdin.sh", "text.sh", "fails.sh", "silent.sh")
+	if assert.Len(t, r.Scripts, 5) {
+		var env struct {
+			IP     string
+			Port   uint
+			Target string
+			Vars   int
+		}
+		assert.Nil(t, json.Unmarshal(r.Scripts[0].Output, &env))
+		assert.Equal(t, "127.0.0.1", env.IP)
+		assert.Equal(t, uint(7000), env.Port)
+		assert.Equal(t, "local.example.com", env.Target)
+		assert.True(t, env.Vars <= 8, "scripts are given a restricted environment")
+
+		var input scriptInput
+		assert.Nil(t, json.Unmarshal(r.Scripts[1].Output, &input))
+		assert.Equal(t, types.OPEN, input.State)
+		assert.Equal(t, uint(7000), input.Port)
+		assert.Equal(t, types.TCP, input.Protocol)
+
+		assert.Equal(t, types.ScriptResult{Name: "text.sh", Error: "script output is not a json object"}, r.Scripts[2])
+		assert.Equal(t, types.ScriptResult{Name: "fails.sh", Error: "script failed, error was: exit status 3: no route to host"}, r.Scripts[3])
+		assert.Equal(t, types.ScriptResult{Name: "silent.sh"}, r.Scripts[4])
+	}
+}
+
+func TestScriptRunner_Timeout(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scripts")
+	defer os.RemoveAll(dir)
+	installScript(t, dir, "slow.sh", `sleep 30 & sleep 30`)
+	start := time.Now()
+	r := runScripts(dir, 100*time.Millisecond, "slow.sh")
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, []types.ScriptResult{{Name: "slow.sh", Error: "script did not finish within 100ms"}}, r.Scripts)
+}
+
+func TestScriptRunner_OutputLimit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scripts")
+	defer os.RemoveAll(dir)
+	installScript(t, dir, "chatty.sh", `yes '{}' | head -c 100000`)
+	r := runScripts(dir, 5*time.Second, "chatty.sh")
+	assert.Equal(t, []types.ScriptResult{{Name: "chatty.sh", Error: "script wrote more than 65536 bytes"}}, r.Scripts)
+}
+
+func TestFindScript(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scripts")
+	defer os.RemoveAll(dir)
+	outside, _ := ioutil.TempDir("", "outside")
+	defer os.RemoveAll(outside)
+	installScript(t, dir, "ok.sh", `exit 0`)
+	installScript(t, outside, "evil.sh", `exit 0`)
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "evil.sh"), filepath.Join(dir, "evil.sh")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data.txt"), []byte("x"), 0644))
+	installScript(t, dir, "open.sh", `exit 0`)
+	assert.Nil(t, os.Chmod(filepath.Join(dir, "open.sh"), 0777))
+
+	path, err := FindScript(dir, "ok.sh")
+	assert.Nil(t, err)
+	assert.True(t, filepath.IsAbs(path))
+	for name, msg := range map[string]string{
+		"../ok.sh":  "../ok.sh is not a valid script name",
+		"missing":   "script missing is not installed",
+		"evil.sh":   "script evil.sh links outside of the script directory",
+		"data.txt":  "script data.txt is not an executable file",
+		"open.sh":   "script open.sh is writable by anyone, so will not be run",
+		".hidden":   ".hidden is not a valid script name",
+		"a b":       "a b is not a valid script name",
+		"ok.sh\x00": "ok.sh\x00 is not a valid script name",
+	} {
+		_, err := FindScript(dir, name)
+		assert.EqualError(t, err, msg)
+	}
+	_, err = FindScript("", "ok.sh")
+	assert.EqualError(t, err, "scripts are not enabled")
+}
+
+func TestScanner_Scan_WithScripts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scripts")
+	defer os.RemoveAll(dir)
+	installScript(t, dir, "port.sh", `echo "{\"port\": $PSCAN_PORT}"`)
+	open := tcpService(t, func(conn net.Conn) {})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := uint(l.Addr().(*net.TCPAddr).Port)
+	_ = l.Close()
+
+	s := New(4)
+	defer s.Close()
+	results, err := s.Scan(context.Background(), []string{"127.0.0.1"}, []uint{open, closed}, Options{Scripts: []string{"port.sh"}, ScriptDir: dir})
+	assert.Nil(t, err)
+	for _, r := range collect(results) {
+		if r.Port == open {
+			assert.Equal(t, []types.ScriptResult{{Name: "port.sh", Output: json.RawMessage(`{"port":` + strconv.FormatUint(uint64(open), 10) + `}`)}}, r.Scripts)
+		} else {
+			assert.Empty(t, r.Scripts, "scripts are only run against open ports")
+		}
+	}
+
+	_, err = s.Scan(context.Background(), []string{"127.0.0.1"}, []uint{open}, Options{Scripts: []string{"port.sh"}})
+	assert.EqualError(t, err, "scripts are not enabled")
+}
@@ -0,0 +1,316 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+const (
+	//synAttempts is how many times a SYN is sent, as either it or its reply may be lost
+	synAttempts = 2
+	//synPortAttempts bounds how many source ports are tried for one probe, should others be in use by probes
+	synPortAttempts = 8
+	//defaultLocalFrom and defaultLocalTo are linux's default ip_local_port_range, assumed where it can not be read
+	defaultLocalFrom = 32768
+	defaultLocalTo   = 60999
+	//minSynPorts is the fewest ports a range beside ip_local_port_range must hold for syn scans to be sent from it
+	minSynPorts = 1024
+
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+var errNoReply = errors.New("no reply")
+
+//localPortRange holds the range the system picks the ports of its own outgoing connections from, only found on linux
+var localPortRange = "/proc/sys/net/ipv4/ip_local_port_range"
+
+//synKey identifies the reply to a SYN, by the address it came from and the port it was sent to
+type synKey struct {
+	remote     [4]byte
+	remotePort uint16
+	localPort  uint16
+}
+
+//synWaiter receives the flags of the reply to a SYN sent with sequence number seq, or if ack is set,
+//to an ACK acknowledging seq
+type synWaiter struct {
+	seq   uint32
+	ack   bool
+	reply chan byte
+}
+
+//synScanner sends SYNs, and ACKs for pings, on a raw socket and matches the replies, without completing handshakes
+//The system answers a SYN-ACK for a port it has no socket on with a RST, so no connection is left half open
+type synScanner struct {
+	conn *net.IPConn
+	//ports are the source ports of segments sent without a source profile range, see synPorts
+	ports PortRange
+
+	mu      sync.Mutex
+	waiting map[synKey]synWaiter
+}
+
+//newSynScanner opens the raw socket syn scans are sent and received on
+//newSynScanner returns an error if raw sockets are not supported, or pscan lacks CAP_NET_RAW
+func newSynScanner() (*synScanner, error) {
+	if !synSupported {
+		return nil, fmt.Errorf("syn scanning is only supported on linux")
+	}
+	conn, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("could not open raw socket, syn scanning needs CAP_NET_RAW, error was: %s", err.Error())
+	}
+	s := &synScanner{conn: conn, ports: synPorts(readLocalPortRange()), waiting: make(map[synKey]synWaiter)}
+	go s.receive()
+	return s, nil
+}
+
+//receive hands each tcp segment that replies to a SYN sent to the probe waiting for it, until the socket is closed
+func (s *synScanner) receive() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		ip, ok := addr.(*net.IPAddr)
+		if !ok || ip.IP.To4() == nil || n < 20 {
+			continue
+		}
+		key := synKey{remotePort: binary.BigEndian.Uint16(buf[0:2]), localPort: binary.BigEndian.Uint16(buf[2:4])}
+		copy(key.remote[:], ip.IP.To4())
+		seq, ack, flags := binary.BigEndian.Uint32(buf[4:8]), binary.BigEndian.Uint32(buf[8:12]), buf[13]
+		s.mu.Lock()
+		waiter, found := s.waiting[key]
+		s.mu.Unlock()
+		//a reply acknowledges the SYN's sequence number, which tells it from other traffic between the same ports
+		//A RST answering an ACK instead takes its sequence number from what the ACK acknowledged
+		synReply := !waiter.ack && flags&tcpFlagACK != 0 && flags&(tcpFlagSYN|tcpFlagRST) != 0 && ack == waiter.seq+1
+		ackReply := waiter.ack && flags&tcpFlagRST != 0 && seq == waiter.seq
+		if found && (synReply || ackReply) {
+			select {
+			case waiter.reply <- flags:
+			default:
+			}
+		}
+	}
+}
+
+//synUnavailable returns why a syn scan from source can not be made, or empty if it can. inspect is true if the
+//scan inspects open ports, which needs the connection a syn scan does not make
+func (s *Scanner) synUnavailable(source Source, inspect bool) string {
+	if s.syn == nil {
+		return "syn scanning is not available, see the log at startup"
+	} else if len(source.Interface) > 0 {
+		return "syn scans can not be bound to an interface"
+	} else if inspect {
+		return "banners, detection, tls and http need a connection"
+	}
+	return ""
+}
+
+func (s *synScanner) close() error {
+	return s.conn.Close()
+}
+
+//getState records the State of tcp ip:port on r, and returns false if ctx was cancelled before it was known
+//A SYN-ACK is open. A RST, no reply within timeout, or a SYN that could not be sent are closed, as with connect
+func (s *synScanner) getState(ctx context.Context, source Source, ip net.IP, port uint, timeout time.Duration, r *Result) bool {
+	flags, err := s.send(ctx, source, ip, port, timeout, false)
+	if ctx.Err() != nil {
+		return false
+	} else if err != nil {
+		r.State, r.Err = types.CLOSED, err
+	} else if flags == 0 {
+		r.State, r.Err = types.CLOSED, errNoReply
+	} else if flags&tcpFlagSYN != 0 {
+		r.State = types.OPEN
+	} else {
+		r.State = types.CLOSED
+	}
+	return true
+}
+
+//ping returns true if ip replied to a SYN, or if ack is set an ACK, sent to port within timeout
+//Any reply will do, as a host that resets a connection is as up as one that accepts it
+func (s *synScanner) ping(ctx context.Context, source Source, ip net.IP, port uint, timeout time.Duration, ack bool) bool {
+	flags, err := s.send(ctx, source, ip, port, timeout, ack)
+	return err == nil && flags != 0
+}
+
+//send sends a SYN, or if ack is set an ACK, to ip:port and returns the flags of the reply, or zero if there was
+//none within timeout. send returns an error if the segment could not be sent, or ctx was cancelled
+func (s *synScanner) send(ctx context.Context, source Source, ip net.IP, port uint, timeout time.Duration, ack bool) (byte, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	local, err := synSource(source, ip, port)
+	if err != nil {
+		return 0, err
+	}
+	key, waiter, err := s.register(source, ip, port, ack)
+	if err != nil {
+		return 0, err
+	}
+	defer s.unregister(key)
+
+	var segment []byte
+	if ack {
+		segment = tcpSegment(local, ip.To4(), key.localPort, key.remotePort, rand.Uint32(), waiter.seq, tcpFlagACK)
+	} else {
+		segment = tcpSegment(local, ip.To4(), key.localPort, key.remotePort, waiter.seq, 0, tcpFlagSYN)
+	}
+	for attempt := 0; attempt < synAttempts; attempt++ {
+		if _, err := s.conn.WriteTo(segment, &net.IPAddr{IP: ip}); err != nil {
+			return 0, err
+		}
+		timer := time.NewTimer(timeout / synAttempts)
+		select {
+		case flags := <-waiter.reply:
+			timer.Stop()
+			return flags, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return 0, nil
+}
+
+//register reserves a source port for a segment to ip:port, from the source profile's range or else s.ports
+func (s *synScanner) register(source Source, ip net.IP, port uint, ack bool) (synKey, synWaiter, error) {
+	from, to := s.ports.From, s.ports.To
+	if source.Ports != (PortRange{}) {
+		from, to = source.Ports.From, source.Ports.To
+	}
+	key := synKey{remotePort: uint16(port)}
+	copy(key.remote[:], ip.To4())
+	waiter := synWaiter{seq: rand.Uint32(), ack: ack, reply: make(chan byte, 1)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < synPortAttempts; i++ {
+		key.localPort = uint16(from + uint(rand.Intn(int(to-from+1))))
+		if _, taken := s.waiting[key]; !taken {
+			s.waiting[key] = waiter
+			return key, waiter, nil
+		}
+	}
+	return synKey{}, synWaiter{}, fmt.Errorf("no free source port in %d-%d", from, to)
+}
+
+func (s *synScanner) unregister(key synKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.waiting, key)
+}
+
+//synPorts returns the source ports of syn scans, the ports above local, the range the system's own connections use,
+//or else those below it down to 1024, so that a reply is not taken for that of a connection of the system's. If
+//neither holds minSynPorts, local is returned, leaving sequence numbers alone to tell replies apart
+func synPorts(local PortRange) PortRange {
+	if local.To+minSynPorts <= 65535 {
+		return PortRange{From: local.To + 1, To: 65535}
+	} else if local.From >= 1024+minSynPorts {
+		return PortRange{From: 1024, To: local.From - 1}
+	}
+	return local
+}
+
+//readLocalPortRange returns the range of localPortRange, or linux's default if it can not be read
+func readLocalPortRange() PortRange {
+	bs, err := ioutil.ReadFile(localPortRange)
+	if err != nil {
+		return PortRange{From: defaultLocalFrom, To: defaultLocalTo}
+	}
+	return parseLocalPortRange(string(bs))
+}
+
+//parseLocalPortRange returns the range of contents in the format of ip_local_port_range, or linux's default if it
+//is not in that format
+func parseLocalPortRange(contents string) PortRange {
+	fields := strings.Fields(contents)
+	if len(fields) == 2 {
+		from, fromErr := strconv.ParseUint(fields[0], 10, 16)
+		to, toErr := strconv.ParseUint(fields[1], 10, 16)
+		if fromErr == nil && toErr == nil && 0 < from && from <= to {
+			return PortRange{From: uint(from), To: uint(to)}
+		}
+	}
+	return PortRange{From: defaultLocalFrom, To: defaultLocalTo}
+}
+
+//synSource returns the address a SYN to ip:port is sent from, the source profile's, or that the routing table chooses
+func synSource(source Source, ip net.IP, port uint) (net.IP, error) {
+	if source.Address != nil {
+		return source.Address.To4(), nil
+	}
+	//connecting a udp socket sends nothing, but has the system choose the route, and with it the source address
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: int(port)})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+//tcpSegment returns a tcp segment with flags from src:srcPort to dst:dstPort. A SYN offers a maximum segment size,
+//as systems do
+func tcpSegment(src, dst net.IP, srcPort, dstPort uint16, seq, ack uint32, flags byte) []byte {
+	segment := make([]byte, 20)
+	if flags&tcpFlagSYN != 0 {
+		//maximum segment size option, 1460 bytes
+		segment = append(segment, 2, 4, 0x05, 0xb4)
+	}
+	binary.BigEndian.PutUint16(segment[0:2], srcPort)
+	binary.BigEndian.PutUint16(segment[2:4], dstPort)
+	binary.BigEndian.PutUint32(segment[4:8], seq)
+	binary.BigEndian.PutUint32(segment[8:12], ack)
+	//data offset in 4 byte words, including options
+	segment[12] = byte(len(segment)/4) << 4
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:16], 64240)
+	binary.BigEndian.PutUint16(segment[16:18], tcpChecksum(src, dst, segment))
+	return segment
+}
+
+//tcpChecksum returns the checksum of segment, over the ipv4 pseudo header and segment
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	pseudo := make([]byte, 0, 12+len(segment))
+	pseudo = append(pseudo, src.To4()...)
+	pseudo = append(pseudo, dst.To4()...)
+	pseudo = append(pseudo, 0, 6, byte(len(segment)>>8), byte(len(segment)))
+	pseudo = append(pseudo, segment...)
+	return checksum(pseudo)
+}
+
+//checksum returns the internet checksum of b, the ones' complement of the ones' complement sum of its 16 bit words
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
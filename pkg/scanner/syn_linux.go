@@ -0,0 +1,4 @@
+package scanner
+
+//synSupported is true where raw tcp sockets receive the replies to the SYNs they send
+const synSupported = true
@@ -0,0 +1,6 @@
+// +build !linux
+
+package scanner
+
+//synSupported is false, as systems other than linux do not pass tcp segments to raw sockets
+const synSupported = false
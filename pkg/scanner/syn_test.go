@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPSegment(t *testing.T) {
+	src, dst := net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.7")
+	segment := tcpSegment(src, dst, 40000, 443, 0xdeadbeef, 0, tcpFlagSYN)
+	assert.Len(t, segment, 24)
+	assert.Equal(t, uint16(40000), binary.BigEndian.Uint16(segment[0:2]))
+	assert.Equal(t, uint16(443), binary.BigEndian.Uint16(segment[2:4]))
+	assert.Equal(t, uint32(0xdeadbeef), binary.BigEndian.Uint32(segment[4:8]))
+	assert.Equal(t, byte(6<<4), segment[12])
+	assert.Equal(t, byte(tcpFlagSYN), segment[13])
+	//a segment carrying its checksum sums to zero
+	assert.Equal(t, uint16(0), tcpChecksum(src, dst, segment))
+
+	segment = tcpSegment(src, dst, 40000, 80, 1, 0xdeadbeef, tcpFlagACK)
+	assert.Len(t, segment, 20)
+	assert.Equal(t, uint32(0xdeadbeef), binary.BigEndian.Uint32(segment[8:12]))
+	assert.Equal(t, byte(5<<4), segment[12])
+	assert.Equal(t, uint16(0), tcpChecksum(src, dst, segment))
+}
+
+func synState(ctx context.Context, syn *synScanner, ip net.IP, port uint, timeout time.Duration) (types.State, bool) {
+	r := Result{IPStatus: types.IPStatus{IP: ip.String()}}
+	ok := syn.getState(ctx, Source{}, ip, port, timeout, &r)
+	return r.State, ok
+}
+
+func TestSynPorts(t *testing.T) {
+	assert.Equal(t, PortRange{From: 32768, To: 60999}, parseLocalPortRange("32768\t60999\n"))
+	assert.Equal(t, PortRange{From: 32768, To: 60999}, parseLocalPortRange("junk"))
+
+	//syn scans are sent from ports the system's own connections do not use
+	assert.Equal(t, PortRange{From: 61000, To: 65535}, synPorts(PortRange{From: 32768, To: 60999}))
+	assert.Equal(t, PortRange{From: 1024, To: 9999}, synPorts(PortRange{From: 10000, To: 65535}))
+	assert.Equal(t, PortRange{From: 1500, To: 65000}, synPorts(PortRange{From: 1500, To: 65000}))
+}
+
+func TestSynScanner(t *testing.T) {
+	syn, err := newSynScanner()
+	if err != nil {
+		t.Skipf("syn scanning is not available: %s", err.Error())
+	}
+	defer syn.close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint(l.Addr().(*net.TCPAddr).Port)
+	state, ok := synState(context.Background(), syn, net.ParseIP("127.0.0.1"), port, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN, state)
+
+	_ = l.Close()
+	state, ok = synState(context.Background(), syn, net.ParseIP("127.0.0.1"), port, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, types.CLOSED, state)
+
+	//a closed port resets a SYN and an ACK alike, either of which shows the host is up
+	assert.True(t, syn.ping(context.Background(), Source{}, net.ParseIP("127.0.0.1"), port, time.Second, false))
+	assert.True(t, syn.ping(context.Background(), Source{}, net.ParseIP("127.0.0.1"), port, time.Second, true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = synState(ctx, syn, net.ParseIP("192.0.2.1"), 443, time.Minute)
+	assert.False(t, ok)
+}
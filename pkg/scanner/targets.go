@@ -0,0 +1,87 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+)
+
+//Expansion is the ips targets expand to
+type Expansion struct {
+	//IPs are in the order their targets were given, without duplicates
+	IPs []string
+	//Targets maps each ip that came from a cidr or hostname to that target
+	Targets map[string]string
+}
+
+//ExpandTargets expands ip addresses, cidrs and hostnames to at most limit ips to probe
+//Hostnames are resolved with r to all of their addresses, both ipv4 and ipv6
+func ExpandTargets(ctx context.Context, r Resolver, targets []string, limit uint) (Expansion, error) {
+	e := Expansion{IPs: make([]string, 0, len(targets)), Targets: make(map[string]string)}
+	seen := make(map[string]bool)
+	add := func(ip, target string) error {
+		if seen[ip] {
+			return nil
+		}
+		if uint(len(e.IPs)) >= limit {
+			return fmt.Errorf("scan requests may contain at most %d ips", limit)
+		}
+		seen[ip] = true
+		e.IPs = append(e.IPs, ip)
+		if ip != target {
+			e.Targets[ip] = target
+		}
+		return nil
+	}
+
+	for _, target := range targets {
+		if ip, zone := pnet.ParseIP(target); ip != nil {
+			//an ip address is its own target, even if not given in canonical form
+			canonical := pnet.FormatIP(ip, zone)
+			if err := add(canonical, canonical); err != nil {
+				return Expansion{}, err
+			}
+		} else if strings.Contains(target, "/") {
+			_, network, err := net.ParseCIDR(target)
+			if err != nil {
+				return Expansion{}, fmt.Errorf("%s is not a valid cidr", target)
+			}
+			ones, bits := network.Mask.Size()
+			//compare prefix lengths first, so an ipv6 /64 is refused without counting its addresses
+			if bits-ones >= 32 || uint64(1)<<uint(bits-ones) > uint64(limit) {
+				return Expansion{}, fmt.Errorf("cidr %s has more than the %d ips a scan request may contain", target, limit)
+			}
+			for ip := network.IP; network.Contains(ip); ip = nextIP(ip) {
+				if err := add(ip.String(), target); err != nil {
+					return Expansion{}, err
+				}
+			}
+		} else {
+			addrs, err := r.LookupIPAddr(ctx, target)
+			if err != nil || len(addrs) == 0 {
+				return Expansion{}, fmt.Errorf("could not resolve %s", target)
+			}
+			for _, addr := range addrs {
+				if err := add(pnet.FormatIP(addr.IP, addr.Zone), target); err != nil {
+					return Expansion{}, err
+				}
+			}
+		}
+	}
+	return e, nil
+}
+
+//nextIP returns the ip after ip, wrapping to all zeros after the last address
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver map[string][]net.IPAddr
+
+func (f fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if addrs, found := f[host]; found {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no such host")
+}
+
+func TestExpandTargets(t *testing.T) {
+	r := fakeResolver{"dual.example.com": {{IP: net.ParseIP("192.0.2.10")}, {IP: net.ParseIP("2001:db8::10")}}}
+	e, err := ExpandTargets(context.Background(), r, []string{
+		"2001:0DB8::0001",
+		"fe80::1%eth0",
+		"192.0.2.0/31",
+		"2001:db8::/127",
+		"dual.example.com",
+		"192.0.2.1",
+	}, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2001:db8::1", "fe80::1%eth0", "192.0.2.0", "192.0.2.1", "2001:db8::", "192.0.2.10", "2001:db8::10"}, e.IPs)
+	assert.Equal(t, map[string]string{
+		"192.0.2.0":    "192.0.2.0/31",
+		"192.0.2.1":    "192.0.2.0/31",
+		"2001:db8::":   "2001:db8::/127",
+		"192.0.2.10":   "dual.example.com",
+		"2001:db8::10": "dual.example.com",
+	}, e.Targets)
+}
+
+func TestExpandTargets_Limits(t *testing.T) {
+	r := fakeResolver{}
+	tests := []struct {
+		targets []string
+		err     string
+	}{
+		{[]string{"2001:db8::/64"}, "cidr 2001:db8::/64 has more than the 16 ips a scan request may contain"},
+		{[]string{"10.0.0.0/27"}, "cidr 10.0.0.0/27 has more than the 16 ips a scan request may contain"},
+		{[]string{"10.0.0.0/28", "10.1.0.1"}, "scan requests may contain at most 16 ips"},
+		{[]string{"nowhere.example.com"}, "could not resolve nowhere.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			_, err := ExpandTargets(context.Background(), r, tt.targets, 16)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	assert.Equal(t, "10.0.1.0", nextIP(net.ParseIP("10.0.0.255").To4()).String())
+	assert.Equal(t, "2001:db8::1:0", nextIP(net.ParseIP("2001:db8::ffff")).String())
+}
+
+func TestGetState_IPv6(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 loopback is not available")
+	}
+	defer l.Close()
+	port := uint(l.Addr().(*net.TCPAddr).Port)
+	status, ok := connect(&net.Dialer{Timeout: time.Second}, "::1", port, nil)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN, status.State)
+}
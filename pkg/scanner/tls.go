@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	pnet "github.com/jbornemann/portscan/internal/net"
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//tlsVersions are the versions offered when finding which a port supports, oldest first
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+//tlsInspector handshakes with an open port, recording its certificate and the tls versions it supports
+//Each version other than the one first negotiated is tried on a connection of its own, dialed with dialer
+type tlsInspector struct {
+	dialer  Dialer
+	timeout time.Duration
+}
+
+func (t tlsInspector) inspect(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+	config := t.config(status.Target)
+	state, ok := t.handshake(ctx, conn, config)
+	if !ok {
+		return
+	}
+	info := &types.TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		info.Certificate = describeCertificate(state.PeerCertificates[0])
+	}
+
+	address := conn.RemoteAddr().String()
+	for _, version := range tlsVersions {
+		supported := version == state.Version
+		if !supported && ctx.Err() == nil {
+			if con, err := t.dialer.DialContext(ctx, "tcp", address); err == nil {
+				only := config.Clone()
+				only.MinVersion, only.MaxVersion = version, version
+				_, supported = t.handshake(ctx, con, only)
+				_ = con.Close()
+			}
+		}
+		if supported {
+			info.Versions = append(info.Versions, tlsVersionName(version))
+		}
+	}
+	status.TLS = info
+}
+
+//config offers every version and cipher suite, weak ones included, as the aim is to learn what the port accepts
+//A target that is a hostname is sent as the server name
+func (t tlsInspector) config(target string) *tls.Config {
+	suites := make([]uint16, 0)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites = append(suites, s.ID)
+	}
+	config := &tls.Config{
+		//the certificate is recorded, not trusted, so that expired and self signed certificates are reported too
+		InsecureSkipVerify: true,
+		MinVersion:         tlsVersions[0],
+		MaxVersion:         tlsVersions[len(tlsVersions)-1],
+		CipherSuites:       suites,
+	}
+	if pnet.ValidHostname(target) {
+		config.ServerName = target
+	}
+	return config
+}
+
+//handshake returns the state of a tls handshake on conn, and false if one could not be completed within timeout
+func (t tlsInspector) handshake(ctx context.Context, conn net.Conn, config *tls.Config) (tls.ConnectionState, bool) {
+	deadline := time.Now().Add(t.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	client := tls.Client(conn, config)
+	if err := client.Handshake(); err != nil {
+		return tls.ConnectionState{}, false
+	}
+	return client.ConnectionState(), true
+}
+
+func tlsVersionName(version uint16) string {
+	if name, found := tlsVersionNames[version]; found {
+		return name
+	}
+	return "unknown"
+}
+
+func describeCertificate(cert *x509.Certificate) *types.Certificate {
+	fingerprint := sha256.Sum256(cert.Raw)
+	c := &types.Certificate{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SelfSigned:         bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil,
+		Fingerprint:        hex.EncodeToString(fingerprint[:]),
+	}
+	c.SANs = append(c.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+	c.SANs = append(c.SANs, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		c.SANs = append(c.SANs, uri.String())
+	}
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		c.KeyType, c.KeyBits = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		c.KeyType, c.KeyBits = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		c.KeyType, c.KeyBits = "Ed25519", 256
+	default:
+		c.KeyType = strings.TrimPrefix(cert.PublicKeyAlgorithm.String(), "x509.")
+	}
+	return c
+}
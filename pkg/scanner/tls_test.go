@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//tlsService serves https on a local port, negotiating only versions min to max
+func tlsService(t *testing.T, min, max uint16) uint {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.TLS = &tls.Config{MinVersion: min, MaxVersion: max}
+	//refused handshakes are expected, and need not be logged
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	u, _ := url.Parse(s.URL)
+	port, _ := strconv.ParseUint(u.Port(), 10, 16)
+	return uint(port)
+}
+
+func TestTLSInspector(t *testing.T) {
+	port := tlsService(t, tls.VersionTLS12, tls.VersionTLS13)
+	dialer := &net.Dialer{Timeout: time.Second}
+	inspector := tlsInspector{dialer: dialer, timeout: 2 * time.Second}
+	status, ok := connect(dialer, "127.0.0.1", port, inspector.inspect)
+	assert.True(t, ok)
+	if assert.NotNil(t, status.TLS) {
+		assert.Equal(t, "TLS 1.3", status.TLS.Version)
+		assert.NotEmpty(t, status.TLS.CipherSuite)
+		assert.Equal(t, []string{"TLS 1.2", "TLS 1.3"}, status.TLS.Versions)
+		cert := status.TLS.Certificate
+		if assert.NotNil(t, cert) {
+			assert.Equal(t, "O=Acme Co", cert.Subject)
+			assert.Contains(t, cert.SANs, "example.com")
+			assert.Contains(t, cert.SANs, "127.0.0.1")
+			assert.Equal(t, "RSA", cert.KeyType)
+			assert.True(t, cert.NotAfter.After(cert.NotBefore))
+			assert.Len(t, cert.Fingerprint, 64)
+		}
+	}
+}
+
+func TestTLSInspector_NotTLS(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1\r\n"))
+	})
+	dialer := &net.Dialer{Timeout: time.Second}
+	inspector := tlsInspector{dialer: dialer, timeout: time.Second}
+	status, ok := connect(dialer, "127.0.0.1", port, inspector.inspect)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN, status.State)
+	assert.Nil(t, status.TLS)
+}
+
+func TestTLSInspector_ServerName(t *testing.T) {
+	inspector := tlsInspector{}
+	assert.Equal(t, "www.example.com", inspector.config("www.example.com").ServerName)
+	assert.Equal(t, "", inspector.config("192.0.2.0/30").ServerName)
+	assert.Equal(t, "", inspector.config("").ServerName)
+}
+
+func TestChainInspectors(t *testing.T) {
+	port := tcpService(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("220 ready\r\n"))
+	})
+	dialer := &net.Dialer{Timeout: time.Second}
+	conns := make(map[string]bool)
+	record := func(ctx context.Context, conn net.Conn, status *types.IPStatus) {
+		conns[conn.LocalAddr().String()] = true
+		status.Banner += "x"
+	}
+	status, ok := connect(dialer, "127.0.0.1", port, chainInspectors(dialer, record, record, record))
+	assert.True(t, ok)
+	assert.Equal(t, "xxx", status.Banner)
+	assert.Len(t, conns, 3)
+}
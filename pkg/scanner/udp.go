@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+)
+
+//udpAttempts is how many times a payload is sent, as either it or its reply may be lost
+const udpAttempts = 2
+
+//udpPayloads are datagrams that provoke a reply from the service commonly found on a port
+//Ports not listed are sent an empty datagram, which few services answer
+var udpPayloads = map[uint][]byte{
+	//dns, a standard query for the root name servers
+	53: dnsQuery,
+	//ntp, a version 3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	//netbios name service, a node status request
+	137: []byte("\x80\xf0\x00\x10\x00\x01\x00\x00\x00\x00\x00\x00\x20CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\x00\x00\x21\x00\x01"),
+	//snmp, a v1 get-request of sysDescr.0 with the public community
+	161: []byte("\x30\x26\x02\x01\x00\x04\x06public\xa0\x19\x02\x01\x01\x02\x01\x00\x02\x01\x00\x30\x0e\x30\x0c\x06\x08\x2b\x06\x01\x02\x01\x01\x01\x00\x05\x00"),
+	//ssdp, a discovery request for all services
+	1900: []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"),
+	//mdns, answered by responders that accept unicast queries
+	5353: dnsQuery,
+	//memcached, a stats request with the udp frame header
+	11211: []byte("\x00\x01\x00\x00\x00\x01\x00\x00stats\r\n"),
+}
+
+var dnsQuery = []byte("\x13\x37\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x01")
+
+//getUDPState records the State of udp r.IP:port on r, and returns false if ctx was cancelled before it was known
+//A reply is open, and an icmp port unreachable, reported as a refused connection, is closed. As services
+//may ignore the payload sent, and firewalls drop datagrams silently, no reply within timeout is open|filtered
+func getUDPState(ctx context.Context, dialer Dialer, port uint, timeout time.Duration, r *Result) bool {
+	con, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(r.IP, strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		r.State, r.Err = types.CLOSED, err
+		return true
+	}
+	defer con.Close()
+	//unblock the read below if ctx is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = con.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	payload := udpPayloads[port]
+	buf := make([]byte, 1)
+	for attempt := 0; attempt < udpAttempts; attempt++ {
+		_ = con.SetReadDeadline(time.Now().Add(timeout / udpAttempts))
+		if _, err = con.Write(payload); err == nil {
+			_, err = con.Read(buf)
+		}
+		if ctx.Err() != nil {
+			return false
+		} else if err == nil {
+			r.State = types.OPEN
+			return true
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			r.State, r.Err = types.CLOSED, err
+			return true
+		}
+	}
+	r.State, r.Err = types.OPEN_FILTERED, err
+	return true
+}
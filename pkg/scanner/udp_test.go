@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jbornemann/portscan/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//udpService listens on a local udp port, answering each datagram if reply is true
+func udpService(t *testing.T, reply bool) (uint, <-chan []byte) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	received := make(chan []byte, 4)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- append([]byte(nil), buf[:n]...)
+			if reply {
+				_, _ = conn.WriteTo([]byte("hello"), from)
+			}
+		}
+	}()
+	return uint(conn.LocalAddr().(*net.UDPAddr).Port), received
+}
+
+func udpState(ctx context.Context, dialer Dialer, ip string, port uint, timeout time.Duration) (types.State, bool) {
+	r := Result{IPStatus: types.IPStatus{IP: ip}}
+	ok := getUDPState(ctx, dialer, port, timeout, &r)
+	return r.State, ok
+}
+
+func TestGetUDPState(t *testing.T) {
+	dialer := &net.Dialer{Timeout: time.Second}
+
+	open, received := udpService(t, true)
+	state, ok := udpState(context.Background(), dialer, "127.0.0.1", open, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN, state)
+	assert.Empty(t, <-received, "ports without a known payload are sent an empty datagram")
+
+	silent, _ := udpService(t, false)
+	state, ok = udpState(context.Background(), dialer, "127.0.0.1", silent, 100*time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, types.OPEN_FILTERED, state)
+
+	//a port nothing listens on is answered with icmp port unreachable
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := uint(conn.LocalAddr().(*net.UDPAddr).Port)
+	_ = conn.Close()
+	state, ok = udpState(context.Background(), dialer, "127.0.0.1", closed, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, types.CLOSED, state)
+}
+
+func TestGetUDPState_Interrupted(t *testing.T) {
+	silent, _ := udpService(t, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, ok := udpState(ctx, &net.Dialer{}, "127.0.0.1", silent, 10*time.Second)
+	assert.False(t, ok)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestUDPPayloads(t *testing.T) {
+	//snmp and dns payloads carry their own lengths, check they agree with the bytes sent
+	snmp := udpPayloads[161]
+	assert.Equal(t, len(snmp), int(snmp[1])+2)
+	assert.Equal(t, 48, len(udpPayloads[123]))
+	assert.Equal(t, byte(1), dnsQuery[5], "one question")
+	assert.Equal(t, 12+1+4, len(dnsQuery))
+}
@@ -0,0 +1,54 @@
+package service
+
+//Exchange sends payload to a service on a new connection, and returns what it replied
+type Exchange func(payload []byte) ([]byte, error)
+
+//Detect identifies the tcp service on port. greeting is what the service sent on connect, unprompted, and is
+//matched against probes without a payload. If that gives no hard match, up to maxProbes probes are sent with
+//exchange, those listing port before those for every port. The first hard match is returned, or failing that the
+//first soft match
+func (db *Database) Detect(port uint, greeting []byte, exchange Exchange, maxProbes int) (Result, bool) {
+	var best *Result
+	//consider records a match of response, returning true if it is a hard match
+	consider := func(p Probe, response []byte) bool {
+		result, found := p.Match(response)
+		if found && (best == nil || !result.Soft) {
+			best = &result
+		}
+		return found && !result.Soft
+	}
+
+	for _, p := range db.Probes {
+		if p.Protocol == "tcp" && len(p.Payload) == 0 && len(greeting) > 0 && consider(p, greeting) {
+			return *best, true
+		}
+	}
+	sent := 0
+	for _, specific := range []bool{true, false} {
+		for _, p := range db.Probes {
+			if sent >= maxProbes {
+				break
+			} else if p.Protocol != "tcp" || len(p.Payload) == 0 || (len(p.Ports) > 0) != specific || !p.AppliesTo(port) {
+				continue
+			}
+			sent++
+			response, err := exchange(p.Payload)
+			if err == nil && len(response) > 0 && consider(p, response) {
+				return *best, true
+			}
+		}
+	}
+	if best != nil {
+		return *best, true
+	}
+	return Result{}, false
+}
+
+//Combine returns a Database of the probes of each of dbs, in order
+func Combine(dbs ...*Database) *Database {
+	combined := &Database{}
+	for _, db := range dbs {
+		combined.Probes = append(combined.Probes, db.Probes...)
+	}
+	return combined
+}
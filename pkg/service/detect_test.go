@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	db, err := Parse(strings.NewReader(`
+Probe TCP NULL q||
+match ssh m|^SSH-2.0-(\S+)| p/$1/
+Probe TCP Any q|any|
+softmatch thing m|^any|
+Probe TCP Specific q|specific|
+ports 99
+match thing m|^specific ([\w.]+)| v/$1/
+`))
+	assert.Nil(t, err)
+
+	var sent []string
+	exchange := func(payload []byte) ([]byte, error) {
+		sent = append(sent, string(payload))
+		return []byte(string(payload) + " 1.0"), nil
+	}
+
+	result, found := db.Detect(22, []byte("SSH-2.0-OpenSSH_8.2\r\n"), exchange, 4)
+	assert.True(t, found)
+	assert.Equal(t, Result{Service: "ssh", Product: "OpenSSH_8.2"}, result)
+	assert.Empty(t, sent)
+
+	result, found = db.Detect(99, nil, exchange, 4)
+	assert.True(t, found)
+	assert.Equal(t, Result{Service: "thing", Version: "1.0"}, result)
+	assert.Equal(t, []string{"specific"}, sent)
+
+	sent = nil
+	result, found = db.Detect(100, nil, exchange, 4)
+	assert.True(t, found)
+	assert.Equal(t, Result{Service: "thing", Soft: true}, result)
+	assert.Equal(t, []string{"any"}, sent)
+
+	sent = nil
+	_, found = db.Detect(100, nil, exchange, 0)
+	assert.False(t, found)
+	assert.Empty(t, sent)
+
+	_, found = db.Detect(100, nil, func([]byte) ([]byte, error) { return nil, fmt.Errorf("refused") }, 4)
+	assert.False(t, found)
+}
+
+func TestDefault(t *testing.T) {
+	noReply := func([]byte) ([]byte, error) { return nil, fmt.Errorf("no reply") }
+	reply := func(response string) Exchange {
+		return func([]byte) ([]byte, error) { return []byte(response), nil }
+	}
+	tests := []struct {
+		port     uint
+		greeting string
+		exchange Exchange
+		result   Result
+	}{
+		{22, "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1\r\n", noReply, Result{Service: "ssh", Product: "OpenSSH", Version: "8.9p1", Info: "protocol 2.0"}},
+		{21, "220 (vsFTPd 3.0.3)\r\n", noReply, Result{Service: "ftp", Product: "vsftpd", Version: "3.0.3"}},
+		{25, "220 mail.example.com ESMTP Postfix (Ubuntu)\r\n", noReply, Result{Service: "smtp", Product: "Postfix smtpd", Info: "host mail.example.com"}},
+		{2525, "220 mail.example.com ESMTP ready\r\n", noReply, Result{Service: "smtp", Soft: true}},
+		{3306, "\x4a\x00\x00\x00\x0a8.0.32\x00\x08\x00\x00\x00", noReply, Result{Service: "mysql", Product: "MySQL", Version: "8.0.32"}},
+		{3306, "\x4a\x00\x00\x00\x0a5.5.5-10.6.12-MariaDB-0ubuntu0.22.04.1\x00", noReply, Result{Service: "mysql", Product: "MariaDB", Version: "5.5.5"}},
+		{5900, "RFB 003.008\n", noReply, Result{Service: "vnc", Product: "VNC", Info: "protocol 003.008"}},
+		{80, "", reply("HTTP/1.1 200 OK\r\nDate: x\r\nServer: nginx/1.18.0 (Ubuntu)\r\n\r\n"), Result{Service: "http", Product: "nginx", Version: "1.18.0"}},
+		{8080, "", reply("HTTP/1.1 404 Not Found\r\nServer: Apache/2.4.57 (Debian)\r\n\r\n"), Result{Service: "http", Product: "Apache httpd", Version: "2.4.57", Info: "Debian"}},
+		{9000, "", reply("HTTP/1.0 400 Bad Request\r\nServer: gunicorn\r\n\r\n"), Result{Service: "http", Product: "gunicorn"}},
+		{6379, "", reply("$100\r\n# Server\r\nredis_version:7.0.11\r\nredis_git_sha1:0\r\n"), Result{Service: "redis", Product: "Redis key-value store", Version: "7.0.11"}},
+		{11211, "", reply("VERSION 1.6.21\r\n"), Result{Service: "memcached", Product: "Memcached", Version: "1.6.21"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %s", tt.port, tt.result.Product), func(t *testing.T) {
+			result, found := Default().Detect(tt.port, []byte(tt.greeting), tt.exchange, 4)
+			assert.True(t, found)
+			assert.Equal(t, tt.result, result)
+		})
+	}
+}
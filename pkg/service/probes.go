@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Default returns the probe database built in to pscan
+func Default() *Database {
+	return defaultDatabase
+}
+
+var defaultDatabase = mustParse(defaultProbes)
+
+func mustParse(probes string) *Database {
+	db, err := Parse(strings.NewReader(probes))
+	if err != nil {
+		panic(fmt.Sprintf("built in service probes are not valid: %s", err.Error()))
+	}
+	return db
+}
+
+//defaultProbes is the built in probe database, in the format read by Parse
+const defaultProbes = `
+# The NULL probe sends nothing, and matches what a service says on connect
+Probe TCP NULL q||
+match ssh m|^SSH-([\d.]+)-OpenSSH[_-]([\w.]+)| p/OpenSSH/ v/$2/ i/protocol $1/
+match ssh m|^SSH-([\d.]+)-dropbear[_-]?([\w.]*)| p/Dropbear sshd/ v/$2/ i/protocol $1/
+match ssh m|^SSH-([\d.]+)-([^\s]+)| p/$2/ i/protocol $1/
+match ftp m|^220[ -]\(vsFTPd ([\w.]+)\)| p/vsftpd/ v/$1/
+match ftp m|^220[ -]ProFTPD ([\w.]+)| p/ProFTPD/ v/$1/
+match ftp m|^220[ -][^\r\n]*Pure-FTPd| p/Pure-FTPd/
+match ftp m|^220[ -][^\r\n]*FileZilla Server(?: version)? ([\w.]+)|i p/FileZilla ftpd/ v/$1/
+match smtp m|^220[ -]([^\s]+) ESMTP Postfix| p/Postfix smtpd/ i/host $1/
+match smtp m|^220[ -]([^\s]+) ESMTP Exim ([\w.]+)| p/Exim smtpd/ v/$2/ i/host $1/
+match smtp m|^220[ -]([^\s]+) [^\r\n]*Microsoft ESMTP MAIL Service| p/Microsoft Exchange smtpd/ i/host $1/
+softmatch smtp m|^220[ -][^\r\n]*SMTP|i
+softmatch ftp m|^220[ -][^\r\n]*FTP|i
+match pop3 m|^\+OK [^\r\n]*Dovecot| p/Dovecot pop3d/
+softmatch pop3 m|^\+OK |
+match imap m|^\* OK [^\r\n]*Dovecot| p/Dovecot imapd/
+softmatch imap m|^\* OK [^\r\n]*IMAP|i
+match mysql m|^.\x00\x00\x00\x0a([\d.]+)-([\d.]+-)?MariaDB|s p/MariaDB/ v/$1/
+match mysql m|^.\x00\x00\x00\x0a(\d[\w.-]*)\x00|s p/MySQL/ v/$1/
+match mysql m|^.\x00\x00\x00\xffj\x04Host '[^']*' is not allowed|s p/MySQL/ i/host not allowed/
+match vnc m|^RFB (\d{3})\.(\d{3})\n| p/VNC/ i/protocol $1.$2/
+softmatch telnet m|^\xff[\xfb-\xfe]|
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+ports 80,591,3000,5000,8000,8008,8080,8081,8888,9200
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: nginx/([\d.]+)|s p/nginx/ v/$1/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: nginx\r\n|s p/nginx/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: Apache/([\d.]+)(?: \(([^)\r\n]+)\))?|s p/Apache httpd/ v/$1/ i/$2/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: Microsoft-IIS/([\d.]+)|s p/Microsoft IIS httpd/ v/$1/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: lighttpd/([\d.]+)|s p/lighttpd/ v/$1/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: Caddy\r\n|s p/Caddy httpd/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: ([^\r\n]+)|s p/$1/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n\r\n\{\s*"name"[^}]*"cluster_name"[^}]*"version"\s*:\s*\{\s*"number"\s*:\s*"([\w.]+)"|s p/Elasticsearch/ v/$1/
+softmatch http m|^HTTP/1\.[01] \d\d\d|
+
+Probe TCP redis q|INFO server\r\n|
+ports 6379
+match redis m|^\$\d+\r\n# Server\r\nredis_version:([\w.]+)\r\n|s p/Redis key-value store/ v/$1/
+match redis m|^-NOAUTH | p/Redis key-value store/ i/authentication required/
+
+Probe TCP memcached q|version\r\n|
+ports 11211
+match memcached m|^VERSION ([\w.-]+)\r\n| p/Memcached/ v/$1/
+
+# GenericLines is tried on any port, and provokes a reply from most line based services
+Probe TCP GenericLines q|\r\n\r\n|
+match http m|^HTTP/1\.[01] \d\d\d.*\r\n[Ss]erver: ([^\r\n]+)|s p/$1/
+softmatch http m|^HTTP/1\.[01] \d\d\d|
+match redis m|^-ERR unknown command| p/Redis key-value store/
+match memcached m|^ERROR\r\n| p/Memcached/
+`
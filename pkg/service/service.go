@@ -0,0 +1,331 @@
+//Package service identifies the service, product and version behind an open port, by sending probes and matching
+//responses against a declarative database in the spirit of nmap-service-probes
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Database is an ordered list of probes. Probes are tried in order, and the matches of a probe in order
+type Database struct {
+	Probes []Probe
+}
+
+//Probe is a payload to send, and the matches identifying services from the response
+type Probe struct {
+	Protocol string
+	Name     string
+	//Payload is sent on connect. The NULL probe has an empty payload, and only reads what the service sends
+	Payload []byte
+	//Ports are the ports this probe is tried on. Empty tries it on every port
+	Ports   []PortRange
+	Matches []Match
+}
+
+//PortRange is an inclusive range of ports
+type PortRange struct {
+	From uint
+	To   uint
+}
+
+//Match identifies a service from a response. A soft match names the service, but not its product or version
+type Match struct {
+	Service string
+	Soft    bool
+	Pattern *regexp.Regexp
+	//Product, Version and Info are templates, where $1 to $9 are replaced with groups of Pattern
+	Product string
+	Version string
+	Info    string
+}
+
+//Result is what a Match found
+type Result struct {
+	Service string
+	Product string
+	Version string
+	Info    string
+	Soft    bool
+}
+
+//AppliesTo returns true if p should be tried on port
+func (p Probe) AppliesTo(port uint) bool {
+	if len(p.Ports) == 0 {
+		return true
+	}
+	for _, r := range p.Ports {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+//Match returns the first hard match of response, or if there is none, the first soft match
+//Each byte of response is matched as the rune of the same value, so that \xNN in a pattern matches byte NN
+func (p Probe) Match(response []byte) (Result, bool) {
+	text := latin1(response)
+	var soft *Result
+	for _, m := range p.Matches {
+		groups := m.Pattern.FindStringSubmatch(text)
+		if groups == nil {
+			continue
+		}
+		result := Result{
+			Service: m.Service,
+			Product: expand(m.Product, groups),
+			Version: expand(m.Version, groups),
+			Info:    expand(m.Info, groups),
+			Soft:    m.Soft,
+		}
+		if !m.Soft {
+			return result, true
+		} else if soft == nil {
+			soft = &result
+		}
+	}
+	if soft != nil {
+		return *soft, true
+	}
+	return Result{}, false
+}
+
+//latin1 returns bs as a string of the runes with the values of its bytes
+func latin1(bs []byte) string {
+	runes := make([]rune, len(bs))
+	for i, b := range bs {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+//expand replaces $1 to $9 in template with groups, keeping only printable ascii of what was matched
+func expand(template string, groups []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] == '$' && i+1 < len(template) && template[i+1] >= '1' && template[i+1] <= '9' {
+			if n := int(template[i+1] - '0'); n < len(groups) {
+				for _, r := range groups[n] {
+					if r >= 0x20 && r < 0x7f {
+						sb.WriteRune(r)
+					}
+				}
+			}
+			i++
+			continue
+		}
+		sb.WriteByte(template[i])
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+//Parse reads a probe database. Each line is blank, a # comment, or one of
+//  Probe <TCP|UDP> <name> q|<payload>|
+//  ports <port or range>,...
+//  match <service> m|<regex>|[i][s] [p/<product>/] [v/<version>/] [i/<info>/]
+//  softmatch <service> m|<regex>|[i][s]
+//Any character may delimit the payload, regex and templates. Payloads may use \r \n \t \0 \\ and \xNN escapes
+//Patterns are go regular expressions, so unlike nmap they may not use backreferences or lookarounds
+func Parse(r io.Reader) (*Database, error) {
+	db := &Database{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		directive, rest := splitWord(text)
+		var err error
+		if directive == "Probe" {
+			var probe *Probe
+			if probe, err = parseProbe(rest); err == nil {
+				db.Probes = append(db.Probes, *probe)
+			}
+		} else if len(db.Probes) == 0 {
+			err = fmt.Errorf("%s before the first Probe", directive)
+		} else {
+			probe := &db.Probes[len(db.Probes)-1]
+			switch directive {
+			case "ports":
+				probe.Ports, err = parsePorts(rest)
+			case "match", "softmatch":
+				var m *Match
+				if m, err = parseMatch(rest, directive == "softmatch"); err == nil {
+					probe.Matches = append(probe.Matches, *m)
+				}
+			default:
+				err = fmt.Errorf("unknown directive %s", directive)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func parseProbe(s string) (*Probe, error) {
+	protocol, rest := splitWord(s)
+	name, rest := splitWord(rest)
+	if protocol != "TCP" && protocol != "UDP" {
+		return nil, fmt.Errorf("probe protocol must be TCP or UDP")
+	} else if len(name) == 0 {
+		return nil, fmt.Errorf("probe must have a name")
+	} else if !strings.HasPrefix(rest, "q") {
+		return nil, fmt.Errorf("probe %s must have a q|payload|", name)
+	}
+	payload, rest, err := delimited(rest[1:])
+	if err != nil {
+		return nil, fmt.Errorf("probe %s payload: %s", name, err.Error())
+	} else if len(strings.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("probe %s has unexpected %q", name, rest)
+	}
+	unescaped, err := unescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("probe %s payload: %s", name, err.Error())
+	}
+	return &Probe{Protocol: strings.ToLower(protocol), Name: name, Payload: unescaped}, nil
+}
+
+func parsePorts(s string) ([]PortRange, error) {
+	ranges := make([]PortRange, 0)
+	for _, p := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(p), "-", 2)
+		from, err := strconv.ParseUint(bounds[0], 10, 16)
+		to := from
+		if err == nil && len(bounds) == 2 {
+			to, err = strconv.ParseUint(bounds[1], 10, 16)
+		}
+		if err != nil || from == 0 || to < from {
+			return nil, fmt.Errorf("%s is not a valid port or port range", p)
+		}
+		ranges = append(ranges, PortRange{From: uint(from), To: uint(to)})
+	}
+	return ranges, nil
+}
+
+func parseMatch(s string, soft bool) (*Match, error) {
+	name, rest := splitWord(s)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("match must name a service")
+	} else if !strings.HasPrefix(rest, "m") {
+		return nil, fmt.Errorf("match %s must have a m|regex|", name)
+	}
+	pattern, rest, err := delimited(rest[1:])
+	if err != nil {
+		return nil, fmt.Errorf("match %s regex: %s", name, err.Error())
+	}
+	//flags follow the closing delimiter directly
+	end := strings.IndexAny(rest, " \t")
+	if end < 0 {
+		end = len(rest)
+	}
+	flags, rest := rest[:end], rest[end:]
+	goFlags := "(?-s)"
+	for _, f := range flags {
+		switch f {
+		case 'i':
+			goFlags += "(?i)"
+		case 's':
+			goFlags += "(?s)"
+		default:
+			return nil, fmt.Errorf("match %s has unknown regex flag %c", name, f)
+		}
+	}
+	re, err := regexp.Compile(goFlags + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("match %s regex is not valid: %s", name, err.Error())
+	}
+	m := &Match{Service: name, Soft: soft, Pattern: re}
+	for rest = strings.TrimSpace(rest); len(rest) > 0; rest = strings.TrimSpace(rest) {
+		field := rest[0]
+		var value string
+		if value, rest, err = delimited(rest[1:]); err != nil {
+			return nil, fmt.Errorf("match %s field %c: %s", name, field, err.Error())
+		}
+		switch field {
+		case 'p':
+			m.Product = value
+		case 'v':
+			m.Version = value
+		case 'i':
+			m.Info = value
+		default:
+			return nil, fmt.Errorf("match %s has unknown field %c", name, field)
+		}
+	}
+	if soft && (len(m.Product) > 0 || len(m.Version) > 0) {
+		return nil, fmt.Errorf("softmatch %s may not give a product or version", name)
+	}
+	return m, nil
+}
+
+//splitWord returns the first space separated word of s, and the rest of s
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+//delimited returns the text between the first character of s and its next occurrence, and what follows
+func delimited(s string) (string, string, error) {
+	if len(s) == 0 {
+		return "", "", fmt.Errorf("missing delimiter")
+	}
+	end := strings.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return "", "", fmt.Errorf("missing closing %c", s[0])
+	}
+	return s[1 : end+1], s[end+2:], nil
+}
+
+func unescape(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i++; i >= len(s) {
+			return nil, fmt.Errorf("trailing \\")
+		}
+		switch s[i] {
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		case 't':
+			buf.WriteByte('\t')
+		case '0':
+			buf.WriteByte(0)
+		case '\\':
+			buf.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("short \\x escape")
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("\\x%s is not a valid escape", s[i+1:i+3])
+			}
+			buf.WriteByte(byte(b))
+			i += 2
+		default:
+			return nil, fmt.Errorf("\\%c is not a valid escape", s[i])
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(`
+# a comment
+Probe TCP Hello q|HELO x\r\n\x00|
+ports 25,2525-2530
+match smtp m=^250 (\S+) (hello|hi)=i p/Thing/ v/$1/ i/said $2/
+softmatch smtp m|^250|
+`))
+	assert.Nil(t, err)
+	assert.Len(t, db.Probes, 1)
+	p := db.Probes[0]
+	assert.Equal(t, "tcp", p.Protocol)
+	assert.Equal(t, "Hello", p.Name)
+	assert.Equal(t, []byte("HELO x\r\n\x00"), p.Payload)
+	assert.Equal(t, []PortRange{{25, 25}, {2525, 2530}}, p.Ports)
+	assert.True(t, p.AppliesTo(2527))
+	assert.False(t, p.AppliesTo(2531))
+
+	result, found := p.Match([]byte("250 mail.example.com HELLO\r\n"))
+	assert.True(t, found)
+	assert.Equal(t, Result{Service: "smtp", Product: "Thing", Version: "mail.example.com", Info: "said HELLO"}, result)
+	result, found = p.Match([]byte("250\r\n"))
+	assert.True(t, found)
+	assert.Equal(t, Result{Service: "smtp", Soft: true}, result)
+	_, found = p.Match([]byte("500 no\r\n"))
+	assert.False(t, found)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		db  string
+		err string
+	}{
+		{"match ssh m|^SSH|", "line 1: match before the first Probe"},
+		{"Probe SCTP x q||", "line 1: probe protocol must be TCP or UDP"},
+		{"Probe TCP x", "line 1: probe x must have a q|payload|"},
+		{"Probe TCP x q|abc", "line 1: probe x payload: missing closing |"},
+		{`Probe TCP x q|\q|`, `line 1: probe x payload: \q is not a valid escape`},
+		{"Probe TCP x q||\nports 0", "line 2: 0 is not a valid port or port range"},
+		{"Probe TCP x q||\nports 90-80", "line 2: 90-80 is not a valid port or port range"},
+		{"Probe TCP x q||\nmatch ssh m|(|", "line 2: match ssh regex is not valid: error parsing regexp: missing closing ): `(?-s)(`"},
+		{"Probe TCP x q||\nmatch ssh m|x|z", "line 2: match ssh has unknown regex flag z"},
+		{"Probe TCP x q||\nmatch ssh m|x| d/y/", "line 2: match ssh has unknown field d"},
+		{"Probe TCP x q||\nsoftmatch ssh m|x| p/y/", "line 2: softmatch ssh may not give a product or version"},
+		{"Probe TCP x q||\nrarity 3", "line 2: unknown directive rarity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.db))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestMatch_Binary(t *testing.T) {
+	db, err := Parse(strings.NewReader("Probe TCP NULL q||\nmatch thing m|^\\xff\\x00(\\w+)\\xfe| p/$1/"))
+	assert.Nil(t, err)
+	result, found := db.Probes[0].Match([]byte("\xff\x00abc\xfe"))
+	assert.True(t, found)
+	assert.Equal(t, "abc", result.Product)
+}
@@ -1,19 +1,69 @@
 package types
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/jbornemann/portscan/internal/cron"
 	pnet "github.com/jbornemann/portscan/internal/net"
 )
 
 //ScanRequest represents a group of interesting IPs, and a port to scan
 type ScanRequest struct {
+	//ScanIPs are ip addresses (for ipv6, optionally with a zone e.g fe80::1%eth0), cidrs, or fully qualified hostnames
+	//A hostname is scanned on every address it resolves to, ipv4 and ipv6
 	ScanIPs  []string `json:"ips"`
 	ScanPort uint     `json:"port"`
+	//Protocol is TCP or UDP. Empty scans TCP
+	Protocol string `json:"protocol,omitempty"`
+	//Technique is how tcp ports are probed, TechniqueConnect or TechniqueSYN. Empty connects
+	Technique string `json:"technique,omitempty"`
+	//Source optionally names a source profile configured on the server, to scan from
+	Source string `json:"source,omitempty"`
+	//Banner optionally records what open tcp ports send on connect, BannerPassive or BannerActive
+	Banner string `json:"banner,omitempty"`
+	//Detect identifies the service, product and version on open tcp ports
+	Detect bool `json:"detect,omitempty"`
+	//TLS records the certificate and protocol versions of open tcp ports that speak tls
+	TLS bool `json:"tls,omitempty"`
+	//HTTP fingerprints open tcp ports that speak http or https
+	HTTP bool `json:"http,omitempty"`
+	//Discover pings each ip before its port is probed, and skips the port of hosts that do not answer
+	Discover bool `json:"discover,omitempty"`
+	//ProbeDown probes the port of hosts discovery found down, rather than skipping it
+	ProbeDown bool `json:"probe_down,omitempty"`
+	//Probe optionally names a prober registered with the server, which checks the port in place of a connect or udp
+	//probe, e.g a proprietary handshake. It can not be combined with syn scans, banners, detection, tls or http
+	Probe string `json:"probe,omitempty"`
+	//Scripts optionally name executables installed in the script directory of the server, run in turn against each
+	//open port, and whose json output is recorded on its status
+	Scripts []string `json:"scripts,omitempty"`
 }
 
+const (
+	//BannerPassive reads what a service sends on connect
+	BannerPassive = "passive"
+	//BannerActive also prompts services that wait for the client to speak first
+	BannerActive = "active"
+)
+
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
+const (
+	//TechniqueConnect completes a handshake with each port
+	TechniqueConnect = "connect"
+	//TechniqueSYN sends a SYN, and reads the state of a port from the reply without completing a handshake
+	//Servers that can not send SYNs connect instead
+	TechniqueSYN = "syn"
+)
+
 //Validate will validate that the ScanRequest is valid, e.g that ips are indeed ip addresses
 //Validate will return an error detailing what is wrong if validation fails
 func (s ScanRequest) Validate() (bool, error) {
@@ -22,9 +72,13 @@ func (s ScanRequest) Validate() (bool, error) {
 	if s.ScanIPs == nil {
 		messages = append(messages, "you must provide a list of ips")
 	} else {
-		for _, ip := range s.ScanIPs {
-			if net.ParseIP(ip) == nil {
-				messages = append(messages, fmt.Sprintf("%s is not a valid ip address", ip))
+		for _, target := range s.ScanIPs {
+			if strings.Contains(target, "/") {
+				if _, _, err := net.ParseCIDR(target); err != nil {
+					messages = append(messages, fmt.Sprintf("%s is not a valid cidr", target))
+				}
+			} else if ip, _ := pnet.ParseIP(target); ip == nil && !pnet.ValidHostname(target) {
+				messages = append(messages, fmt.Sprintf("%s is not a valid ip address, cidr or hostname", target))
 			}
 		}
 	}
@@ -33,29 +87,371 @@ func (s ScanRequest) Validate() (bool, error) {
 		messages = append(messages, fmt.Sprintf("%d is not a valid port number", s.ScanPort))
 	}
 
+	if s.Protocol != "" && s.Protocol != TCP && s.Protocol != UDP {
+		messages = append(messages, fmt.Sprintf("%s is not a supported protocol, use tcp or udp", s.Protocol))
+	}
+
+	if s.Technique != "" && s.Technique != TechniqueConnect && s.Technique != TechniqueSYN {
+		messages = append(messages, fmt.Sprintf("%s is not a scan technique, use connect or syn", s.Technique))
+	} else if s.Technique == TechniqueSYN && s.Protocol == UDP {
+		messages = append(messages, "syn scans can only be made of tcp ports")
+	}
+
+	if s.Banner != "" && s.Banner != BannerPassive && s.Banner != BannerActive {
+		messages = append(messages, fmt.Sprintf("%s is not a banner mode, use passive or active", s.Banner))
+	} else if s.Banner != "" && s.Protocol == UDP {
+		messages = append(messages, "banners can only be collected from tcp scans")
+	}
+
+	if s.Detect && s.Protocol == UDP {
+		messages = append(messages, "services can only be detected on tcp scans")
+	}
+
+	if s.TLS && s.Protocol == UDP {
+		messages = append(messages, "tls can only be inspected on tcp scans")
+	}
+
+	if s.HTTP && s.Protocol == UDP {
+		messages = append(messages, "http can only be fingerprinted on tcp scans")
+	}
+
+	if s.ProbeDown && !s.Discover {
+		messages = append(messages, "down hosts can only be probed with discovery")
+	}
+
+	if s.Probe != "" && (s.Technique == TechniqueSYN || s.Banner != "" || s.Detect || s.TLS || s.HTTP) {
+		messages = append(messages, fmt.Sprintf("probe %s can not be combined with syn scans, banners, detection, tls or http", s.Probe))
+	}
+
+	for _, name := range s.Scripts {
+		if !ValidScriptName(name) {
+			messages = append(messages, fmt.Sprintf("%s is not a valid script name", name))
+		}
+	}
+
 	if len(messages) > 0 {
-		return false, fmt.Errorf(strings.Join(messages, "\n"))
+		return false, errors.New(strings.Join(messages, "\n"))
 	}
 	return true, nil
 }
 
+//ValidScriptName returns true if name is the plain file name of a script, that can not name a file outside of the
+//script directory
+func ValidScriptName(name string) bool {
+	if len(name) == 0 || len(name) > 64 || name[0] == '.' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+//ScheduleRequest asks a pscan server to scan Scan each time the cron expression Cron comes due
+type ScheduleRequest struct {
+	//Name optionally describes the schedule, e.g nightly dmz sweep
+	Name string `json:"name,omitempty"`
+	//Cron is a cron expression of five fields or an @ shorthand, e.g */30 * * * * or @daily, in the server's time zone
+	Cron string      `json:"cron"`
+	Scan ScanRequest `json:"scan"`
+}
+
+//Validate will validate that the ScheduleRequest is valid, returning an error detailing what is wrong if not
+func (s ScheduleRequest) Validate() (bool, error) {
+	messages := make([]string, 0)
+
+	if len(s.Name) > maxScheduleNameLength {
+		messages = append(messages, fmt.Sprintf("schedule names may be at most %d characters", maxScheduleNameLength))
+	}
+
+	if len(s.Cron) == 0 {
+		messages = append(messages, "you must provide a cron schedule")
+	} else if _, err := cron.Parse(s.Cron); err != nil {
+		messages = append(messages, err.Error())
+	}
+
+	if valid, err := s.Scan.Validate(); !valid {
+		messages = append(messages, err.Error())
+	}
+
+	if len(messages) > 0 {
+		return false, errors.New(strings.Join(messages, "\n"))
+	}
+	return true, nil
+}
+
+const maxScheduleNameLength = 128
+
+//Schedule is a scan a pscan server runs on a cron schedule, with the history of its recent runs
+type Schedule struct {
+	ID      uint64      `json:"id"`
+	Name    string      `json:"name,omitempty"`
+	Cron    string      `json:"cron"`
+	Scan    ScanRequest `json:"scan"`
+	Paused  bool        `json:"paused"`
+	Created time.Time   `json:"created"`
+	//NextRun is when the scan next runs, nil while the schedule is paused
+	NextRun *time.Time `json:"next_run,omitempty"`
+	//Runs are the most recent runs of the schedule, oldest first
+	Runs []ScheduleRun `json:"runs"`
+}
+
+//ScheduleRun is one run of a Schedule
+type ScheduleRun struct {
+	Time time.Time `json:"time"`
+	//ScanID is the scan the run submitted, queried as any other scan. Zero if the run did not submit a scan
+	ScanID uint64 `json:"scan_id,omitempty"`
+	//Error is why the run did not submit a scan, e.g its targets are no longer allowed by policy
+	Error string `json:"error,omitempty"`
+}
+
+//ScheduleIDRequest names the schedule to pause, resume or delete
+type ScheduleIDRequest struct {
+	ID uint64 `json:"id"`
+}
+
+//ScheduleListResponse holds every schedule of a pscan server
+type ScheduleListResponse struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
 type ScanResponse struct {
 	ScanID uint64 `json:"id"`
 }
 
 type QueryRequest struct {
 	ScanID uint64 `json:"id"`
+	//Service and Product, if given, keep only statuses whose detected service or product contains them, ignoring case
+	Service string `json:"service,omitempty"`
+	Product string `json:"product,omitempty"`
+}
+
+//Matches returns true if status passes the Service and Product filters of q
+func (q QueryRequest) Matches(status IPStatus) bool {
+	if len(q.Service) == 0 && len(q.Product) == 0 {
+		return true
+	} else if status.Service == nil {
+		return false
+	}
+	return containsFold(status.Service.Name, q.Service) && containsFold(status.Service.Product, q.Product)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
 
 type QueryResponse struct {
 	Ready    bool       `json:"ready"`
 	ScanPort uint       `json:"port"`
+	Protocol string     `json:"protocol,omitempty"`
+	Source   string     `json:"source,omitempty"`
 	Status   []IPStatus `json:"status"`
+	//Baseline is the evaluation of Status against the baseline of the server, if it has one
+	Baseline *BaselineResult `json:"baseline,omitempty"`
+}
+
+//ExportRecord is written by a pscan server's exporters for each scan as it completes
+type ExportRecord struct {
+	ScanID uint64 `json:"scan_id"`
+	//Schedule is the schedule the scan was a run of, if any
+	Schedule  uint64    `json:"schedule_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Completed time.Time `json:"completed"`
+	QueryResponse
+}
+
+//ExportStatus is the status of one address of a completed scan, as a syslog exporter sends it, a message an address
+type ExportStatus struct {
+	ScanID uint64 `json:"scan_id"`
+	//Schedule is the schedule the scan was a run of, if any
+	Schedule  uint64    `json:"schedule_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Completed time.Time `json:"completed"`
+	ScanPort  uint      `json:"port"`
+	Protocol  string    `json:"protocol,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	IPStatus
+}
+
+//BaselineResult is the evaluation of a scan against the exposure a baseline expects of its hosts
+type BaselineResult struct {
+	//Passed is true when at least one address was Evaluated, and there are no Violations
+	Passed bool `json:"passed"`
+	//Evaluated is the number of addresses a baseline rule applied to
+	Evaluated  int                 `json:"evaluated"`
+	Violations []BaselineViolation `json:"violations"`
+}
+
+//BaselineViolation is an address whose port is not in the state its baseline rule expects
+type BaselineViolation struct {
+	IP     string `json:"ip"`
+	Target string `json:"target,omitempty"`
+	Rule   string `json:"rule"`
+	State  State  `json:"state"`
+	Reason string `json:"reason"`
+}
+
+//DiffRequest asks how the results of scan To differ from those of scan From, two scans of the same port
+type DiffRequest struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+//DiffResponse holds a PortDiff for each address whose port differs between two scans, ordered by address
+type DiffResponse struct {
+	From     uint64     `json:"from"`
+	To       uint64     `json:"to"`
+	ScanPort uint       `json:"port"`
+	Protocol string     `json:"protocol,omitempty"`
+	Changes  []PortDiff `json:"changes"`
+}
+
+const (
+	//DiffOpened is a port open in the later scan that was not in the earlier
+	DiffOpened = "opened"
+	//DiffClosed is a port open in the earlier scan that is not in the later
+	DiffClosed = "closed"
+	//DiffState is a port whose state changed, but was open in neither scan
+	DiffState = "state"
+	//DiffChanged is a port open in both scans, where what was collected from it differs
+	DiffChanged = "changed"
+)
+
+//PortDiff is how the port of one address differs between two scans
+type PortDiff struct {
+	IP string `json:"ip"`
+	//Target is the cidr or hostname IP was scanned for, when it was not given as an ip address
+	Target string `json:"target,omitempty"`
+	//Change is DiffOpened, DiffClosed, DiffState or DiffChanged
+	Change string `json:"change"`
+	//FromState and ToState are the states of the port in each scan, empty if the scan did not include the address
+	FromState State `json:"from_state,omitempty"`
+	ToState   State `json:"to_state,omitempty"`
+	//Fields are what was collected from the port that differs, for ports open in both scans
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+//FieldDiff is something collected from an open port that differs between two scans
+type FieldDiff struct {
+	//Field is banner, service, tls, certificate or http
+	Field string `json:"field"`
+	//From and To describe the field in each scan, empty if it was not collected
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+const (
+	//AlertPortOpened is a port open that was not in the previous run of a schedule
+	AlertPortOpened = "port_opened"
+	//AlertPortClosed is a port not open that was in the previous run of a schedule
+	AlertPortClosed = "port_closed"
+	//AlertCertExpiring is a certificate presented by an open port that expires within the window of its alert rule
+	AlertCertExpiring = "cert_expiring"
+	//AlertHostDown is a host that did not answer a ping, when discovery was requested
+	AlertHostDown = "host_down"
+)
+
+//Alert is one event in the results of a completed scan that an alert rule asks to be told of
+type Alert struct {
+	Event   string `json:"event"`
+	IP      string `json:"ip"`
+	Target  string `json:"target,omitempty"`
+	Message string `json:"message"`
+}
+
+//AlertNotification is sent to the sinks of an alert rule, with the alerts of one completed scan
+type AlertNotification struct {
+	Rule     string `json:"rule"`
+	ScanID   uint64 `json:"scan_id"`
+	ScanPort uint   `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+	//Schedule is the schedule the scan was a run of, and PreviousScanID the scan of its run the results were compared to
+	Schedule       uint64    `json:"schedule_id,omitempty"`
+	PreviousScanID uint64    `json:"previous_scan_id,omitempty"`
+	Time           time.Time `json:"time"`
+	Alerts         []Alert   `json:"alerts"`
 }
 
 type IPStatus struct {
 	IP    string `json:"ip"`
 	State State  `json:"state"`
+	//Target is the cidr or hostname IP was scanned for, when it was not given as an ip address
+	Target string `json:"target,omitempty"`
+	//Banner is what an open port sent, as printable ascii with other bytes escaped, when banners were requested
+	Banner string `json:"banner,omitempty"`
+	//Service is what was detected on an open port, when detection was requested and a probe matched
+	Service *Service `json:"service,omitempty"`
+	//TLS is what a tls handshake with an open port found, when tls inspection was requested and the port speaks tls
+	TLS *TLSInfo `json:"tls,omitempty"`
+	//HTTP is what a request to an open port found, when http fingerprinting was requested and the port speaks http
+	HTTP *HTTPInfo `json:"http,omitempty"`
+	//Host is whether the host answered a ping, when discovery was requested
+	Host HostState `json:"host,omitempty"`
+	//HostReason is the first ping the host answered, e.g icmp echo or tcp syn 443
+	HostReason string `json:"host_reason,omitempty"`
+	//MAC is the hardware address of a host on a local subnet, when discovery found it by arp
+	MAC string `json:"mac,omitempty"`
+	//Scripts are what each script requested found of an open port, in the order they ran
+	Scripts []ScriptResult `json:"scripts,omitempty"`
+}
+
+//ScriptResult is what one script printed when run against an open port
+type ScriptResult struct {
+	Name string `json:"name"`
+	//Output is the json object the script printed, if any
+	Output json.RawMessage `json:"output,omitempty"`
+	//Error is why the script did not run to completion, or its output could not be used
+	Error string `json:"error,omitempty"`
+}
+
+//HTTPInfo describes the response to a request for / on a port, after following redirects that stay on the port
+type HTTPInfo struct {
+	//URL is the last url requested, either http or https
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Server     string `json:"server,omitempty"`
+	Title      string `json:"title,omitempty"`
+	//Redirects are the locations redirected to, in order. The last is not followed if it leaves the port
+	Redirects []string `json:"redirects,omitempty"`
+	//SecurityHeaders are those of the last response among e.g Strict-Transport-Security and Content-Security-Policy
+	SecurityHeaders map[string]string `json:"security_headers,omitempty"`
+}
+
+//Service identifies what is listening on a port. Product and Version are empty if only the kind of service is known
+type Service struct {
+	Name    string `json:"name"`
+	Product string `json:"product,omitempty"`
+	Version string `json:"version,omitempty"`
+	Info    string `json:"info,omitempty"`
+}
+
+//TLSInfo describes the tls an open port speaks
+type TLSInfo struct {
+	//Version and CipherSuite were negotiated offering every version and cipher suite pscan supports, e.g TLS 1.3
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	//Versions are those the port completed a handshake with, oldest first
+	Versions    []string     `json:"versions"`
+	Certificate *Certificate `json:"certificate,omitempty"`
+}
+
+//Certificate describes the leaf certificate a port presented. It is recorded whether or not it would be trusted
+type Certificate struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+	//SANs are the dns names, ip addresses, email addresses and uris the certificate is valid for
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	//KeyType is RSA, ECDSA or Ed25519, and KeyBits the size of the key
+	KeyType            string `json:"key_type"`
+	KeyBits            int    `json:"key_bits"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	SelfSigned         bool   `json:"self_signed"`
+	//Fingerprint is the hex sha256 of the certificate
+	Fingerprint string `json:"fingerprint"`
 }
 
 type State string
@@ -63,4 +459,41 @@ type State string
 const (
 	OPEN   State = "open"
 	CLOSED State = "closed"
+	//OPEN_FILTERED is a udp port that did not reply, which may be open or behind a firewall that drops probes
+	OPEN_FILTERED State = "open|filtered"
+	//SKIPPED is a port that was not probed, as discovery found its host down
+	SKIPPED State = "skipped"
 )
+
+type HostState string
+
+const (
+	HOST_UP   HostState = "up"
+	HOST_DOWN HostState = "down"
+)
+
+//HealthResponse is returned by a pscan server's liveness endpoint
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+//ReadinessResponse is returned by a pscan server's readiness endpoint
+//Ready is true only if every check passed
+type ReadinessResponse struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+//VersionResponse is the build metadata of a pscan server
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
@@ -61,3 +61,165 @@ func TestScanRequest_Validate(t *testing.T) {
 	assert.True(t, valid)
 	assert.Nil(t, err)
 }
+
+func TestScanRequest_Validate_Targets(t *testing.T) {
+	s := ScanRequest{
+		ScanIPs: []string{
+			"2001:db8::1",
+			"fe80::1%eth0",
+			"10.0.0.0/30",
+			"2001:db8::/126",
+			"scanme.example.com",
+		},
+		ScanPort: 8080,
+	}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.ScanIPs = []string{"10.0.0.0/33", "10.0.0.1%eth0", "not a host"}
+	valid, err = s.Validate()
+	assert.False(t, valid)
+	assert.EqualError(t, err, "10.0.0.0/33 is not a valid cidr\n10.0.0.1%eth0 is not a valid ip address, cidr or hostname\nnot a host is not a valid ip address, cidr or hostname")
+}
+
+func TestScanRequest_Validate_Protocol(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 53}
+	for _, protocol := range []string{"", TCP, UDP} {
+		s.Protocol = protocol
+		valid, err := s.Validate()
+		assert.True(t, valid)
+		assert.Nil(t, err)
+	}
+	s.Protocol = "sctp"
+	valid, err := s.Validate()
+	assert.False(t, valid)
+	assert.EqualError(t, err, "sctp is not a supported protocol, use tcp or udp")
+}
+
+func TestScanRequest_Validate_Banner(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 22, Banner: BannerActive}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Banner = "loud"
+	_, err = s.Validate()
+	assert.EqualError(t, err, "loud is not a banner mode, use passive or active")
+
+	s.Banner, s.Protocol = BannerPassive, UDP
+	_, err = s.Validate()
+	assert.EqualError(t, err, "banners can only be collected from tcp scans")
+}
+
+func TestScanRequest_Validate_Detect(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 22, Detect: true}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Protocol = UDP
+	_, err = s.Validate()
+	assert.EqualError(t, err, "services can only be detected on tcp scans")
+}
+
+func TestQueryRequest_Matches(t *testing.T) {
+	ssh := IPStatus{IP: "10.0.0.1", State: OPEN, Service: &Service{Name: "ssh", Product: "OpenSSH", Version: "8.9p1"}}
+	closed := IPStatus{IP: "10.0.0.2", State: CLOSED}
+	assert.True(t, QueryRequest{}.Matches(closed))
+	assert.True(t, QueryRequest{Service: "SSH"}.Matches(ssh))
+	assert.True(t, QueryRequest{Service: "ssh", Product: "openssh"}.Matches(ssh))
+	assert.False(t, QueryRequest{Service: "ssh", Product: "dropbear"}.Matches(ssh))
+	assert.False(t, QueryRequest{Service: "ssh"}.Matches(closed))
+}
+
+func TestScanRequest_Validate_TLS(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 443, TLS: true}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Protocol = UDP
+	_, err = s.Validate()
+	assert.EqualError(t, err, "tls can only be inspected on tcp scans")
+}
+
+func TestScanRequest_Validate_HTTP(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 80, HTTP: true}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Protocol = UDP
+	_, err = s.Validate()
+	assert.EqualError(t, err, "http can only be fingerprinted on tcp scans")
+}
+
+func TestScanRequest_Validate_Technique(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 22}
+	for _, technique := range []string{"", TechniqueConnect, TechniqueSYN} {
+		s.Technique = technique
+		valid, err := s.Validate()
+		assert.True(t, valid)
+		assert.Nil(t, err)
+	}
+	s.Technique = "fin"
+	_, err := s.Validate()
+	assert.EqualError(t, err, "fin is not a scan technique, use connect or syn")
+
+	s.Technique, s.Protocol = TechniqueSYN, UDP
+	_, err = s.Validate()
+	assert.EqualError(t, err, "syn scans can only be made of tcp ports")
+}
+
+func TestScanRequest_Validate_Discover(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 53, Protocol: UDP, Discover: true, ProbeDown: true}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Discover = false
+	_, err = s.Validate()
+	assert.EqualError(t, err, "down hosts can only be probed with discovery")
+}
+
+func TestScanRequest_Validate_Probe(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 7000, Probe: "acme-hello", Discover: true}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Technique = TechniqueSYN
+	_, err = s.Validate()
+	assert.EqualError(t, err, "probe acme-hello can not be combined with syn scans, banners, detection, tls or http")
+
+	s.Technique, s.Detect = "", true
+	_, err = s.Validate()
+	assert.EqualError(t, err, "probe acme-hello can not be combined with syn scans, banners, detection, tls or http")
+}
+
+func TestScanRequest_Validate_Scripts(t *testing.T) {
+	s := ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 21, Scripts: []string{"ftp-anon", "smb_os.py"}}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s.Scripts = []string{"../../bin/sh", ".profile", ""}
+	_, err = s.Validate()
+	assert.EqualError(t, err, "../../bin/sh is not a valid script name\n.profile is not a valid script name\n is not a valid script name")
+}
+
+func TestScheduleRequest_Validate(t *testing.T) {
+	s := ScheduleRequest{Cron: "*/30 * * * *", Scan: ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 22}}
+	valid, err := s.Validate()
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	s = ScheduleRequest{Name: strings.Repeat("n", 129), Cron: "@fortnightly", Scan: ScanRequest{ScanIPs: []string{"127.0.0.1"}}}
+	_, err = s.Validate()
+	assert.EqualError(t, err, "schedule names may be at most 128 characters\ncron schedule @fortnightly must have 5 fields, minute hour day-of-month month day-of-week\n0 is not a valid port number")
+
+	s = ScheduleRequest{Scan: ScanRequest{ScanIPs: []string{"127.0.0.1"}, ScanPort: 22}}
+	_, err = s.Validate()
+	assert.EqualError(t, err, "you must provide a cron schedule")
+}